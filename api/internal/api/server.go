@@ -1,25 +1,42 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"linker/internal/config"
 	"linker/internal/database"
+	"linker/internal/events"
 	"linker/internal/handlers"
+	"linker/internal/healthcheck"
 	"linker/internal/middleware"
+	"linker/internal/pages"
+	"linker/internal/shortcode"
 	"linker/internal/storage"
+	"linker/internal/trash"
+	"linker/internal/urlpolicy"
 )
 
 type Server struct {
-	config      *config.Config
-	db          *database.Database
-	router      *gin.Engine
-	rateLimiter *middleware.RateLimiter
+	config        *config.Config
+	db            *database.Database
+	router        *gin.Engine
+	rateLimiter   *middleware.RateLimiter
+	healthChecker *healthcheck.Checker
+	trashPurger   *trash.Purger
+	events        *events.Recorder
+	httpServer    *http.Server
 }
 
+// shutdownTimeout bounds how long Shutdown waits for in-flight requests
+const shutdownTimeout = 10 * time.Second
+
 func NewServer(config *config.Config, db *database.Database) *Server {
 	if config.Environment == "production" {
 		gin.SetMode(gin.ReleaseMode)
@@ -32,6 +49,19 @@ func NewServer(config *config.Config, db *database.Database) *Server {
 		db:          db,
 		router:      router,
 		rateLimiter: middleware.NewRateLimiter(),
+		events:      events.NewRecorder(db, config),
+	}
+	server.httpServer = &http.Server{
+		Addr:    fmt.Sprintf(":%s", config.Port),
+		Handler: router,
+	}
+
+	if config.HealthCheck.Enabled {
+		server.healthChecker = healthcheck.NewChecker(db, config)
+	}
+
+	if rc := config.RedirectCache; rc.Enabled {
+		db.EnableRedirectCache(rc.Size, time.Duration(rc.TTLSeconds)*time.Second, time.Duration(rc.NegativeTTLSeconds)*time.Second)
 	}
 	
 	server.setupMiddleware()
@@ -48,10 +78,35 @@ func (s *Server) setupMiddleware() {
 
 func (s *Server) setupRoutes() {
 	authHandler := handlers.NewAuthHandler(s.db, s.config.JWTSecret)
-	linksHandler := handlers.NewLinksHandler(s.db)
-	redirectHandler := handlers.NewRedirectHandler(s.db, s.config.Analytics)
+
+	generator, err := shortcode.NewGenerator(&s.config.ShortCodes)
+	if err != nil {
+		log.Printf("Invalid short code settings, using defaults: %v", err)
+		defaults := config.DefaultShortCodeConfig()
+		generator, _ = shortcode.NewGenerator(&defaults)
+	}
+
+	blocklist, err := shortcode.NewBlocklist(&s.config.ShortCodes)
+	if err != nil {
+		log.Printf("Failed to load short code denylist: %v", err)
+		blocklist, _ = shortcode.NewBlocklist(&config.ShortCodeConfig{Reserved: s.config.ShortCodes.Reserved})
+	}
+
+	urlPolicy, err := urlpolicy.New(s.config)
+	if err != nil {
+		log.Printf("Failed to load destination host denylist: %v", err)
+		withoutDenylist := *s.config
+		withoutDenylist.URLPolicy.DenylistFile = ""
+		urlPolicy, _ = urlpolicy.New(&withoutDenylist)
+	}
+
+	linksHandler := handlers.NewLinksHandler(s.db, generator, blocklist, urlPolicy, s.config)
+	renderer := pages.NewRenderer(s.config)
+	redirectHandler := handlers.NewRedirectHandler(s.db, renderer, s.events, s.config)
 	analyticsHandler := handlers.NewAnalyticsHandler(s.db)
 	tokensHandler := handlers.NewTokensHandler(s.db)
+	domainsHandler := handlers.NewDomainsHandler(s.db, urlPolicy, s.config)
+	searchHandler := handlers.NewSearchHandler(s.db)
 	
 	// Initialize S3 client if configured
 	var s3Client *storage.S3Client
@@ -64,7 +119,12 @@ func (s *Server) setupRoutes() {
 		}
 	}
 	
-	filesHandler := handlers.NewFilesHandler(s.db, s3Client, s.config)
+	filesHandler := handlers.NewFilesHandler(s.db, s3Client, generator, blocklist, renderer, s.events, s.config)
+	pagesHandler := handlers.NewPagesHandler(s.db, renderer)
+
+	s.trashPurger = trash.NewPurger(s.db, s3Client, s.config)
+	trashHandler := handlers.NewTrashHandler(s.db, s.trashPurger)
+	adminHandler := handlers.NewAdminHandler(s.db, s.events, s.config)
 
 	api := s.router.Group("/api/v1")
 	{
@@ -83,16 +143,40 @@ func (s *Server) setupRoutes() {
 			tokens.DELETE("/:id", tokensHandler.DeleteToken)
 		}
 
+		domains := api.Group("/domains")
+		domains.Use(middleware.AuthMiddleware(s.config.JWTSecret))
+		{
+			domains.POST("", domainsHandler.CreateDomain)
+			domains.GET("", domainsHandler.GetDomains)
+			domains.GET("/:id", domainsHandler.GetDomain)
+			domains.PUT("/:id", domainsHandler.UpdateDomain)
+			domains.DELETE("/:id", domainsHandler.DeleteDomain)
+		}
+
 		links := api.Group("/links")
-		links.Use(middleware.AuthMiddleware(s.config.JWTSecret))
+		links.Use(middleware.AuthMiddlewareWithAPITokens(s.config.JWTSecret, s.db))
 		{
 			links.POST("", linksHandler.CreateLink)
 			links.GET("", linksHandler.GetUserLinks)
+			links.GET("/broken", linksHandler.GetBrokenLinks)
 			links.GET("/:id", linksHandler.GetLink)
+			links.GET("/:id/preview", linksHandler.PreviewLink)
+			links.GET("/:id/qr", linksHandler.GetLinkQR)
+			links.GET("/:id/history", linksHandler.GetLinkHistory)
+			links.POST("/:id/rollback", linksHandler.RollbackLink)
+			links.POST("/:id/short-codes", linksHandler.AddShortCode)
+			links.DELETE("/:id/short-codes/:shortCode", linksHandler.DeleteShortCode)
+			links.PUT("/:id/short-codes/:shortCode/primary", linksHandler.SetPrimaryShortCode)
 			links.PUT("/:id", linksHandler.UpdateLink)
 			links.DELETE("/:id", linksHandler.DeleteLink)
 		}
 
+		search := api.Group("/search")
+		search.Use(middleware.AuthMiddleware(s.config.JWTSecret))
+		{
+			search.GET("", searchHandler.Search)
+		}
+
 		analytics := api.Group("/analytics")
 		analytics.Use(middleware.AuthMiddleware(s.config.JWTSecret))
 		{
@@ -114,30 +198,96 @@ func (s *Server) setupRoutes() {
 			files.PUT("/:id", filesHandler.UpdateFile)
 			files.DELETE("/:id", filesHandler.DeleteFile)
 			files.GET("/:id/analytics", filesHandler.GetFileAnalytics)
+			files.GET("/:id/qr", filesHandler.GetFileQR)
+			files.POST("/:id/short-codes", filesHandler.AddShortCode)
+			files.DELETE("/:id/short-codes/:shortCode", filesHandler.DeleteShortCode)
+			files.PUT("/:id/short-codes/:shortCode/primary", filesHandler.SetPrimaryShortCode)
+		}
+
+		trashBin := api.Group("/trash")
+		trashBin.Use(middleware.AuthMiddlewareWithAPITokens(s.config.JWTSecret, s.db))
+		{
+			trashBin.GET("", trashHandler.GetTrash)
+			trashBin.DELETE("", trashHandler.EmptyTrash)
+			trashBin.POST("/links/:id/restore", trashHandler.RestoreLink)
+			trashBin.DELETE("/links/:id", trashHandler.PurgeLink)
+			trashBin.POST("/files/:id/restore", trashHandler.RestoreFile)
+			trashBin.DELETE("/files/:id", trashHandler.PurgeFile)
+		}
+
+		admin := api.Group("/admin")
+		admin.Use(middleware.AuthMiddlewareWithAPITokens(s.config.JWTSecret, s.db))
+		{
+			admin.GET("/stats", adminHandler.Stats)
 		}
 	}
 
 	// Setup redirect route with configurable prefix
 	prefixPattern := fmt.Sprintf("/%s/:shortCode", s.config.LinkPrefix)
+	// Each password attempt costs a bcrypt comparison, so guesses are limited
+	unlockLimit := s.rateLimiter.Limit("unlock", 10, 5*time.Minute, "Too many password attempts. Please try again later.")
 	s.router.GET(prefixPattern, redirectHandler.Redirect)
+	s.router.POST(prefixPattern, unlockLimit, redirectHandler.Unlock)
+	// Catch-all for links that forward extra path segments
+	s.router.GET(prefixPattern+"/*path", redirectHandler.Redirect)
+	s.router.POST(prefixPattern+"/*path", unlockLimit, redirectHandler.Unlock)
 	
 	// Setup public file download route with configurable prefix
 	filePrefixPattern := fmt.Sprintf("/%s/:shortCode", s.config.FilePrefix)
 	s.router.GET(filePrefixPattern, filesHandler.DownloadFile)
+	s.router.POST(filePrefixPattern, unlockLimit, filesHandler.UnlockFile)
 	
 	s.router.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{"status": "ok"})
 	})
+
+	s.router.GET("/", pagesHandler.Landing)
+	s.router.NoRoute(pagesHandler.NotFound)
+
+	// Keep every static route segment free so short codes never shadow them
+	for _, route := range s.router.Routes() {
+		for _, segment := range strings.Split(route.Path, "/") {
+			if segment != "" && !strings.HasPrefix(segment, ":") && !strings.HasPrefix(segment, "*") {
+				blocklist.Reserve(segment)
+			}
+		}
+	}
 }
 
+// Handler returns the server's routes, for serving them without Start
+func (s *Server) Handler() http.Handler {
+	return s.router
+}
+
+// Start serves requests until Shutdown is called, when it returns nil
 func (s *Server) Start() error {
-	addr := fmt.Sprintf(":%s", s.config.Port)
-	log.Printf("Server starting on %s", addr)
-	return s.router.Run(addr)
+	if s.healthChecker != nil {
+		s.healthChecker.Start()
+	}
+	s.trashPurger.Start()
+	s.events.Start()
+	log.Printf("Server starting on %s", s.httpServer.Addr)
+	if err := s.httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
 }
 
+// Shutdown stops accepting requests, waits for those in flight, then stops
+// the background workers, writing any clicks and downloads still queued
 func (s *Server) Shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := s.httpServer.Shutdown(ctx); err != nil {
+		log.Printf("Failed to finish in-flight requests: %v", err)
+	}
+
 	if s.rateLimiter != nil {
 		s.rateLimiter.Stop()
 	}
+	if s.healthChecker != nil {
+		s.healthChecker.Stop()
+	}
+	s.trashPurger.Stop()
+	s.events.Stop()
 }
\ No newline at end of file
@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GenerateUnlockToken signs a token proving the holder entered the password
+// for resourceID. It is stored in a cookie so the visitor isn't asked again
+// until the token expires. The token is bound to passwordHash, so changing
+// the password invalidates tokens already issued.
+func GenerateUnlockToken(resourceID, passwordHash string, expiresAt time.Time, secret string) string {
+	expiry := strconv.FormatInt(expiresAt.Unix(), 10)
+	return expiry + "." + unlockSignature(resourceID, passwordHash, expiry, secret)
+}
+
+// ValidateUnlockToken checks the signature and expiry of an unlock token
+// against the resource's current password hash
+func ValidateUnlockToken(token, resourceID, passwordHash, secret string) bool {
+	expiry, signature, found := strings.Cut(token, ".")
+	if !found {
+		return false
+	}
+
+	expiresAt, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		return false
+	}
+
+	expected := unlockSignature(resourceID, passwordHash, expiry, secret)
+	return hmac.Equal([]byte(signature), []byte(expected))
+}
+
+func unlockSignature(resourceID, passwordHash, expiry, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "unlock|%s|%s|%s", resourceID, passwordHash, expiry)
+	return hex.EncodeToString(mac.Sum(nil))
+}
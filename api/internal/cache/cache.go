@@ -0,0 +1,185 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Stats are a cache's counters, used to size it
+type Stats struct {
+	Size          int     `json:"size"`
+	Capacity      int     `json:"capacity"`
+	Hits          uint64  `json:"hits"`
+	NegativeHits  uint64  `json:"negative_hits"` // Hits on a remembered miss
+	Misses        uint64  `json:"misses"`
+	HitRate       float64 `json:"hit_rate"`
+	Evictions     uint64  `json:"evictions"`     // Entries dropped to make room
+	Expirations   uint64  `json:"expirations"`   // Entries dropped when their time ran out
+	Invalidations uint64  `json:"invalidations"` // Entries dropped because what they held changed
+}
+
+type entry struct {
+	key       string
+	value     interface{} // nil for a remembered miss
+	tags      []string
+	expiresAt time.Time
+}
+
+// Cache is a size bounded LRU cache whose entries also expire. It can
+// remember misses, and entries carry tags so everything derived from one
+// record can be invalidated together.
+type Cache struct {
+	capacity    int
+	ttl         time.Duration
+	negativeTTL time.Duration
+
+	mu      sync.Mutex
+	order   *list.List // Most recently used first
+	entries map[string]*list.Element
+	tagged  map[string]map[*list.Element]struct{}
+	// Bumped by every invalidation so a value loaded before one is not
+	// cached after it
+	generation uint64
+
+	hits, negativeHits, misses            uint64
+	evictions, expirations, invalidations uint64
+}
+
+// New creates a cache holding up to capacity entries. Values are kept for
+// ttl and misses for negativeTTL; a zero negativeTTL disables negative
+// caching.
+func New(capacity int, ttl, negativeTTL time.Duration) *Cache {
+	return &Cache{
+		capacity:    capacity,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		order:       list.New(),
+		entries:     make(map[string]*list.Element),
+		tagged:      make(map[string]map[*list.Element]struct{}),
+	}
+}
+
+// Get returns the value cached under key. found is false when nothing is
+// cached; a remembered miss is found with a nil value.
+func (c *Cache) Get(key string) (value interface{}, found bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+
+	e := elem.Value.(*entry)
+	if !time.Now().Before(e.expiresAt) {
+		c.remove(elem)
+		c.expirations++
+		c.misses++
+		return nil, false
+	}
+
+	c.order.MoveToFront(elem)
+	if e.value == nil {
+		c.negativeHits++
+	} else {
+		c.hits++
+	}
+	return e.value, true
+}
+
+// Generation is read before loading a value so Set can tell whether an
+// invalidation happened while it was being loaded
+func (c *Cache) Generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// Set caches value under key until the ttl passes or expiresAt, whichever
+// is sooner; a zero expiresAt uses the ttl alone. A nil value remembers a
+// miss. Nothing is cached if anything was invalidated since generation.
+func (c *Cache) Set(key string, value interface{}, tags []string, expiresAt time.Time, generation uint64) {
+	ttl := c.ttl
+	if value == nil {
+		ttl = c.negativeTTL
+	}
+	if ttl <= 0 || c.capacity <= 0 {
+		return
+	}
+
+	deadline := time.Now().Add(ttl)
+	if !expiresAt.IsZero() && expiresAt.Before(deadline) {
+		deadline = expiresAt
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	for c.order.Len() >= c.capacity {
+		c.remove(c.order.Back())
+		c.evictions++
+	}
+
+	elem := c.order.PushFront(&entry{key: key, value: value, tags: tags, expiresAt: deadline})
+	c.entries[key] = elem
+	for _, tag := range tags {
+		if c.tagged[tag] == nil {
+			c.tagged[tag] = make(map[*list.Element]struct{})
+		}
+		c.tagged[tag][elem] = struct{}{}
+	}
+}
+
+// Invalidate drops every entry carrying tag
+func (c *Cache) Invalidate(tag string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	for elem := range c.tagged[tag] {
+		c.remove(elem)
+		c.invalidations++
+	}
+}
+
+// Stats returns the cache's counters so far
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := Stats{
+		Size:          c.order.Len(),
+		Capacity:      c.capacity,
+		Hits:          c.hits,
+		NegativeHits:  c.negativeHits,
+		Misses:        c.misses,
+		Evictions:     c.evictions,
+		Expirations:   c.expirations,
+		Invalidations: c.invalidations,
+	}
+	if lookups := c.hits + c.negativeHits + c.misses; lookups > 0 {
+		stats.HitRate = float64(c.hits+c.negativeHits) / float64(lookups)
+	}
+	return stats
+}
+
+func (c *Cache) remove(elem *list.Element) {
+	e := elem.Value.(*entry)
+	c.order.Remove(elem)
+	delete(c.entries, e.key)
+	for _, tag := range e.tags {
+		delete(c.tagged[tag], elem)
+		if len(c.tagged[tag]) == 0 {
+			delete(c.tagged, tag)
+		}
+	}
+}
@@ -10,17 +10,28 @@ import (
 )
 
 type Config struct {
-	Port           string   `json:"port"`
-	DatabaseURL    string   `json:"database_url"`
-	DefaultDomain  string   `json:"default_domain"`
-	AllowedDomains []string `json:"allowed_domains"`
-	UnifiedPrefix  string   `json:"unified_prefix,omitempty"`
-	LinkPrefix     string   `json:"link_prefix,omitempty"`
-	FilePrefix     string   `json:"file_prefix,omitempty"`
-	JWTSecret      string   `json:"jwt_secret"`
-	Analytics      bool     `json:"analytics"`
-	Environment    string   `json:"environment"`
-	S3             S3Config `json:"s3"`
+	Port           string            `json:"port"`
+	DatabaseURL    string            `json:"database_url"`
+	DefaultDomain  string            `json:"default_domain"`
+	AllowedDomains []string          `json:"allowed_domains"`
+	UnifiedPrefix  string            `json:"unified_prefix,omitempty"`
+	LinkPrefix     string            `json:"link_prefix,omitempty"`
+	FilePrefix     string            `json:"file_prefix,omitempty"`
+	JWTSecret      string            `json:"jwt_secret"`
+	Analytics      bool              `json:"analytics"`
+	Environment    string            `json:"environment"`
+	S3             S3Config          `json:"s3"`
+	ShortCodes     ShortCodeConfig   `json:"short_codes"`
+	AdminUsers     []string          `json:"admin_users"` // Usernames allowed to bypass short code restrictions
+	// IPs or CIDR ranges of the proxies in front of the server. Their country
+	// headers are trusted; from anyone else those headers are ignored.
+	TrustedProxies []string          `json:"trusted_proxies"`
+	URLPolicy      URLPolicyConfig   `json:"url_policy"`
+	HealthCheck    HealthCheckConfig `json:"health_check"`
+	Trash          TrashConfig       `json:"trash"`
+	Pages          PagesConfig       `json:"pages"`
+	RedirectCache  CacheConfig       `json:"redirect_cache"`
+	Events         EventsConfig      `json:"events"`
 }
 
 type S3Config struct {
@@ -35,6 +46,148 @@ type S3Config struct {
 	AllowedMimeTypes []string `json:"allowed_mime_types"`
 }
 
+// ShortCodeConfig controls how short codes are generated when none are given
+type ShortCodeConfig struct {
+	Length     int    `json:"length"`
+	MaxLength  int    `json:"max_length"`
+	Alphabet   string `json:"alphabet"` // base62, lowercase or unambiguous
+	LinkPrefix string `json:"link_prefix"`
+	FilePrefix string `json:"file_prefix"`
+	Attempts   int    `json:"attempts"` // Collisions tolerated before growing the length
+
+	// Codes users may not claim, on top of the built-in and route entries
+	Reserved []string `json:"reserved"`
+	// File with one blocked word per line
+	DenylistFile string `json:"denylist_file"`
+}
+
+// DefaultShortCodeConfig returns 8 character lowercase codes that may grow to
+// 12 characters, with files keeping their historical "f-" prefix
+func DefaultShortCodeConfig() ShortCodeConfig {
+	return ShortCodeConfig{
+		Length:     8,
+		MaxLength:  12,
+		Alphabet:   "lowercase",
+		FilePrefix: "f-",
+		Attempts:   3,
+	}
+}
+
+// URLPolicyConfig restricts where links may point
+type URLPolicyConfig struct {
+	AllowedSchemes []string `json:"allowed_schemes"`
+	// Hosts-file formatted list of blocked destination hosts
+	DenylistFile string `json:"denylist_file"`
+	// Most of our own short links a destination may redirect through
+	MaxChainHops int `json:"max_chain_hops"`
+	// Reject loopback, RFC1918 and link-local destinations
+	BlockPrivateNetworks bool `json:"block_private_networks"`
+}
+
+// DefaultURLPolicyConfig allows http and https destinations that go through
+// at most one other short link
+func DefaultURLPolicyConfig() URLPolicyConfig {
+	return URLPolicyConfig{
+		AllowedSchemes: []string{"http", "https"},
+		MaxChainHops:   1,
+	}
+}
+
+// HealthCheckConfig controls the background checker that requests each
+// link's destination to find dead links
+type HealthCheckConfig struct {
+	Enabled         bool `json:"enabled"`
+	IntervalMinutes int  `json:"interval_minutes"` // How often to look for links due a check
+	RecheckHours    int  `json:"recheck_hours"`    // How old a result must be before it is checked again
+	BatchSize       int  `json:"batch_size"`       // Most links checked per pass
+	Concurrency     int  `json:"concurrency"`
+	PerHostDelayMs  int  `json:"per_host_delay_ms"` // Minimum gap between requests to one host
+	TimeoutSeconds  int  `json:"timeout_seconds"`
+	// Lets the checker connect to loopback, private and link-local
+	// addresses. Any user can point a link at one, so only enable this when
+	// every user is trusted.
+	AllowPrivateNetworks bool `json:"allow_private_networks"`
+}
+
+// DefaultHealthCheckConfig leaves the checker off, since it makes outbound
+// requests to every destination users add; HEALTH_CHECK_ENABLED=true turns
+// it on. Once on, it checks every link about once a day, at most 8 at a time
+// and one request per second to any host.
+func DefaultHealthCheckConfig() HealthCheckConfig {
+	return HealthCheckConfig{
+		Enabled:         false,
+		IntervalMinutes: 15,
+		RecheckHours:    24,
+		BatchSize:       500,
+		Concurrency:     8,
+		PerHostDelayMs:  1000,
+		TimeoutSeconds:  10,
+	}
+}
+
+// TrashConfig controls how long deleted links and files can be restored
+type TrashConfig struct {
+	RetentionDays        int `json:"retention_days"`         // Days before trashed items are purged
+	PurgeIntervalMinutes int `json:"purge_interval_minutes"` // How often to look for items to purge
+}
+
+// DefaultTrashConfig keeps deleted items for 30 days, purging hourly
+func DefaultTrashConfig() TrashConfig {
+	return TrashConfig{
+		RetentionDays:        30,
+		PurgeIntervalMinutes: 60,
+	}
+}
+
+// PagesConfig controls the HTML pages shown to visitors in place of JSON
+// errors, such as the not-found and password pages
+type PagesConfig struct {
+	// Templates overriding the built-in ones, with a subdirectory per domain
+	// for domain specific templates
+	TemplatesDir string `json:"templates_dir"`
+}
+
+// CacheConfig controls the in-memory cache of short codes resolved by
+// redirects and downloads
+type CacheConfig struct {
+	Enabled            bool `json:"enabled"`
+	Size               int  `json:"size"` // Most short codes kept, for links and files each
+	TTLSeconds         int  `json:"ttl_seconds"`
+	NegativeTTLSeconds int  `json:"negative_ttl_seconds"` // How long unknown short codes are remembered
+}
+
+// DefaultCacheConfig keeps up to 10000 links and files for five minutes and
+// remembers unknown short codes for 30 seconds
+func DefaultCacheConfig() CacheConfig {
+	return CacheConfig{
+		Enabled:            true,
+		Size:               10000,
+		TTLSeconds:         300,
+		NegativeTTLSeconds: 30,
+	}
+}
+
+// EventsConfig controls the queue that records clicks and downloads in the
+// background, in batches
+type EventsConfig struct {
+	QueueSize       int `json:"queue_size"` // Most events waiting to be written
+	BatchSize       int `json:"batch_size"` // Most events written per transaction
+	FlushIntervalMs int `json:"flush_interval_ms"`
+	// How long a request may wait for room in a full queue before its event
+	// is dropped; 0 drops straight away
+	MaxWaitMs int `json:"max_wait_ms"`
+}
+
+// DefaultEventsConfig writes queued events every second, at most 500 at a
+// time, and drops events rather than slowing requests down
+func DefaultEventsConfig() EventsConfig {
+	return EventsConfig{
+		QueueSize:       10000,
+		BatchSize:       500,
+		FlushIntervalMs: 1000,
+	}
+}
+
 func Load() *Config {
 	// Try to load from JSON file first
 	if config := loadFromJSON(); config != nil {
@@ -59,7 +212,16 @@ func loadFromJSON() *Config {
 		return nil
 	}
 	
-	var config Config
+	// Short code prefixes may be deliberately empty, so start from the
+	// defaults rather than filling in zero values afterwards
+	config := Config{
+		ShortCodes:    DefaultShortCodeConfig(),
+		URLPolicy:     DefaultURLPolicyConfig(),
+		HealthCheck:   DefaultHealthCheckConfig(),
+		Trash:         DefaultTrashConfig(),
+		RedirectCache: DefaultCacheConfig(),
+		Events:        DefaultEventsConfig(),
+	}
 	if err := json.Unmarshal(data, &config); err != nil {
 		fmt.Printf("Warning: Failed to parse config file %s: %v\n", configFile, err)
 		return nil
@@ -177,6 +339,15 @@ func loadFromEnv() *Config {
 		JWTSecret:      getEnv("JWT_SECRET", "your-secret-key-change-this"),
 		Analytics:      getEnvBool("ANALYTICS", true),
 		Environment:    getEnv("ENVIRONMENT", "development"),
+		ShortCodes:     loadShortCodeConfigFromEnv(),
+		AdminUsers:     splitAndTrim(getEnv("ADMIN_USERS", ""), ","),
+		TrustedProxies: splitAndTrim(getEnv("TRUSTED_PROXIES", ""), ","),
+		URLPolicy:      loadURLPolicyConfigFromEnv(),
+		HealthCheck:    loadHealthCheckConfigFromEnv(),
+		Trash:          loadTrashConfigFromEnv(),
+		Pages:          PagesConfig{TemplatesDir: getEnv("PAGES_TEMPLATES_DIR", "")},
+		RedirectCache:  loadCacheConfigFromEnv(),
+		Events:         loadEventsConfigFromEnv(),
 		S3: S3Config{
 			Enabled:         getEnvBool("S3_ENABLED", false),
 			Endpoint:        getEnv("S3_ENDPOINT", ""),
@@ -197,6 +368,78 @@ func loadFromEnv() *Config {
 	}
 }
 
+func loadShortCodeConfigFromEnv() ShortCodeConfig {
+	defaults := DefaultShortCodeConfig()
+	return ShortCodeConfig{
+		Length:     int(getEnvInt64("SHORT_CODE_LENGTH", int64(defaults.Length))),
+		MaxLength:  int(getEnvInt64("SHORT_CODE_MAX_LENGTH", int64(defaults.MaxLength))),
+		Alphabet:   getEnv("SHORT_CODE_ALPHABET", defaults.Alphabet),
+		LinkPrefix: lookupEnv("SHORT_CODE_LINK_PREFIX", defaults.LinkPrefix),
+		FilePrefix: lookupEnv("SHORT_CODE_FILE_PREFIX", defaults.FilePrefix),
+		Attempts:   int(getEnvInt64("SHORT_CODE_ATTEMPTS", int64(defaults.Attempts))),
+
+		Reserved:     splitAndTrim(getEnv("SHORT_CODE_RESERVED", ""), ","),
+		DenylistFile: getEnv("SHORT_CODE_DENYLIST_FILE", ""),
+	}
+}
+
+func loadURLPolicyConfigFromEnv() URLPolicyConfig {
+	defaults := DefaultURLPolicyConfig()
+	schemes := defaults.AllowedSchemes
+	if val := getEnv("URL_ALLOWED_SCHEMES", ""); val != "" {
+		schemes = splitAndTrim(val, ",")
+	}
+	return URLPolicyConfig{
+		AllowedSchemes:       schemes,
+		DenylistFile:         getEnv("URL_DENYLIST_FILE", ""),
+		MaxChainHops:         int(getEnvInt64("URL_MAX_CHAIN_HOPS", int64(defaults.MaxChainHops))),
+		BlockPrivateNetworks: getEnvBool("URL_BLOCK_PRIVATE_NETWORKS", defaults.BlockPrivateNetworks),
+	}
+}
+
+func loadHealthCheckConfigFromEnv() HealthCheckConfig {
+	defaults := DefaultHealthCheckConfig()
+	return HealthCheckConfig{
+		Enabled:         getEnvBool("HEALTH_CHECK_ENABLED", defaults.Enabled),
+		IntervalMinutes: int(getEnvInt64("HEALTH_CHECK_INTERVAL_MINUTES", int64(defaults.IntervalMinutes))),
+		RecheckHours:    int(getEnvInt64("HEALTH_CHECK_RECHECK_HOURS", int64(defaults.RecheckHours))),
+		BatchSize:       int(getEnvInt64("HEALTH_CHECK_BATCH_SIZE", int64(defaults.BatchSize))),
+		Concurrency:     int(getEnvInt64("HEALTH_CHECK_CONCURRENCY", int64(defaults.Concurrency))),
+		PerHostDelayMs:  int(getEnvInt64("HEALTH_CHECK_PER_HOST_DELAY_MS", int64(defaults.PerHostDelayMs))),
+		TimeoutSeconds:  int(getEnvInt64("HEALTH_CHECK_TIMEOUT_SECONDS", int64(defaults.TimeoutSeconds))),
+
+		AllowPrivateNetworks: getEnvBool("HEALTH_CHECK_ALLOW_PRIVATE_NETWORKS", defaults.AllowPrivateNetworks),
+	}
+}
+
+func loadTrashConfigFromEnv() TrashConfig {
+	defaults := DefaultTrashConfig()
+	return TrashConfig{
+		RetentionDays:        int(getEnvInt64("TRASH_RETENTION_DAYS", int64(defaults.RetentionDays))),
+		PurgeIntervalMinutes: int(getEnvInt64("TRASH_PURGE_INTERVAL_MINUTES", int64(defaults.PurgeIntervalMinutes))),
+	}
+}
+
+func loadCacheConfigFromEnv() CacheConfig {
+	defaults := DefaultCacheConfig()
+	return CacheConfig{
+		Enabled:            getEnvBool("REDIRECT_CACHE_ENABLED", defaults.Enabled),
+		Size:               int(getEnvInt64("REDIRECT_CACHE_SIZE", int64(defaults.Size))),
+		TTLSeconds:         int(getEnvInt64("REDIRECT_CACHE_TTL_SECONDS", int64(defaults.TTLSeconds))),
+		NegativeTTLSeconds: int(getEnvInt64("REDIRECT_CACHE_NEGATIVE_TTL_SECONDS", int64(defaults.NegativeTTLSeconds))),
+	}
+}
+
+func loadEventsConfigFromEnv() EventsConfig {
+	defaults := DefaultEventsConfig()
+	return EventsConfig{
+		QueueSize:       int(getEnvInt64("EVENTS_QUEUE_SIZE", int64(defaults.QueueSize))),
+		BatchSize:       int(getEnvInt64("EVENTS_BATCH_SIZE", int64(defaults.BatchSize))),
+		FlushIntervalMs: int(getEnvInt64("EVENTS_FLUSH_INTERVAL_MS", int64(defaults.FlushIntervalMs))),
+		MaxWaitMs:       int(getEnvInt64("EVENTS_MAX_WAIT_MS", int64(defaults.MaxWaitMs))),
+	}
+}
+
 func getEnv(key, defaultVal string) string {
 	if val := os.Getenv(key); val != "" {
 		return val
@@ -204,6 +447,14 @@ func getEnv(key, defaultVal string) string {
 	return defaultVal
 }
 
+// lookupEnv is like getEnv but treats a variable set to "" as a value
+func lookupEnv(key, defaultVal string) string {
+	if val, ok := os.LookupEnv(key); ok {
+		return val
+	}
+	return defaultVal
+}
+
 func getEnvBool(key string, defaultVal bool) bool {
 	if val := os.Getenv(key); val != "" {
 		if b, err := strconv.ParseBool(val); err == nil {
@@ -7,15 +7,22 @@ import (
 	"log"
 	"path/filepath"
 
+	"linker/internal/cache"
 	_ "modernc.org/sqlite"
 )
 
 type Database struct {
 	*sql.DB
+
+	// Resolved short codes, nil unless EnableRedirectCache was called
+	linkCache *cache.Cache
+	fileCache *cache.Cache
 }
 
 func Init(databaseURL string) (*Database, error) {
-	db, err := sql.Open("sqlite", databaseURL+"?_pragma=foreign_keys(1)")
+	// The busy timeout makes concurrent writers, such as the health checker
+	// and redirects, wait for the lock instead of failing
+	db, err := sql.Open("sqlite", databaseURL+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
@@ -24,7 +31,7 @@ func Init(databaseURL string) (*Database, error) {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	database := &Database{db}
+	database := &Database{DB: db}
 	
 	if err := database.migrate(); err != nil {
 		return nil, fmt.Errorf("failed to migrate database: %w", err)
@@ -52,6 +59,26 @@ func (db *Database) migrate() error {
 		"004_uuid_conversion.sql",
 		"005_file_sharing.sql",
 		"006_fix_short_codes_constraints.sql",
+		"007_short_code_domains.sql",
+		"008_link_passwords.sql",
+		"009_link_max_clicks.sql",
+		"010_activation_window.sql",
+		"011_redirect_rules.sql",
+		"012_link_variants.sql",
+		"013_link_query_params.sql",
+		"014_link_forward_path.sql",
+		"015_link_opengraph.sql",
+		"016_click_source.sql",
+		"017_link_health.sql",
+		"018_link_redirect_type.sql",
+		"019_tags.sql",
+		"020_search.sql",
+		"021_link_history.sql",
+		"022_trash.sql",
+		"023_fallback_urls.sql",
+		"024_domain_branding.sql",
+		"025_domain_default_codes.sql",
+		"026_file_download_source.sql",
 	}
 
 	for _, migration := range migrations {
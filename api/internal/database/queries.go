@@ -2,8 +2,14 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
 	"linker/internal/models"
 	"linker/internal/utils"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -70,219 +76,2033 @@ func (db *Database) GetUserByID(userID string) (*models.User, error) {
 	return user, nil
 }
 
+// Domain operations
+
+// domainColumns is the column list read by scanDomain
+const domainColumns = `id, domain, is_default, enabled, fallback_url, use_default_codes,
+		brand_name, logo_url, primary_color, background_color, footer_text, created_at, updated_at`
+
+func scanDomain(row rowScanner, domain *models.Domain) error {
+	branding := &domain.Branding
+	return row.Scan(
+		&domain.ID, &domain.Domain, &domain.IsDefault, &domain.Enabled, &domain.FallbackURL, &domain.UseDefaultCodes,
+		&branding.Name, &branding.LogoURL, &branding.PrimaryColor, &branding.BackgroundColor, &branding.FooterText,
+		&domain.CreatedAt, &domain.UpdatedAt,
+	)
+}
+
+func (db *Database) CreateDomain(domain *models.Domain) error {
+	defer db.invalidateDomains()
+
+	domain.ID = utils.GenerateUUID()
+	now := time.Now()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Only one domain can be the default at a time
+	if domain.IsDefault {
+		if _, err := tx.Exec(`UPDATE domains SET is_default = 0 WHERE is_default = 1`); err != nil {
+			return err
+		}
+	}
+
+	query := `
+		INSERT INTO domains (id, domain, is_default, enabled, fallback_url, use_default_codes,
+			brand_name, logo_url, primary_color, background_color, footer_text, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	branding := domain.Branding
+	_, err = tx.Exec(query, domain.ID, domain.Domain, domain.IsDefault, domain.Enabled, domain.FallbackURL, domain.UseDefaultCodes,
+		branding.Name, branding.LogoURL, branding.PrimaryColor, branding.BackgroundColor, branding.FooterText, now, now)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	domain.CreatedAt = now
+	domain.UpdatedAt = now
+	return nil
+}
+
+func (db *Database) GetDomains() ([]models.Domain, error) {
+	query := `
+		SELECT ` + domainColumns + `
+		FROM domains
+		ORDER BY is_default DESC, domain ASC`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var domains []models.Domain
+	for rows.Next() {
+		var domain models.Domain
+		err := scanDomain(rows, &domain)
+		if err != nil {
+			return nil, err
+		}
+		domains = append(domains, domain)
+	}
+
+	return domains, nil
+}
+
+func (db *Database) GetDomainByID(domainID string) (*models.Domain, error) {
+	domain := &models.Domain{}
+	query := `SELECT ` + domainColumns + ` FROM domains WHERE id = ?`
+
+	err := scanDomain(db.QueryRow(query, domainID), domain)
+	if err != nil {
+		return nil, err
+	}
+
+	return domain, nil
+}
+
+func (db *Database) GetDomainByName(name string) (*models.Domain, error) {
+	domain := &models.Domain{}
+	query := `SELECT ` + domainColumns + ` FROM domains WHERE domain = ?`
+
+	err := scanDomain(db.QueryRow(query, name), domain)
+	if err != nil {
+		return nil, err
+	}
+
+	return domain, nil
+}
+
+func (db *Database) GetDefaultDomain() (*models.Domain, error) {
+	domain := &models.Domain{}
+	query := `SELECT ` + domainColumns + ` FROM domains WHERE is_default = 1 LIMIT 1`
+
+	err := scanDomain(db.QueryRow(query), domain)
+	if err != nil {
+		return nil, err
+	}
+
+	return domain, nil
+}
+
+func (db *Database) UpdateDomain(domainID string, updates *models.UpdateDomainRequest) error {
+	defer db.invalidateDomains()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if updates.IsDefault != nil && *updates.IsDefault {
+		if _, err := tx.Exec(`UPDATE domains SET is_default = 0 WHERE is_default = 1 AND id != ?`, domainID); err != nil {
+			return err
+		}
+	}
+
+	query := `
+		UPDATE domains
+		SET is_default = COALESCE(?, is_default),
+			enabled = COALESCE(?, enabled),
+			fallback_url = COALESCE(?, fallback_url),
+			use_default_codes = COALESCE(?, use_default_codes),
+			updated_at = ?
+		WHERE id = ?`
+
+	result, err := tx.Exec(query, updates.IsDefault, updates.Enabled, updates.FallbackURL, updates.UseDefaultCodes, time.Now(), domainID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	if branding := updates.Branding; branding != nil {
+		_, err := tx.Exec(`
+			UPDATE domains
+			SET brand_name = ?, logo_url = ?, primary_color = ?, background_color = ?, footer_text = ?
+			WHERE id = ?`,
+			branding.Name, branding.LogoURL, branding.PrimaryColor, branding.BackgroundColor, branding.FooterText, domainID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// CountDomainUsage returns how many links and files are assigned to a domain
+func (db *Database) CountDomainUsage(domainID string) (int, error) {
+	var count int
+	err := db.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM links WHERE domain_id = ?) +
+		       (SELECT COUNT(*) FROM files WHERE domain_id = ?)`,
+		domainID, domainID,
+	).Scan(&count)
+	return count, err
+}
+
+func (db *Database) DeleteDomain(domainID string) error {
+	defer db.invalidateDomains()
+
+	query := `DELETE FROM domains WHERE id = ?`
+	result, err := db.Exec(query, domainID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
 // Link operations
+
+// linkColumns is the column list read by scanLink
+const linkColumns = `id, user_id, domain_id, original_url, title, description,
+		clicks, max_clicks, analytics, forward_query, forward_path, redirect_type, version, utm_source, utm_medium,
+		utm_campaign, utm_term, utm_content, og_title, og_description, og_image, fallback_url,
+		health_status_code, health_error, health_latency_ms, health_checked_at,
+		password, starts_at, expires_at, created_at, updated_at, deleted_at`
+
+// utc returns t in UTC. Times are stored as text, so those compared in SQL
+// must all be written and bound in one zone to compare in time order.
+func utc(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// querier is implemented by both the database and a transaction, so the
+// steps of a link update can run alone or together in one transaction
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// inTx runs fn in a transaction, committing it only if fn succeeds
+func (db *Database) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func scanLink(row rowScanner, link *models.Link) error {
+	return row.Scan(
+		&link.ID, &link.UserID, &link.DomainID, &link.OriginalURL,
+		&link.Title, &link.Description, &link.Clicks, &link.MaxClicks,
+		&link.Analytics, &link.ForwardQuery, &link.ForwardPath, &link.RedirectType, &link.Version, &link.UTMSource, &link.UTMMedium,
+		&link.UTMCampaign, &link.UTMTerm, &link.UTMContent,
+		&link.OGTitle, &link.OGDescription, &link.OGImage, &link.FallbackURL,
+		&link.HealthStatusCode, &link.HealthError, &link.HealthLatencyMs, &link.HealthCheckedAt,
+		&link.Password, &link.StartsAt, &link.ExpiresAt,
+		&link.CreatedAt, &link.UpdatedAt, &link.DeletedAt,
+	)
+}
+
 func (db *Database) CreateLink(link *models.Link) error {
 	link.ID = utils.GenerateUUID()
+	if link.RedirectType == 0 {
+		link.RedirectType = 302
+	}
 	query := `
-		INSERT INTO links (id, user_id, domain_id, original_url, title, description, analytics, expires_at, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+		INSERT INTO links (id, user_id, domain_id, original_url, title, description, max_clicks, analytics,
+			forward_query, forward_path, redirect_type, utm_source, utm_medium, utm_campaign, utm_term, utm_content,
+			og_title, og_description, og_image, fallback_url, password, starts_at, expires_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 	
-	now := time.Now()
+	now := time.Now().UTC()
 	_, err := db.Exec(query, 
 		link.ID, link.UserID, link.DomainID, 
-		link.OriginalURL, link.Title, link.Description, 
-		link.Analytics, link.ExpiresAt, now, now,
+		link.OriginalURL, link.Title, link.Description, link.MaxClicks,
+		link.Analytics, link.ForwardQuery, link.ForwardPath, link.RedirectType, link.UTMSource, link.UTMMedium,
+		link.UTMCampaign, link.UTMTerm, link.UTMContent,
+		link.OGTitle, link.OGDescription, link.OGImage, link.FallbackURL,
+		link.Password, utc(link.StartsAt), utc(link.ExpiresAt), now, now,
+	)
+	if err != nil {
+		return err
+	}
+	
+	link.CreatedAt = now
+	link.UpdatedAt = now
+	return nil
+}
+
+// CreateShortCode adds a short code for a link in the link's domain namespace
+func (db *Database) CreateShortCode(linkID, shortCode string, isPrimary bool) error {
+	defer db.invalidateShortCode(shortCode)
+	defer db.invalidateLink(linkID)
+	id := utils.GenerateUUID()
+	query := `
+		INSERT INTO short_codes (id, link_id, domain_id, short_code, is_primary, created_at)
+		VALUES (?, ?, (SELECT domain_id FROM links WHERE id = ?), ?, ?, ?)`
+	
+	_, err := db.Exec(query, id, linkID, linkID, shortCode, isPrimary, time.Now())
+	return err
+}
+
+// ShortCodeExists reports whether a short code is taken by any link or file
+// in the given domain namespace. A nil domainID is the default namespace.
+func (db *Database) ShortCodeExists(shortCode string, domainID *string) (bool, error) {
+	var count int
+	err := db.QueryRow(
+		`SELECT COUNT(*) FROM short_codes WHERE short_code = ? AND domain_id IS ?`,
+		shortCode, domainID,
+	).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// queryLinkByShortCode loads the link behind a short code for
+// GetLinkByShortCode, bypassing the cache. Codes without a domain are only
+// used for the default domain and domains with use_default_codes set.
+func (db *Database) queryLinkByShortCode(shortCode string, domainID *string) (*models.Link, error) {
+	link := &models.Link{}
+	query := `
+		SELECT ` + linkColumns + `
+		FROM links
+		WHERE deleted_at IS NULL AND id = (
+			SELECT link_id FROM short_codes
+			WHERE short_code = ? AND link_id IS NOT NULL
+			  AND (domain_id = ? OR (domain_id IS NULL AND (? IS NULL OR EXISTS (
+				SELECT 1 FROM domains WHERE id = ? AND (is_default OR use_default_codes)
+			  ))))
+			ORDER BY domain_id IS NULL
+			LIMIT 1
+		)`
+	
+	err := scanLink(db.QueryRow(query, shortCode, domainID, domainID, domainID), link)
+	if err != nil {
+		return nil, err
+	}
+	
+	// Load short codes, redirect rules and variants
+	shortCodes, err := db.GetShortCodesByLinkID(link.ID)
+	if err == nil {
+		link.ShortCodes = shortCodes
+	}
+	rules, err := db.GetRedirectRulesByLinkID(link.ID)
+	if err == nil {
+		link.RedirectRules = rules
+	}
+	variants, err := db.GetLinkVariantsByLinkID(link.ID)
+	if err == nil {
+		link.Variants = variants
+	}
+	
+	return link, nil
+}
+
+func (db *Database) GetShortCodesByLinkID(linkID string) ([]models.ShortCode, error) {
+	return getShortCodesByLinkID(db, linkID)
+}
+
+func getShortCodesByLinkID(q querier, linkID string) ([]models.ShortCode, error) {
+	query := `
+		SELECT id, link_id, domain_id, short_code, is_primary, created_at
+		FROM short_codes WHERE link_id = ?
+		ORDER BY is_primary DESC, created_at ASC`
+	
+	rows, err := q.Query(query, linkID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	
+	var shortCodes []models.ShortCode
+	for rows.Next() {
+		var sc models.ShortCode
+		err := rows.Scan(&sc.ID, &sc.LinkID, &sc.DomainID, &sc.ShortCode, &sc.IsPrimary, &sc.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		shortCodes = append(shortCodes, sc)
+	}
+	
+	return shortCodes, nil
+}
+
+// SetLinkRedirectRules replaces a link's redirect rules, keeping the order
+// given. Rules that are kept are updated in place so their IDs, which
+// recorded clicks refer to, stay the same.
+func (db *Database) SetLinkRedirectRules(linkID string, rules []models.RedirectRuleRequest) ([]models.RedirectRule, error) {
+	defer db.invalidateLink(linkID)
+
+	var saved []models.RedirectRule
+	err := db.inTx(func(tx *sql.Tx) error {
+		var err error
+		saved, err = setLinkRedirectRules(tx, linkID, rules)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
+
+func setLinkRedirectRules(q querier, linkID string, rules []models.RedirectRuleRequest) ([]models.RedirectRule, error) {
+	rows, err := q.Query(`
+		SELECT id, COALESCE(platform, ''), COALESCE(language, ''), COALESCE(country, ''),
+		       destination_url, created_at
+		FROM link_redirect_rules WHERE link_id = ?
+		ORDER BY position ASC`, linkID)
+	if err != nil {
+		return nil, err
+	}
+	var current []models.RedirectRule
+	for rows.Next() {
+		var r models.RedirectRule
+		if err := rows.Scan(&r.ID, &r.Platform, &r.Language, &r.Country, &r.DestinationURL, &r.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		current = append(current, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	
+	requested := make([]models.RedirectRule, len(rules))
+	for i, r := range rules {
+		requested[i] = models.RedirectRule{
+			ID:             r.ID,
+			LinkID:         linkID,
+			Position:       i,
+			Platform:       strings.ToLower(r.Platform),
+			Language:       strings.ToLower(r.Language),
+			Country:        strings.ToUpper(r.Country),
+			DestinationURL: r.DestinationURL,
+		}
+	}
+	
+	// Match requests to existing rules by ID first, then by content;
+	// requests that match nothing become new rules
+	matched := make([]*models.RedirectRule, len(requested))
+	used := make(map[string]bool)
+	for i, r := range requested {
+		for j := range current {
+			if r.ID != "" && current[j].ID == r.ID && !used[r.ID] {
+				matched[i] = &current[j]
+				used[r.ID] = true
+				break
+			}
+		}
+	}
+	for i, r := range requested {
+		if matched[i] != nil {
+			continue
+		}
+		for j := range current {
+			existing := &current[j]
+			if !used[existing.ID] && existing.Platform == r.Platform && existing.Language == r.Language &&
+				existing.Country == r.Country && existing.DestinationURL == r.DestinationURL {
+				matched[i] = existing
+				used[existing.ID] = true
+				break
+			}
+		}
+	}
+	
+	for _, existing := range current {
+		if !used[existing.ID] {
+			if _, err := q.Exec(`DELETE FROM link_redirect_rules WHERE id = ?`, existing.ID); err != nil {
+				return nil, err
+			}
+		}
+	}
+	
+	insert := `
+		INSERT INTO link_redirect_rules (id, link_id, position, platform, language, country, destination_url, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	update := `
+		UPDATE link_redirect_rules SET position = ?, platform = ?, language = ?, country = ?, destination_url = ?
+		WHERE id = ?`
+	
+	now := time.Now()
+	saved := make([]models.RedirectRule, 0, len(requested))
+	for i, rule := range requested {
+		if existing := matched[i]; existing != nil {
+			rule.ID = existing.ID
+			rule.CreatedAt = existing.CreatedAt
+			_, err = q.Exec(update, rule.Position, rule.Platform, rule.Language, rule.Country, rule.DestinationURL, rule.ID)
+		} else {
+			rule.ID = utils.GenerateUUID()
+			rule.CreatedAt = now
+			_, err = q.Exec(insert,
+				rule.ID, rule.LinkID, rule.Position, rule.Platform,
+				rule.Language, rule.Country, rule.DestinationURL, rule.CreatedAt,
+			)
+		}
+		if err != nil {
+			return nil, err
+		}
+		saved = append(saved, rule)
+	}
+	
+	return saved, nil
+}
+
+func (db *Database) GetRedirectRulesByLinkID(linkID string) ([]models.RedirectRule, error) {
+	return getRedirectRulesByLinkID(db, linkID)
+}
+
+func getRedirectRulesByLinkID(q querier, linkID string) ([]models.RedirectRule, error) {
+	query := `
+		SELECT id, link_id, position, COALESCE(platform, ''), COALESCE(language, ''),
+		       COALESCE(country, ''), destination_url, created_at
+		FROM link_redirect_rules WHERE link_id = ?
+		ORDER BY position ASC`
+	
+	rows, err := q.Query(query, linkID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	
+	var rules []models.RedirectRule
+	for rows.Next() {
+		var rule models.RedirectRule
+		err := rows.Scan(&rule.ID, &rule.LinkID, &rule.Position, &rule.Platform,
+			&rule.Language, &rule.Country, &rule.DestinationURL, &rule.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	
+	return rules, nil
+}
+
+// SetLinkVariants replaces a link's A/B variants, keeping the order given.
+// Variants that are kept are updated in place so their IDs, which visitors'
+// variant cookies and recorded clicks refer to, stay the same.
+func (db *Database) SetLinkVariants(linkID string, variants []models.LinkVariantRequest) ([]models.LinkVariant, error) {
+	defer db.invalidateLink(linkID)
+
+	var saved []models.LinkVariant
+	err := db.inTx(func(tx *sql.Tx) error {
+		var err error
+		saved, err = setLinkVariants(tx, linkID, variants)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
+
+func setLinkVariants(q querier, linkID string, variants []models.LinkVariantRequest) ([]models.LinkVariant, error) {
+	rows, err := q.Query(`
+		SELECT id, COALESCE(label, ''), destination_url, created_at
+		FROM link_variants WHERE link_id = ?
+		ORDER BY position ASC`, linkID)
+	if err != nil {
+		return nil, err
+	}
+	var current []models.LinkVariant
+	for rows.Next() {
+		var v models.LinkVariant
+		if err := rows.Scan(&v.ID, &v.Label, &v.DestinationURL, &v.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		current = append(current, v)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	
+	// Match requests to existing variants by ID first, then by content;
+	// requests that match nothing become new variants
+	matched := make([]*models.LinkVariant, len(variants))
+	used := make(map[string]bool)
+	for i, v := range variants {
+		for j := range current {
+			if v.ID != "" && current[j].ID == v.ID && !used[v.ID] {
+				matched[i] = &current[j]
+				used[v.ID] = true
+				break
+			}
+		}
+	}
+	for i, v := range variants {
+		if matched[i] != nil {
+			continue
+		}
+		for j := range current {
+			existing := &current[j]
+			if !used[existing.ID] && existing.Label == v.Label && existing.DestinationURL == v.DestinationURL {
+				matched[i] = existing
+				used[existing.ID] = true
+				break
+			}
+		}
+	}
+	
+	for _, existing := range current {
+		if !used[existing.ID] {
+			if _, err := q.Exec(`DELETE FROM link_variants WHERE id = ?`, existing.ID); err != nil {
+				return nil, err
+			}
+		}
+	}
+	
+	insert := `
+		INSERT INTO link_variants (id, link_id, position, label, destination_url, weight, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`
+	update := `
+		UPDATE link_variants SET position = ?, label = ?, destination_url = ?, weight = ?
+		WHERE id = ?`
+	
+	now := time.Now()
+	saved := make([]models.LinkVariant, 0, len(variants))
+	for i, v := range variants {
+		variant := models.LinkVariant{
+			ID:             utils.GenerateUUID(),
+			LinkID:         linkID,
+			Position:       i,
+			Label:          v.Label,
+			DestinationURL: v.DestinationURL,
+			Weight:         v.Weight,
+			CreatedAt:      now,
+		}
+		if existing := matched[i]; existing != nil {
+			variant.ID = existing.ID
+			variant.CreatedAt = existing.CreatedAt
+			_, err = q.Exec(update, variant.Position, variant.Label, variant.DestinationURL, variant.Weight, variant.ID)
+		} else {
+			_, err = q.Exec(insert,
+				variant.ID, variant.LinkID, variant.Position, variant.Label,
+				variant.DestinationURL, variant.Weight, variant.CreatedAt,
+			)
+		}
+		if err != nil {
+			return nil, err
+		}
+		saved = append(saved, variant)
+	}
+	
+	return saved, nil
+}
+
+func (db *Database) GetLinkVariantsByLinkID(linkID string) ([]models.LinkVariant, error) {
+	return getLinkVariantsByLinkID(db, linkID)
+}
+
+func getLinkVariantsByLinkID(q querier, linkID string) ([]models.LinkVariant, error) {
+	query := `
+		SELECT id, link_id, position, COALESCE(label, ''), destination_url, weight, created_at
+		FROM link_variants WHERE link_id = ?
+		ORDER BY position ASC`
+	
+	rows, err := q.Query(query, linkID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	
+	var variants []models.LinkVariant
+	for rows.Next() {
+		var v models.LinkVariant
+		err := rows.Scan(&v.ID, &v.LinkID, &v.Position, &v.Label, &v.DestinationURL, &v.Weight, &v.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		variants = append(variants, v)
+	}
+	
+	return variants, nil
+}
+
+// GetUserLinks lists a user's links matching opts
+func (db *Database) GetUserLinks(userID string, opts *models.ListOptions) ([]models.Link, error) {
+	where, order, args, err := linkListing.clauses(userID, opts, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	query := `
+		SELECT ` + linkColumns + `
+		FROM links WHERE ` + where + `
+		ORDER BY ` + order + `
+		LIMIT ? OFFSET ?`
+	
+	rows, err := db.Query(query, append(args, opts.Limit, opts.Offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	
+	var links []models.Link
+	for rows.Next() {
+		var link models.Link
+		err := scanLink(rows, &link)
+		if err != nil {
+			return nil, err
+		}
+		
+		// Load short codes, redirect rules, variants and tags for each link
+		shortCodes, err := db.GetShortCodesByLinkID(link.ID)
+		if err == nil {
+			link.ShortCodes = shortCodes
+		}
+		rules, err := db.GetRedirectRulesByLinkID(link.ID)
+		if err == nil {
+			link.RedirectRules = rules
+		}
+		variants, err := db.GetLinkVariantsByLinkID(link.ID)
+		if err == nil {
+			link.Variants = variants
+		}
+		tags, err := db.GetLinkTags(link.ID)
+		if err == nil {
+			link.Tags = tags
+		}
+		
+		links = append(links, link)
+	}
+	
+	return links, rows.Err()
+}
+
+func (db *Database) GetLinkByID(linkID, userID string) (*models.Link, error) {
+	return getLinkByID(db, linkID, userID)
+}
+
+func getLinkByID(q querier, linkID, userID string) (*models.Link, error) {
+	link := &models.Link{}
+	query := `
+		SELECT ` + linkColumns + `
+		FROM links WHERE id = ? AND user_id = ? AND deleted_at IS NULL`
+	
+	err := scanLink(q.QueryRow(query, linkID, userID), link)
+	if err != nil {
+		return nil, err
+	}
+	
+	// Load short codes, redirect rules, variants and tags
+	shortCodes, err := getShortCodesByLinkID(q, link.ID)
+	if err == nil {
+		link.ShortCodes = shortCodes
+	}
+	rules, err := getRedirectRulesByLinkID(q, link.ID)
+	if err == nil {
+		link.RedirectRules = rules
+	}
+	variants, err := getLinkVariantsByLinkID(q, link.ID)
+	if err == nil {
+		link.Variants = variants
+	}
+	tags, err := getTags(q, "link_tags", "link_id", link.ID)
+	if err == nil {
+		link.Tags = tags
+	}
+	
+	return link, nil
+}
+
+func (db *Database) UpdateLink(linkID, userID string, updates *models.UpdateLinkRequest) error {
+	defer db.invalidateLink(linkID)
+
+	return updateLink(db, linkID, userID, updates)
+}
+
+func updateLink(q querier, linkID, userID string, updates *models.UpdateLinkRequest) error {
+	query := `
+		UPDATE links 
+		SET original_url = COALESCE(?, original_url),
+			title = COALESCE(?, title),
+			description = COALESCE(?, description),
+			analytics = COALESCE(?, analytics),
+			forward_query = COALESCE(?, forward_query),
+			forward_path = COALESCE(?, forward_path),
+			redirect_type = COALESCE(?, redirect_type),
+			utm_source = COALESCE(?, utm_source),
+			utm_medium = COALESCE(?, utm_medium),
+			utm_campaign = COALESCE(?, utm_campaign),
+			utm_term = COALESCE(?, utm_term),
+			utm_content = COALESCE(?, utm_content),
+			og_title = COALESCE(?, og_title),
+			og_description = COALESCE(?, og_description),
+			og_image = COALESCE(?, og_image),
+			fallback_url = COALESCE(?, fallback_url),
+			password = CASE WHEN ? THEN NULL ELSE COALESCE(?, password) END,
+			max_clicks = CASE WHEN ? THEN NULL ELSE COALESCE(?, max_clicks) END,
+			starts_at = CASE WHEN ? THEN NULL ELSE COALESCE(?, starts_at) END,
+			expires_at = CASE WHEN ? THEN NULL ELSE COALESCE(?, expires_at) END,
+			updated_at = ?,
+			-- A new destination has not been checked yet
+			health_checked_at = CASE WHEN original_url = COALESCE(?, original_url) THEN health_checked_at END,
+			health_status_code = CASE WHEN original_url = COALESCE(?, original_url) THEN health_status_code END,
+			health_error = CASE WHEN original_url = COALESCE(?, original_url) THEN health_error END,
+			health_latency_ms = CASE WHEN original_url = COALESCE(?, original_url) THEN health_latency_ms END
+		WHERE id = ? AND user_id = ? AND deleted_at IS NULL`
+	
+	result, err := q.Exec(query, 
+		updates.OriginalURL, updates.Title, updates.Description,
+		updates.Analytics, updates.ForwardQuery, updates.ForwardPath, updates.RedirectType, updates.UTMSource,
+		updates.UTMMedium, updates.UTMCampaign, updates.UTMTerm, updates.UTMContent,
+		updates.OGTitle, updates.OGDescription, updates.OGImage, updates.FallbackURL,
+		updates.Clears(models.ClearPassword), updates.Password,
+		updates.Clears(models.ClearMaxClicks), updates.MaxClicks,
+		updates.Clears(models.ClearStartsAt), utc(updates.StartsAt),
+		updates.Clears(models.ClearExpiresAt), utc(updates.ExpiresAt), time.Now(),
+		updates.OriginalURL, updates.OriginalURL, updates.OriginalURL, updates.OriginalURL,
+		linkID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	
+	return nil
+}
+
+func (db *Database) DeleteLink(linkID, userID string) error {
+	defer db.invalidateLink(linkID)
+
+	query := `DELETE FROM links WHERE id = ? AND user_id = ?`
+	result, err := db.Exec(query, linkID, userID)
+	if err != nil {
+		return err
+	}
+	
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	
+	return nil
+}
+
+// IncrementLinkClicks counts a click unless the link has reached max_clicks.
+// The limit check and increment run as a single statement so concurrent
+// redirects can't overshoot it. It reports whether the click was counted.
+func (db *Database) IncrementLinkClicks(linkID string) (bool, error) {
+	query := `
+		UPDATE links SET clicks = clicks + 1
+		WHERE id = ? AND (max_clicks IS NULL OR clicks < max_clicks)`
+	result, err := db.Exec(query, linkID)
+	if err != nil {
+		return false, err
+	}
+	
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	
+	// Cached links keep their click count, so make the next lookup see the
+	// limit has been reached
+	if rowsAffected == 0 {
+		db.invalidateLink(linkID)
+	}
+	
+	return rowsAffected > 0, nil
+}
+
+// Link history operations
+
+// linkVersionColumns is the column list read by scanLinkVersion, qualified
+// for queries that select link_versions as "v"
+const linkVersionColumns = `v.id, v.link_id, v.version, v.action, v.restored_version, v.changed_fields,
+		v.user_id, v.api_token_id, v.original_url, v.title, v.description, v.analytics,
+		v.forward_query, v.forward_path, v.redirect_type, v.utm_source, v.utm_medium, v.utm_campaign,
+		v.utm_term, v.utm_content, v.og_title, v.og_description, v.og_image, v.fallback_url, v.password,
+		v.max_clicks, v.starts_at, v.expires_at, v.redirect_rules, v.variants, v.tags, v.created_at`
+
+func scanLinkVersion(row rowScanner, version *models.LinkVersion) error {
+	var changedFields, rules, variants, tags string
+	err := row.Scan(
+		&version.ID, &version.LinkID, &version.Version, &version.Action, &version.RestoredVersion,
+		&changedFields, &version.UserID, &version.APITokenID, &version.OriginalURL,
+		&version.Title, &version.Description, &version.Analytics,
+		&version.ForwardQuery, &version.ForwardPath, &version.RedirectType,
+		&version.UTMSource, &version.UTMMedium, &version.UTMCampaign, &version.UTMTerm, &version.UTMContent,
+		&version.OGTitle, &version.OGDescription, &version.OGImage, &version.FallbackURL, &version.Password,
+		&version.MaxClicks, &version.StartsAt, &version.ExpiresAt, &rules, &variants, &tags,
+		&version.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	version.ChangedFields = []string{}
+	if changedFields != "" {
+		version.ChangedFields = strings.Split(changedFields, ",")
+	}
+	version.PasswordProtected = version.Password != nil
+	if err := json.Unmarshal([]byte(rules), &version.RedirectRules); err != nil {
+		return fmt.Errorf("invalid redirect rules in link version: %w", err)
+	}
+	if err := json.Unmarshal([]byte(variants), &version.Variants); err != nil {
+		return fmt.Errorf("invalid variants in link version: %w", err)
+	}
+	if err := json.Unmarshal([]byte(tags), &version.Tags); err != nil {
+		return fmt.Errorf("invalid tags in link version: %w", err)
+	}
+	return nil
+}
+
+// linkVersionOf copies a link's editable settings, as loaded by
+// GetLinkByID, into a version
+func linkVersionOf(link *models.Link) *models.LinkVersion {
+	version := &models.LinkVersion{
+		LinkID:            link.ID,
+		OriginalURL:       link.OriginalURL,
+		Title:             link.Title,
+		Description:       link.Description,
+		Analytics:         link.Analytics,
+		ForwardQuery:      link.ForwardQuery,
+		ForwardPath:       link.ForwardPath,
+		RedirectType:      link.RedirectType,
+		UTMSource:         link.UTMSource,
+		UTMMedium:         link.UTMMedium,
+		UTMCampaign:       link.UTMCampaign,
+		UTMTerm:           link.UTMTerm,
+		UTMContent:        link.UTMContent,
+		OGTitle:           link.OGTitle,
+		OGDescription:     link.OGDescription,
+		OGImage:           link.OGImage,
+		FallbackURL:       link.FallbackURL,
+		Password:          link.Password,
+		PasswordProtected: link.Password != nil,
+		MaxClicks:         link.MaxClicks,
+		StartsAt:          link.StartsAt,
+		ExpiresAt:         link.ExpiresAt,
+		RedirectRules:     []models.RedirectRuleRequest{},
+		Variants:          []models.LinkVariantRequest{},
+		Tags:              []string{},
+		ChangedFields:     []string{},
+	}
+	for _, rule := range link.RedirectRules {
+		version.RedirectRules = append(version.RedirectRules, models.RedirectRuleRequest{
+			ID:             rule.ID,
+			Platform:       rule.Platform,
+			Language:       rule.Language,
+			Country:        rule.Country,
+			DestinationURL: rule.DestinationURL,
+		})
+	}
+	for _, variant := range link.Variants {
+		version.Variants = append(version.Variants, models.LinkVariantRequest{
+			ID:             variant.ID,
+			Label:          variant.Label,
+			DestinationURL: variant.DestinationURL,
+			Weight:         variant.Weight,
+		})
+	}
+	version.Tags = append(version.Tags, link.Tags...)
+	return version
+}
+
+// linkVersionFields lists a version's settings by field name, each in a
+// form that compares equal when the setting is unchanged
+func linkVersionFields(v *models.LinkVersion) [][2]string {
+	optionalInt := func(n *int) string {
+		if n == nil {
+			return ""
+		}
+		return fmt.Sprint(*n)
+	}
+	optionalTime := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+	password := ""
+	if v.Password != nil {
+		password = *v.Password
+	}
+	// Rule and variant IDs are left out: versions recorded before they were
+	// kept have none, and a kept ID never changes a link's behaviour
+	rules := make([]models.RedirectRuleRequest, len(v.RedirectRules))
+	for i, rule := range v.RedirectRules {
+		rule.ID = ""
+		rules[i] = rule
+	}
+	variants := make([]models.LinkVariantRequest, len(v.Variants))
+	for i, variant := range v.Variants {
+		variant.ID = ""
+		variants[i] = variant
+	}
+	rulesJSON, _ := json.Marshal(rules)
+	variantsJSON, _ := json.Marshal(variants)
+	tags, _ := json.Marshal(v.Tags)
+
+	return [][2]string{
+		{"original_url", v.OriginalURL},
+		{"title", v.Title},
+		{"description", v.Description},
+		{"analytics", fmt.Sprint(v.Analytics)},
+		{"forward_query", fmt.Sprint(v.ForwardQuery)},
+		{"forward_path", fmt.Sprint(v.ForwardPath)},
+		{"redirect_type", fmt.Sprint(v.RedirectType)},
+		{"utm_source", v.UTMSource},
+		{"utm_medium", v.UTMMedium},
+		{"utm_campaign", v.UTMCampaign},
+		{"utm_term", v.UTMTerm},
+		{"utm_content", v.UTMContent},
+		{"og_title", v.OGTitle},
+		{"og_description", v.OGDescription},
+		{"og_image", v.OGImage},
+		{"fallback_url", v.FallbackURL},
+		{"password", password},
+		{"max_clicks", optionalInt(v.MaxClicks)},
+		{"starts_at", optionalTime(v.StartsAt)},
+		{"expires_at", optionalTime(v.ExpiresAt)},
+		{"redirect_rules", string(rulesJSON)},
+		{"variants", string(variantsJSON)},
+		{"tags", string(tags)},
+	}
+}
+
+// changedLinkFields names the settings that differ between two versions
+func changedLinkFields(before, after *models.LinkVersion) []string {
+	beforeFields := linkVersionFields(before)
+	changed := []string{}
+	for i, field := range linkVersionFields(after) {
+		if field[1] != beforeFields[i][1] {
+			changed = append(changed, field[0])
+		}
+	}
+	return changed
+}
+
+// RecordLinkVersion snapshots a link's current settings, as loaded by
+// GetLinkByID, as its next version and makes that version live for click
+// attribution. userID and apiTokenID identify who made the change. An
+// update that changed nothing records no version and returns nil.
+func (db *Database) RecordLinkVersion(link *models.Link, action string, restoredVersion *int, userID string, apiTokenID *string) (*models.LinkVersion, error) {
+	// Clicks are attributed to the version held by the cached link
+	defer db.invalidateLink(link.ID)
+
+	var version *models.LinkVersion
+	err := db.inTx(func(tx *sql.Tx) error {
+		var err error
+		version, err = recordLinkVersion(tx, link, action, restoredVersion, userID, apiTokenID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return version, nil
+}
+
+func recordLinkVersion(q querier, link *models.Link, action string, restoredVersion *int, userID string, apiTokenID *string) (*models.LinkVersion, error) {
+	version := linkVersionOf(link)
+	version.Action = action
+	version.RestoredVersion = restoredVersion
+	version.UserID = &userID
+	version.APITokenID = apiTokenID
+
+	previous := &models.LinkVersion{}
+	err := scanLinkVersion(q.QueryRow(`
+		SELECT `+linkVersionColumns+`
+		FROM link_versions v WHERE v.link_id = ?
+		ORDER BY v.version DESC LIMIT 1`, link.ID), previous)
+	switch {
+	case err == sql.ErrNoRows:
+		version.Version = 1
+	case err != nil:
+		return nil, err
+	default:
+		version.Version = previous.Version + 1
+		version.ChangedFields = changedLinkFields(previous, version)
+		if len(version.ChangedFields) == 0 && action == models.LinkVersionUpdate {
+			return nil, nil
+		}
+	}
+
+	rules, err := json.Marshal(version.RedirectRules)
+	if err != nil {
+		return nil, err
+	}
+	variants, err := json.Marshal(version.Variants)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := json.Marshal(version.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	version.ID = utils.GenerateUUID()
+	version.CreatedAt = time.Now()
+	_, err = q.Exec(`
+		INSERT INTO link_versions (id, link_id, version, action, restored_version, changed_fields,
+			user_id, api_token_id, original_url, title, description, analytics, forward_query,
+			forward_path, redirect_type, utm_source, utm_medium, utm_campaign, utm_term, utm_content,
+			og_title, og_description, og_image, fallback_url, password, max_clicks, starts_at, expires_at,
+			redirect_rules, variants, tags, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		version.ID, version.LinkID, version.Version, version.Action, version.RestoredVersion,
+		strings.Join(version.ChangedFields, ","), version.UserID, version.APITokenID,
+		version.OriginalURL, version.Title, version.Description, version.Analytics,
+		version.ForwardQuery, version.ForwardPath, version.RedirectType,
+		version.UTMSource, version.UTMMedium, version.UTMCampaign, version.UTMTerm, version.UTMContent,
+		version.OGTitle, version.OGDescription, version.OGImage, version.FallbackURL, version.Password,
+		version.MaxClicks, version.StartsAt, version.ExpiresAt,
+		string(rules), string(variants), string(tags), version.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Skipped when unchanged so the update trigger doesn't touch updated_at
+	_, err = q.Exec(`UPDATE links SET version = ? WHERE id = ? AND version != ?`,
+		version.Version, link.ID, version.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	return version, nil
+}
+
+// GetLinkHistory returns every recorded version of a user's link, newest first
+func (db *Database) GetLinkHistory(linkID, userID string) ([]models.LinkVersion, error) {
+	rows, err := db.Query(`
+		SELECT `+linkVersionColumns+`
+		FROM link_versions v
+		JOIN links l ON v.link_id = l.id
+		WHERE l.id = ? AND l.user_id = ?
+		ORDER BY v.version DESC`,
+		linkID, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []models.LinkVersion{}
+	for rows.Next() {
+		var version models.LinkVersion
+		if err := scanLinkVersion(rows, &version); err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
+// GetLinkVersion returns one version of a user's link
+func (db *Database) GetLinkVersion(linkID, userID string, versionNumber int) (*models.LinkVersion, error) {
+	version := &models.LinkVersion{}
+	err := scanLinkVersion(db.QueryRow(`
+		SELECT `+linkVersionColumns+`
+		FROM link_versions v
+		JOIN links l ON v.link_id = l.id
+		WHERE l.id = ? AND l.user_id = ? AND v.version = ?`,
+		linkID, userID, versionNumber,
+	), version)
+	if err != nil {
+		return nil, err
+	}
+	return version, nil
+}
+
+// RestoreLinkVersion puts a link's settings back to those of an earlier
+// version. The caller records the result as a new version.
+func (db *Database) RestoreLinkVersion(linkID, userID string, version *models.LinkVersion) error {
+	defer db.invalidateLink(linkID)
+
+	return db.inTx(func(tx *sql.Tx) error {
+		return restoreLinkVersion(tx, linkID, userID, version)
+	})
+}
+
+func restoreLinkVersion(q querier, linkID, userID string, version *models.LinkVersion) error {
+	result, err := q.Exec(`
+		UPDATE links
+		SET original_url = ?, title = ?, description = ?, analytics = ?,
+			forward_query = ?, forward_path = ?, redirect_type = ?,
+			utm_source = ?, utm_medium = ?, utm_campaign = ?, utm_term = ?, utm_content = ?,
+			og_title = ?, og_description = ?, og_image = ?, fallback_url = ?, password = ?,
+			max_clicks = ?, starts_at = ?, expires_at = ?, updated_at = ?,
+			-- A new destination has not been checked yet
+			health_checked_at = CASE WHEN original_url = ? THEN health_checked_at END,
+			health_status_code = CASE WHEN original_url = ? THEN health_status_code END,
+			health_error = CASE WHEN original_url = ? THEN health_error END,
+			health_latency_ms = CASE WHEN original_url = ? THEN health_latency_ms END
+		WHERE id = ? AND user_id = ?`,
+		version.OriginalURL, version.Title, version.Description, version.Analytics,
+		version.ForwardQuery, version.ForwardPath, version.RedirectType,
+		version.UTMSource, version.UTMMedium, version.UTMCampaign, version.UTMTerm, version.UTMContent,
+		version.OGTitle, version.OGDescription, version.OGImage, version.FallbackURL, version.Password,
+		version.MaxClicks, utc(version.StartsAt), utc(version.ExpiresAt), time.Now(),
+		version.OriginalURL, version.OriginalURL, version.OriginalURL, version.OriginalURL,
+		linkID, userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	if _, err := setLinkRedirectRules(q, linkID, version.RedirectRules); err != nil {
+		return err
+	}
+	if _, err := setLinkVariants(q, linkID, version.Variants); err != nil {
+		return err
+	}
+	return setTags(q, "link_tags", "link_id", linkID, version.Tags)
+}
+
+// UpdateLinkWithHistory applies an update, including any redirect rules,
+// variants and tags it sets, and records the result as a new version in one
+// transaction, so a failed step leaves the link as it was. The returned
+// version is nil when the update changed nothing.
+func (db *Database) UpdateLinkWithHistory(linkID, userID string, updates *models.UpdateLinkRequest, apiTokenID *string) (*models.LinkVersion, error) {
+	defer db.invalidateLink(linkID)
+
+	var version *models.LinkVersion
+	err := db.inTx(func(tx *sql.Tx) error {
+		if err := updateLink(tx, linkID, userID, updates); err != nil {
+			return err
+		}
+
+		// A nil list leaves the rules, variants or tags alone; an empty one clears them
+		if updates.RedirectRules != nil {
+			if _, err := setLinkRedirectRules(tx, linkID, updates.RedirectRules); err != nil {
+				return err
+			}
+		}
+		if updates.Variants != nil {
+			if _, err := setLinkVariants(tx, linkID, updates.Variants); err != nil {
+				return err
+			}
+		}
+		if updates.Tags != nil {
+			if err := setTags(tx, "link_tags", "link_id", linkID, updates.Tags); err != nil {
+				return err
+			}
+		}
+
+		link, err := getLinkByID(tx, linkID, userID)
+		if err != nil {
+			return err
+		}
+		version, err = recordLinkVersion(tx, link, models.LinkVersionUpdate, nil, userID, apiTokenID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return version, nil
+}
+
+// RollbackLink restores an earlier version of a link and records the
+// result as a new version in one transaction
+func (db *Database) RollbackLink(linkID, userID string, version *models.LinkVersion, apiTokenID *string) (*models.LinkVersion, error) {
+	defer db.invalidateLink(linkID)
+
+	var recorded *models.LinkVersion
+	err := db.inTx(func(tx *sql.Tx) error {
+		if err := restoreLinkVersion(tx, linkID, userID, version); err != nil {
+			return err
+		}
+
+		link, err := getLinkByID(tx, linkID, userID)
+		if err != nil {
+			return err
+		}
+		recorded, err = recordLinkVersion(tx, link, models.LinkVersionRollback, &version.Version, userID, apiTokenID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return recorded, nil
+}
+
+// Link health operations
+
+// HealthCheckTarget is a link destination due for a health check
+type HealthCheckTarget struct {
+	LinkID      string
+	OriginalURL string
+}
+
+// GetLinksDueForHealthCheck returns links never checked or last checked
+// before the cutoff, oldest first. Expired links are skipped.
+func (db *Database) GetLinksDueForHealthCheck(checkedBefore time.Time, limit int) ([]HealthCheckTarget, error) {
+	rows, err := db.Query(`
+		SELECT id, original_url FROM links
+		WHERE (health_checked_at IS NULL OR health_checked_at < ?)
+		  AND (expires_at IS NULL OR expires_at > ?)
+		  AND deleted_at IS NULL
+		ORDER BY health_checked_at IS NOT NULL, health_checked_at
+		LIMIT ?`,
+		checkedBefore.UTC(), time.Now().UTC(), limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var targets []HealthCheckTarget
+	for rows.Next() {
+		var target HealthCheckTarget
+		if err := rows.Scan(&target.LinkID, &target.OriginalURL); err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+	return targets, rows.Err()
+}
+
+// UpdateLinkHealth stores the result of a health check. The result is
+// dropped if the destination changed while it was being checked.
+func (db *Database) UpdateLinkHealth(linkID, checkedURL string, statusCode *int, healthErr *string, latency time.Duration, checkedAt time.Time) error {
+	_, err := db.Exec(`
+		UPDATE links
+		SET health_status_code = ?, health_error = ?, health_latency_ms = ?, health_checked_at = ?
+		WHERE id = ? AND original_url = ?`,
+		statusCode, healthErr, latency.Milliseconds(), checkedAt.UTC(), linkID, checkedURL,
+	)
+	return err
+}
+
+// Broken link filters for GetBrokenLinks
+const (
+	BrokenAny         = ""
+	BrokenClientError = "4xx"
+	BrokenServerError = "5xx"
+	BrokenDNS         = "dns"
+	BrokenNetwork     = "error" // Any failure without an HTTP response
+)
+
+var brokenLinkConditions = map[string]string{
+	BrokenAny:         `(health_status_code >= 400 OR health_error IS NOT NULL)`,
+	BrokenClientError: `health_status_code BETWEEN 400 AND 499`,
+	BrokenServerError: `health_status_code >= 500`,
+	BrokenDNS:         `health_error = 'dns_error'`,
+	BrokenNetwork:     `health_error IS NOT NULL`,
+}
+
+// IsValidBrokenFilter reports whether filter is accepted by GetBrokenLinks
+func IsValidBrokenFilter(filter string) bool {
+	_, ok := brokenLinkConditions[filter]
+	return ok
+}
+
+// GetBrokenLinks returns a user's links whose last health check failed,
+// most recently checked first
+func (db *Database) GetBrokenLinks(userID, filter string, limit, offset int) ([]models.Link, error) {
+	condition, ok := brokenLinkConditions[filter]
+	if !ok {
+		return nil, fmt.Errorf("unknown broken link filter %q", filter)
+	}
+
+	rows, err := db.Query(`
+		SELECT `+linkColumns+`
+		FROM links WHERE user_id = ? AND deleted_at IS NULL AND `+condition+`
+		ORDER BY health_checked_at DESC
+		LIMIT ? OFFSET ?`,
+		userID, limit, offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []models.Link{}
+	for rows.Next() {
+		var link models.Link
+		if err := scanLink(rows, &link); err != nil {
+			return nil, err
+		}
+		if shortCodes, err := db.GetShortCodesByLinkID(link.ID); err == nil {
+			link.ShortCodes = shortCodes
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+// Tag operations
+
+// SetLinkTags replaces a link's tags
+func (db *Database) SetLinkTags(linkID string, tags []string) error {
+	return db.inTx(func(tx *sql.Tx) error {
+		return setTags(tx, "link_tags", "link_id", linkID, tags)
+	})
+}
+
+// GetLinkTags returns a link's tags in alphabetical order
+func (db *Database) GetLinkTags(linkID string) ([]string, error) {
+	return getTags(db, "link_tags", "link_id", linkID)
+}
+
+// SetFileTags replaces a file's tags
+func (db *Database) SetFileTags(fileID string, tags []string) error {
+	return db.inTx(func(tx *sql.Tx) error {
+		return setTags(tx, "file_tags", "file_id", fileID, tags)
+	})
+}
+
+// GetFileTags returns a file's tags in alphabetical order
+func (db *Database) GetFileTags(fileID string) ([]string, error) {
+	return getTags(db, "file_tags", "file_id", fileID)
+}
+
+// setTags replaces the tags in table owned by ownerID. Tags are trimmed and
+// compared without case, so the first spelling of a duplicate is kept.
+func setTags(q querier, table, ownerColumn, ownerID string, tags []string) error {
+	if _, err := q.Exec(`DELETE FROM `+table+` WHERE `+ownerColumn+` = ?`, ownerID); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+		_, err := q.Exec(`INSERT OR IGNORE INTO `+table+` (`+ownerColumn+`, tag, created_at) VALUES (?, ?, ?)`,
+			ownerID, tag, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func getTags(q querier, table, ownerColumn, ownerID string) ([]string, error) {
+	rows, err := q.Query(`SELECT tag FROM `+table+` WHERE `+ownerColumn+` = ? ORDER BY tag`, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []string
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// Listing filters
+
+// listing describes how GetUserLinks and GetUserFiles filter and sort one
+// resource table
+type listing struct {
+	tagTable      string
+	ownerColumn   string
+	searchColumns []string
+	sortColumns   map[string]string
+	// Links also expire once they reach max_clicks
+	clickLimit bool
+}
+
+var linkListing = listing{
+	tagTable:      "link_tags",
+	ownerColumn:   "link_id",
+	searchColumns: []string{"title", "description", "original_url"},
+	sortColumns: map[string]string{
+		models.SortCreated: "created_at",
+		models.SortUpdated: "updated_at",
+		models.SortClicks:  "clicks",
+	},
+	clickLimit: true,
+}
+
+var fileListing = listing{
+	tagTable:      "file_tags",
+	ownerColumn:   "file_id",
+	searchColumns: []string{"title", "description", "original_name"},
+	sortColumns: map[string]string{
+		models.SortCreated:   "created_at",
+		models.SortUpdated:   "updated_at",
+		models.SortDownloads: "downloads",
+	},
+}
+
+// likeEscaper escapes LIKE wildcards so search terms match literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// clauses builds the WHERE and ORDER BY clauses for a user's listing, with
+// the arguments for the WHERE placeholders
+func (l listing) clauses(userID string, opts *models.ListOptions, now time.Time) (string, string, []interface{}, error) {
+	conditions := []string{"user_id = ?", "deleted_at IS NULL"}
+	args := []interface{}{userID}
+
+	for _, tag := range opts.Tags {
+		conditions = append(conditions, `id IN (SELECT `+l.ownerColumn+` FROM `+l.tagTable+` WHERE tag = ?)`)
+		args = append(args, tag)
+	}
+
+	if opts.DomainID != nil {
+		if *opts.DomainID == models.DomainDefault {
+			conditions = append(conditions, "domain_id IS NULL")
+		} else {
+			conditions = append(conditions, "domain_id = ?")
+			args = append(args, *opts.DomainID)
+		}
+	}
+
+	exhausted := "0"
+	if l.clickLimit {
+		exhausted = "(max_clicks IS NOT NULL AND clicks >= max_clicks)"
+	}
+	switch opts.Expiry {
+	case "":
+	case models.ExpiryActive:
+		conditions = append(conditions,
+			"(starts_at IS NULL OR starts_at <= ?) AND (expires_at IS NULL OR expires_at > ?) AND NOT "+exhausted)
+		args = append(args, now, now)
+	case models.ExpiryExpired:
+		conditions = append(conditions, "(expires_at <= ? OR "+exhausted+")")
+		args = append(args, now)
+	case models.ExpiryScheduled:
+		conditions = append(conditions, "starts_at > ?")
+		args = append(args, now)
+	case models.ExpiryNever:
+		conditions = append(conditions, "expires_at IS NULL")
+	default:
+		return "", "", nil, fmt.Errorf("unknown expiry filter %q", opts.Expiry)
+	}
+
+	if opts.CreatedAfter != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, opts.CreatedAfter.UTC())
+	}
+	if opts.CreatedBefore != nil {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, opts.CreatedBefore.UTC())
+	}
+
+	if opts.Search != "" {
+		pattern := "%" + likeEscaper.Replace(opts.Search) + "%"
+		matches := make([]string, len(l.searchColumns))
+		for i, column := range l.searchColumns {
+			matches[i] = column + ` LIKE ? ESCAPE '\'`
+			args = append(args, pattern)
+		}
+		conditions = append(conditions, "("+strings.Join(matches, " OR ")+")")
+	}
+
+	sortKey := opts.Sort
+	if sortKey == "" {
+		sortKey = models.SortCreated
+	}
+	column, ok := l.sortColumns[sortKey]
+	if !ok {
+		return "", "", nil, fmt.Errorf("unknown sort %q", sortKey)
+	}
+	direction := "ASC"
+	if opts.Descending {
+		direction = "DESC"
+	}
+	// created_at and id break ties so pages don't overlap
+	order := column + " " + direction + ", created_at " + direction + ", id " + direction
+
+	return strings.Join(conditions, " AND "), order, args, nil
+}
+
+// Search operations
+
+// Search highlight markers. They are swapped for <mark> tags after the
+// snippet is HTML-escaped, so stored text can't inject markup.
+const (
+	searchMarkStart = "\x02"
+	searchMarkEnd   = "\x03"
+)
+
+var searchHighlighter = strings.NewReplacer(searchMarkStart, "<mark>", searchMarkEnd, "</mark>")
+
+// searchQueries rank each index with bm25, weighting titles above
+// descriptions and URLs or filenames. The id column is not searched.
+var searchQueries = map[string]string{
+	models.SearchTypeLink: `
+		SELECT s.link_id, snippet(links_search, -1, char(2), char(3), '…', 12), bm25(links_search, 0, 10.0, 4.0, 2.0) AS rank
+		FROM links_search s JOIN links l ON l.id = s.link_id
+		WHERE links_search MATCH ? AND l.user_id = ? AND l.deleted_at IS NULL
+		ORDER BY rank
+		LIMIT ?`,
+	models.SearchTypeFile: `
+		SELECT s.file_id, snippet(files_search, -1, char(2), char(3), '…', 12), bm25(files_search, 0, 6.0, 10.0, 4.0) AS rank
+		FROM files_search s JOIN files f ON f.id = s.file_id
+		WHERE files_search MATCH ? AND f.user_id = ? AND f.deleted_at IS NULL
+		ORDER BY rank
+		LIMIT ?`,
+}
+
+// FTSQuery turns free text into an FTS5 query matching documents that
+// contain every word, treating the last letters of each word as a prefix.
+// Words are quoted so FTS5 operators in user input are matched literally.
+// It returns "" when the text has no words.
+func FTSQuery(text string) string {
+	var terms []string
+	for _, word := range strings.Fields(text) {
+		terms = append(terms, `"`+strings.ReplaceAll(word, `"`, `""`)+`"*`)
+	}
+	return strings.Join(terms, " ")
+}
+
+// Search runs a full-text search over a user's links and files, most
+// relevant first. types limits the search to SearchTypeLink or
+// SearchTypeFile results; nil searches both.
+func (db *Database) Search(userID, text string, types []string, limit, offset int) ([]models.SearchResult, error) {
+	query := FTSQuery(text)
+	if query == "" {
+		return []models.SearchResult{}, nil
+	}
+	if types == nil {
+		types = []string{models.SearchTypeLink, models.SearchTypeFile}
+	}
+
+	// Each index returns enough results to fill the page on its own, then
+	// the two are merged by score
+	var results []models.SearchResult
+	for _, resultType := range types {
+		sqlQuery, ok := searchQueries[resultType]
+		if !ok {
+			return nil, fmt.Errorf("unknown search result type %q", resultType)
+		}
+
+		rows, err := db.Query(sqlQuery, query, userID, limit+offset)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			result := models.SearchResult{Type: resultType}
+			var rank float64
+			if err := rows.Scan(&result.ID, &result.Snippet, &rank); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			result.Snippet = searchHighlighter.Replace(html.EscapeString(result.Snippet))
+			// bm25 scores are negative, lower being better
+			result.Score = -rank
+			results = append(results, result)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	if offset >= len(results) {
+		return []models.SearchResult{}, nil
+	}
+	results = results[offset:]
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	for i := range results {
+		var err error
+		switch results[i].Type {
+		case models.SearchTypeLink:
+			results[i].Link, err = db.GetLinkByID(results[i].ID, userID)
+		case models.SearchTypeFile:
+			results[i].File, err = db.GetFileByID(results[i].ID, userID)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+// Trash operations
+
+// TrashLink moves a link to the trash. Its short codes stay reserved until
+// it is purged.
+func (db *Database) TrashLink(linkID, userID string) error {
+	defer db.invalidateLink(linkID)
+	return db.setDeletedAt("links", linkID, userID, time.Now())
+}
+
+// TrashFile moves a file to the trash. The stored object is kept so the file
+// can be restored.
+func (db *Database) TrashFile(fileID, userID string) error {
+	defer db.invalidateFile(fileID)
+	return db.setDeletedAt("files", fileID, userID, time.Now())
+}
+
+// RestoreLink takes a link out of the trash unless it was deleted before
+// deletedAfter, when it is already due to be purged
+func (db *Database) RestoreLink(linkID, userID string, deletedAfter time.Time) error {
+	// Its short codes were remembered as matching nothing while trashed
+	defer db.invalidateMissing()
+	return db.restore("links", linkID, userID, deletedAfter)
+}
+
+// RestoreFile takes a file out of the trash unless it was deleted before
+// deletedAfter, when its object may already have been removed
+func (db *Database) RestoreFile(fileID, userID string, deletedAfter time.Time) error {
+	defer db.invalidateMissing()
+	return db.restore("files", fileID, userID, deletedAfter)
+}
+
+func (db *Database) setDeletedAt(table, id, userID string, deletedAt time.Time) error {
+	result, err := db.Exec(
+		`UPDATE `+table+` SET deleted_at = ? WHERE id = ? AND user_id = ? AND deleted_at IS NULL`,
+		deletedAt.UTC(), id, userID,
 	)
 	if err != nil {
 		return err
 	}
-	
-	link.CreatedAt = now
-	link.UpdatedAt = now
-	return nil
+	return requireRow(result)
 }
 
-func (db *Database) CreateShortCode(linkID, shortCode string, isPrimary bool) error {
-	id := utils.GenerateUUID()
-	query := `
-		INSERT INTO short_codes (id, link_id, short_code, is_primary, created_at)
-		VALUES (?, ?, ?, ?, ?)`
-	
-	_, err := db.Exec(query, id, linkID, shortCode, isPrimary, time.Now())
-	return err
+func (db *Database) restore(table, id, userID string, deletedAfter time.Time) error {
+	result, err := db.Exec(
+		`UPDATE `+table+` SET deleted_at = NULL WHERE id = ? AND user_id = ? AND deleted_at > ?`,
+		id, userID, deletedAfter.UTC(),
+	)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
 }
 
-func (db *Database) GetLinkByShortCode(shortCode string) (*models.Link, error) {
-	link := &models.Link{}
-	query := `
-		SELECT l.id, l.user_id, l.domain_id, l.original_url, l.title, l.description, 
-			   l.clicks, l.analytics, l.expires_at, l.created_at, l.updated_at 
-		FROM links l
-		JOIN short_codes sc ON l.id = sc.link_id
-		WHERE sc.short_code = ?`
-	
-	err := db.QueryRow(query, shortCode).Scan(
-		&link.ID, &link.UserID, &link.DomainID, &link.OriginalURL,
-		&link.Title, &link.Description, &link.Clicks, &link.Analytics,
-		&link.ExpiresAt, &link.CreatedAt, &link.UpdatedAt,
-	)
+// requireRow returns sql.ErrNoRows when a statement changed nothing
+func requireRow(result sql.Result) error {
+	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	
-	// Load short codes
-	shortCodes, err := db.GetShortCodesByLinkID(link.ID)
-	if err == nil {
-		link.ShortCodes = shortCodes
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
 	}
-	
-	return link, nil
+	return nil
 }
 
-func (db *Database) GetShortCodesByLinkID(linkID string) ([]models.ShortCode, error) {
-	query := `
-		SELECT id, link_id, short_code, is_primary, created_at
-		FROM short_codes WHERE link_id = ?
-		ORDER BY is_primary DESC, created_at ASC`
-	
-	rows, err := db.Query(query, linkID)
+// GetTrashedLinks lists a user's links in the trash, most recently deleted
+// first
+func (db *Database) GetTrashedLinks(userID string) ([]models.Link, error) {
+	rows, err := db.Query(`
+		SELECT `+linkColumns+`
+		FROM links WHERE user_id = ? AND deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC, id`,
+		userID,
+	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	
-	var shortCodes []models.ShortCode
+
+	links := []models.Link{}
 	for rows.Next() {
-		var sc models.ShortCode
-		err := rows.Scan(&sc.ID, &sc.LinkID, &sc.ShortCode, &sc.IsPrimary, &sc.CreatedAt)
-		if err != nil {
+		var link models.Link
+		if err := scanLink(rows, &link); err != nil {
 			return nil, err
 		}
-		shortCodes = append(shortCodes, sc)
+		if shortCodes, err := db.GetShortCodesByLinkID(link.ID); err == nil {
+			link.ShortCodes = shortCodes
+		}
+		if tags, err := db.GetLinkTags(link.ID); err == nil {
+			link.Tags = tags
+		}
+		links = append(links, link)
 	}
-	
-	return shortCodes, nil
+	return links, rows.Err()
 }
 
-func (db *Database) GetUserLinks(userID string, limit, offset int) ([]models.Link, error) {
-	query := `
-		SELECT id, user_id, domain_id, original_url, title, description, 
-			   clicks, analytics, expires_at, created_at, updated_at 
-		FROM links WHERE user_id = ? 
-		ORDER BY created_at DESC 
-		LIMIT ? OFFSET ?`
-	
-	rows, err := db.Query(query, userID, limit, offset)
+// GetTrashedFiles lists a user's files in the trash, most recently deleted
+// first
+func (db *Database) GetTrashedFiles(userID string) ([]models.File, error) {
+	return db.queryTrashedFiles(`
+		SELECT `+fileColumns+`
+		FROM files WHERE user_id = ? AND deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC, id`,
+		userID,
+	)
+}
+
+// GetTrashedFile returns one of a user's files in the trash
+func (db *Database) GetTrashedFile(fileID, userID string) (*models.File, error) {
+	file := &models.File{}
+	err := scanFile(db.QueryRow(`
+		SELECT `+fileColumns+`
+		FROM files WHERE id = ? AND user_id = ? AND deleted_at IS NOT NULL`,
+		fileID, userID,
+	), file)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// GetFilesDueForPurge returns files of every user that were moved to the
+// trash before the cutoff, oldest first
+func (db *Database) GetFilesDueForPurge(deletedBefore time.Time, limit int) ([]models.File, error) {
+	return db.queryTrashedFiles(`
+		SELECT `+fileColumns+`
+		FROM files WHERE deleted_at <= ?
+		ORDER BY deleted_at
+		LIMIT ?`,
+		deletedBefore.UTC(), limit,
+	)
+}
+
+func (db *Database) queryTrashedFiles(query string, args ...interface{}) ([]models.File, error) {
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	
-	var links []models.Link
+
+	files := []models.File{}
 	for rows.Next() {
-		var link models.Link
-		err := rows.Scan(
-			&link.ID, &link.UserID, &link.DomainID, &link.OriginalURL,
-			&link.Title, &link.Description, &link.Clicks, &link.Analytics,
-			&link.ExpiresAt, &link.CreatedAt, &link.UpdatedAt,
-		)
-		if err != nil {
+		var file models.File
+		if err := scanFile(rows, &file); err != nil {
 			return nil, err
 		}
-		
-		// Load short codes for each link
-		shortCodes, err := db.GetShortCodesByLinkID(link.ID)
-		if err == nil {
-			link.ShortCodes = shortCodes
+		if shortCodes, err := db.GetShortCodesByFileID(file.ID); err == nil {
+			file.ShortCodes = shortCodes
 		}
-		
-		links = append(links, link)
+		if tags, err := db.GetFileTags(file.ID); err == nil {
+			file.Tags = tags
+		}
+		files = append(files, file)
 	}
-	
-	return links, nil
+	return files, rows.Err()
 }
 
-func (db *Database) GetLinkByID(linkID, userID string) (*models.Link, error) {
-	link := &models.Link{}
-	query := `
-		SELECT id, user_id, domain_id, original_url, title, description, 
-			   clicks, analytics, expires_at, created_at, updated_at 
-		FROM links WHERE id = ? AND user_id = ?`
-	
-	err := db.QueryRow(query, linkID, userID).Scan(
-		&link.ID, &link.UserID, &link.DomainID, &link.OriginalURL,
-		&link.Title, &link.Description, &link.Clicks, &link.Analytics,
-		&link.ExpiresAt, &link.CreatedAt, &link.UpdatedAt,
+// PurgeLink permanently deletes a link in the trash, releasing its short
+// codes
+func (db *Database) PurgeLink(linkID, userID string) error {
+	result, err := db.Exec(
+		`DELETE FROM links WHERE id = ? AND user_id = ? AND deleted_at IS NOT NULL`,
+		linkID, userID,
 	)
 	if err != nil {
-		return nil, err
-	}
-	
-	// Load short codes
-	shortCodes, err := db.GetShortCodesByLinkID(link.ID)
-	if err == nil {
-		link.ShortCodes = shortCodes
+		return err
 	}
-	
-	return link, nil
+	return requireRow(result)
 }
 
-func (db *Database) UpdateLink(linkID, userID string, updates *models.UpdateLinkRequest) error {
-	query := `
-		UPDATE links 
-		SET original_url = COALESCE(?, original_url),
-			title = COALESCE(?, title),
-			description = COALESCE(?, description),
-			analytics = ?,
-			expires_at = COALESCE(?, expires_at),
-			updated_at = ?
-		WHERE id = ? AND user_id = ?`
-	
-	result, err := db.Exec(query, 
-		updates.OriginalURL, updates.Title, updates.Description,
-		updates.Analytics, updates.ExpiresAt, time.Now(), linkID, userID,
+// PurgeFile permanently deletes a file in the trash. The caller removes the
+// stored object first.
+func (db *Database) PurgeFile(fileID, userID string) error {
+	result, err := db.Exec(
+		`DELETE FROM files WHERE id = ? AND user_id = ? AND deleted_at IS NOT NULL`,
+		fileID, userID,
 	)
 	if err != nil {
 		return err
 	}
+	return requireRow(result)
+}
+
+// PurgeTrashedLinks permanently deletes links moved to the trash before the
+// cutoff and returns how many were removed. A non-empty userID limits it to
+// that user's links.
+func (db *Database) PurgeTrashedLinks(userID string, deletedBefore time.Time) (int64, error) {
+	query := `DELETE FROM links WHERE deleted_at <= ?`
+	args := []interface{}{deletedBefore.UTC()}
+	if userID != "" {
+		query += ` AND user_id = ?`
+		args = append(args, userID)
+	}
+
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Short code alias operations
+
+// ErrLastShortCode is returned when removing a resource's only short code
+var ErrLastShortCode = errors.New("cannot remove the last short code")
+
+func (db *Database) DeleteLinkShortCode(linkID, shortCode string) error {
+	defer db.invalidateShortCode(shortCode)
+	defer db.invalidateLink(linkID)
+	return db.deleteShortCode("link_id", linkID, shortCode)
+}
+
+func (db *Database) DeleteFileShortCode(fileID, shortCode string) error {
+	defer db.invalidateShortCode(shortCode)
+	defer db.invalidateFile(fileID)
+	return db.deleteShortCode("file_id", fileID, shortCode)
+}
+
+func (db *Database) SetPrimaryLinkShortCode(linkID, shortCode string) error {
+	defer db.invalidateLink(linkID)
+	return db.setPrimaryShortCode("link_id", linkID, shortCode)
+}
+
+func (db *Database) SetPrimaryFileShortCode(fileID, shortCode string) error {
+	defer db.invalidateFile(fileID)
+	return db.setPrimaryShortCode("file_id", fileID, shortCode)
+}
+
+// deleteShortCode removes one of a link's or file's short codes. ownerColumn
+// is "link_id" or "file_id". Removing the primary code promotes the oldest
+// remaining one so the resource always keeps a primary.
+func (db *Database) deleteShortCode(ownerColumn, ownerID, shortCode string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 	
-	rowsAffected, err := result.RowsAffected()
+	var total int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM short_codes WHERE `+ownerColumn+` = ?`, ownerID).Scan(&total); err != nil {
+		return err
+	}
+	
+	var id string
+	var isPrimary bool
+	err = tx.QueryRow(
+		`SELECT id, is_primary FROM short_codes WHERE `+ownerColumn+` = ? AND short_code = ?`,
+		ownerID, shortCode,
+	).Scan(&id, &isPrimary)
 	if err != nil {
 		return err
 	}
 	
-	if rowsAffected == 0 {
-		return sql.ErrNoRows
+	if total <= 1 {
+		return ErrLastShortCode
 	}
 	
-	return nil
+	if _, err := tx.Exec(`DELETE FROM short_codes WHERE id = ?`, id); err != nil {
+		return err
+	}
+	
+	if isPrimary {
+		_, err := tx.Exec(`
+			UPDATE short_codes SET is_primary = 1
+			WHERE id = (
+				SELECT id FROM short_codes WHERE `+ownerColumn+` = ?
+				ORDER BY created_at ASC LIMIT 1
+			)`, ownerID)
+		if err != nil {
+			return err
+		}
+	}
+	
+	return tx.Commit()
 }
 
-func (db *Database) DeleteLink(linkID, userID string) error {
-	query := `DELETE FROM links WHERE id = ? AND user_id = ?`
-	result, err := db.Exec(query, linkID, userID)
+// setPrimaryShortCode marks one short code as primary and demotes the rest
+func (db *Database) setPrimaryShortCode(ownerColumn, ownerID, shortCode string) error {
+	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 	
-	rowsAffected, err := result.RowsAffected()
+	var id string
+	err = tx.QueryRow(
+		`SELECT id FROM short_codes WHERE `+ownerColumn+` = ? AND short_code = ?`,
+		ownerID, shortCode,
+	).Scan(&id)
 	if err != nil {
 		return err
 	}
 	
-	if rowsAffected == 0 {
-		return sql.ErrNoRows
+	_, err = tx.Exec(
+		`UPDATE short_codes SET is_primary = (id = ?) WHERE `+ownerColumn+` = ?`,
+		id, ownerID,
+	)
+	if err != nil {
+		return err
 	}
 	
-	return nil
+	return tx.Commit()
 }
 
-func (db *Database) IncrementLinkClicks(linkID string) error {
-	query := `UPDATE links SET clicks = clicks + 1 WHERE id = ?`
-	_, err := db.Exec(query, linkID)
-	return err
+// Click operations
+
+// clickColumns is the column list read by scanClick, qualified for queries
+// that join clicks as "c"
+const clickColumns = `c.id, c.link_id, c.ip_address, c.user_agent, c.referer,
+		COALESCE(c.country, ''), c.rule_id, c.variant_id, COALESCE(c.source, ''),
+		c.link_version, c.created_at`
+
+func scanClick(row rowScanner, click *models.Click) error {
+	return row.Scan(
+		&click.ID, &click.LinkID, &click.IPAddress, &click.UserAgent,
+		&click.Referer, &click.Country, &click.RuleID, &click.VariantID,
+		&click.Source, &click.LinkVersion, &click.CreatedAt,
+	)
 }
 
-// Click operations
 func (db *Database) CreateClick(click *models.Click) error {
 	click.ID = utils.GenerateUUID()
 	query := `
-		INSERT INTO clicks (id, link_id, ip_address, user_agent, referer, country, created_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?)`
+		INSERT INTO clicks (id, link_id, ip_address, user_agent, referer, country, rule_id, variant_id, source, link_version, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 	
 	_, err := db.Exec(query, 
 		click.ID, click.LinkID, click.IPAddress, 
-		click.UserAgent, click.Referer, click.Country, time.Now(),
+		click.UserAgent, click.Referer, click.Country, click.RuleID,
+		click.VariantID, click.Source, click.LinkVersion, time.Now(),
 	)
 	
 	return err
@@ -290,7 +2110,7 @@ func (db *Database) CreateClick(click *models.Click) error {
 
 func (db *Database) GetLinkAnalytics(linkID, userID string) ([]models.Click, error) {
 	query := `
-		SELECT c.id, c.link_id, c.ip_address, c.user_agent, c.referer, c.country, c.created_at
+		SELECT ` + clickColumns + `
 		FROM clicks c
 		JOIN links l ON c.link_id = l.id
 		WHERE l.id = ? AND l.user_id = ?
@@ -305,10 +2125,7 @@ func (db *Database) GetLinkAnalytics(linkID, userID string) ([]models.Click, err
 	var clicks []models.Click
 	for rows.Next() {
 		var click models.Click
-		err := rows.Scan(
-			&click.ID, &click.LinkID, &click.IPAddress,
-			&click.UserAgent, &click.Referer, &click.Country, &click.CreatedAt,
-		)
+		err := scanClick(rows, &click)
 		if err != nil {
 			return nil, err
 		}
@@ -318,6 +2135,89 @@ func (db *Database) GetLinkAnalytics(linkID, userID string) ([]models.Click, err
 	return clicks, nil
 }
 
+// GetLinkVariantStats counts recorded clicks for each of a link's current
+// variants, including variants that have not been served yet, followed by
+// variants that have since been removed but still have clicks. Removed
+// variants are described by the latest version of the link that had them.
+func (db *Database) GetLinkVariantStats(linkID, userID string) ([]models.VariantStats, error) {
+	query := `
+		SELECT v.id, COALESCE(v.label, ''), v.destination_url, v.weight, COUNT(c.id), 0 AS removed, v.position AS ord
+		FROM link_variants v
+		JOIN links l ON v.link_id = l.id
+		LEFT JOIN clicks c ON c.link_id = v.link_id AND c.variant_id = v.id
+		WHERE l.id = ? AND l.user_id = ?
+		GROUP BY v.id
+		UNION ALL
+		SELECT c.variant_id,
+			COALESCE((SELECT json_extract(j.value, '$.label') FROM link_versions lv, json_each(lv.variants) j
+				WHERE lv.link_id = c.link_id AND json_extract(j.value, '$.id') = c.variant_id
+				ORDER BY lv.version DESC LIMIT 1), ''),
+			COALESCE((SELECT json_extract(j.value, '$.destination_url') FROM link_versions lv, json_each(lv.variants) j
+				WHERE lv.link_id = c.link_id AND json_extract(j.value, '$.id') = c.variant_id
+				ORDER BY lv.version DESC LIMIT 1), ''),
+			COALESCE((SELECT json_extract(j.value, '$.weight') FROM link_versions lv, json_each(lv.variants) j
+				WHERE lv.link_id = c.link_id AND json_extract(j.value, '$.id') = c.variant_id
+				ORDER BY lv.version DESC LIMIT 1), 0),
+			COUNT(c.id), 1 AS removed, MIN(c.created_at) AS ord
+		FROM clicks c
+		JOIN links l ON c.link_id = l.id
+		WHERE l.id = ? AND l.user_id = ? AND c.variant_id IS NOT NULL
+			AND c.variant_id NOT IN (SELECT id FROM link_variants WHERE link_id = l.id)
+		GROUP BY c.variant_id
+		ORDER BY removed ASC, ord ASC`
+	
+	rows, err := db.Query(query, linkID, userID, linkID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	
+	stats := []models.VariantStats{}
+	for rows.Next() {
+		var vs models.VariantStats
+		var ord interface{}
+		err := rows.Scan(&vs.VariantID, &vs.Label, &vs.DestinationURL, &vs.Weight, &vs.Clicks, &vs.Removed, &ord)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, vs)
+	}
+	
+	return stats, nil
+}
+
+// GetLinkVersionStats counts recorded clicks against each version of a
+// link, oldest version first. Clicks from before history was kept are not
+// counted against any version.
+func (db *Database) GetLinkVersionStats(linkID, userID string) ([]models.VersionStats, error) {
+	query := `
+		SELECT v.version, v.original_url, v.created_at, COUNT(c.id)
+		FROM link_versions v
+		JOIN links l ON v.link_id = l.id
+		LEFT JOIN clicks c ON c.link_id = v.link_id AND c.link_version = v.version
+		WHERE l.id = ? AND l.user_id = ?
+		GROUP BY v.version
+		ORDER BY v.version ASC`
+	
+	rows, err := db.Query(query, linkID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	
+	stats := []models.VersionStats{}
+	for rows.Next() {
+		var vs models.VersionStats
+		err := rows.Scan(&vs.Version, &vs.OriginalURL, &vs.CreatedAt, &vs.Clicks)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, vs)
+	}
+	
+	return stats, nil
+}
+
 func (db *Database) GetUserAnalytics(userID string) (*models.UserAnalytics, error) {
 	analytics := &models.UserAnalytics{
 		UserID:          userID,
@@ -330,7 +2230,7 @@ func (db *Database) GetUserAnalytics(userID string) (*models.UserAnalytics, erro
 	}
 
 	// Get total links count
-	err := db.QueryRow("SELECT COUNT(*) FROM links WHERE user_id = ?", userID).Scan(&analytics.TotalLinks)
+	err := db.QueryRow("SELECT COUNT(*) FROM links WHERE user_id = ? AND deleted_at IS NULL", userID).Scan(&analytics.TotalLinks)
 	if err != nil {
 		return nil, err
 	}
@@ -380,7 +2280,7 @@ func (db *Database) GetUserAnalytics(userID string) (*models.UserAnalytics, erro
 		       COALESCE(sc.short_code, ''), l.clicks
 		FROM links l
 		LEFT JOIN short_codes sc ON l.id = sc.link_id AND sc.is_primary = 1
-		WHERE l.user_id = ?
+		WHERE l.user_id = ? AND l.deleted_at IS NULL
 		ORDER BY l.clicks DESC
 		LIMIT 10`
 	
@@ -401,7 +2301,7 @@ func (db *Database) GetUserAnalytics(userID string) (*models.UserAnalytics, erro
 
 	// Get recent clicks (last 50)
 	recentClicksQuery := `
-		SELECT c.id, c.link_id, c.ip_address, c.user_agent, c.referer, c.country, c.created_at
+		SELECT ` + clickColumns + `
 		FROM clicks c
 		JOIN links l ON c.link_id = l.id
 		WHERE l.user_id = ?
@@ -416,8 +2316,7 @@ func (db *Database) GetUserAnalytics(userID string) (*models.UserAnalytics, erro
 
 	for rows.Next() {
 		var click models.Click
-		err := rows.Scan(&click.ID, &click.LinkID, &click.IPAddress, 
-			&click.UserAgent, &click.Referer, &click.Country, &click.CreatedAt)
+		err := scanClick(rows, &click)
 		if err != nil {
 			return nil, err
 		}
@@ -527,20 +2426,36 @@ func (db *Database) GetUserAnalytics(userID string) (*models.UserAnalytics, erro
 }
 
 // File operations
+
+// fileColumns is the column list read by scanFile
+const fileColumns = `id, user_id, domain_id, filename, original_name, mime_type, file_size,
+		s3_key, s3_bucket, title, description, downloads, analytics, is_public,
+		password, starts_at, expires_at, created_at, updated_at, deleted_at`
+
+func scanFile(row rowScanner, file *models.File) error {
+	return row.Scan(
+		&file.ID, &file.UserID, &file.DomainID, &file.Filename, &file.OriginalName,
+		&file.MimeType, &file.FileSize, &file.S3Key, &file.S3Bucket, &file.Title,
+		&file.Description, &file.Downloads, &file.Analytics, &file.IsPublic,
+		&file.Password, &file.StartsAt, &file.ExpiresAt, &file.CreatedAt, &file.UpdatedAt,
+		&file.DeletedAt,
+	)
+}
+
 func (db *Database) CreateFile(file *models.File) error {
 	file.ID = utils.GenerateUUID()
 	query := `
 		INSERT INTO files (id, user_id, domain_id, filename, original_name, mime_type, 
 						  file_size, s3_key, s3_bucket, title, description, analytics, 
-						  is_public, password, expires_at, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+						  is_public, password, starts_at, expires_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 	
-	now := time.Now()
+	now := time.Now().UTC()
 	_, err := db.Exec(query,
 		file.ID, file.UserID, file.DomainID, file.Filename, file.OriginalName,
 		file.MimeType, file.FileSize, file.S3Key, file.S3Bucket, file.Title,
 		file.Description, file.Analytics, file.IsPublic, file.Password,
-		file.ExpiresAt, now, now,
+		utc(file.StartsAt), utc(file.ExpiresAt), now, now,
 	)
 	if err != nil {
 		return err
@@ -551,32 +2466,38 @@ func (db *Database) CreateFile(file *models.File) error {
 	return nil
 }
 
+// CreateFileShortCode adds a short code for a file in the file's domain namespace
 func (db *Database) CreateFileShortCode(fileID, shortCode string, isPrimary bool) error {
+	defer db.invalidateShortCode(shortCode)
+	defer db.invalidateFile(fileID)
 	id := utils.GenerateUUID()
 	query := `
-		INSERT INTO short_codes (id, file_id, short_code, is_primary, created_at)
-		VALUES (?, ?, ?, ?, ?)`
+		INSERT INTO short_codes (id, file_id, domain_id, short_code, is_primary, created_at)
+		VALUES (?, ?, (SELECT domain_id FROM files WHERE id = ?), ?, ?, ?)`
 	
-	_, err := db.Exec(query, id, fileID, shortCode, isPrimary, time.Now())
+	_, err := db.Exec(query, id, fileID, fileID, shortCode, isPrimary, time.Now())
 	return err
 }
 
-func (db *Database) GetFileByShortCode(shortCode string) (*models.File, error) {
+// queryFileByShortCode loads the file behind a short code for
+// GetFileByShortCode, bypassing the cache, in the same way as
+// queryLinkByShortCode
+func (db *Database) queryFileByShortCode(shortCode string, domainID *string) (*models.File, error) {
 	file := &models.File{}
 	query := `
-		SELECT f.id, f.user_id, f.domain_id, f.filename, f.original_name, f.mime_type,
-			   f.file_size, f.s3_key, f.s3_bucket, f.title, f.description, f.downloads,
-			   f.analytics, f.is_public, f.password, f.expires_at, f.created_at, f.updated_at
-		FROM files f
-		JOIN short_codes sc ON f.id = sc.file_id
-		WHERE sc.short_code = ?`
-	
-	err := db.QueryRow(query, shortCode).Scan(
-		&file.ID, &file.UserID, &file.DomainID, &file.Filename, &file.OriginalName,
-		&file.MimeType, &file.FileSize, &file.S3Key, &file.S3Bucket, &file.Title,
-		&file.Description, &file.Downloads, &file.Analytics, &file.IsPublic,
-		&file.Password, &file.ExpiresAt, &file.CreatedAt, &file.UpdatedAt,
-	)
+		SELECT ` + fileColumns + `
+		FROM files
+		WHERE deleted_at IS NULL AND id = (
+			SELECT file_id FROM short_codes
+			WHERE short_code = ? AND file_id IS NOT NULL
+			  AND (domain_id = ? OR (domain_id IS NULL AND (? IS NULL OR EXISTS (
+				SELECT 1 FROM domains WHERE id = ? AND (is_default OR use_default_codes)
+			  ))))
+			ORDER BY domain_id IS NULL
+			LIMIT 1
+		)`
+	
+	err := scanFile(db.QueryRow(query, shortCode, domainID, domainID, domainID), file)
 	if err != nil {
 		return nil, err
 	}
@@ -592,7 +2513,7 @@ func (db *Database) GetFileByShortCode(shortCode string) (*models.File, error) {
 
 func (db *Database) GetShortCodesByFileID(fileID string) ([]models.ShortCode, error) {
 	query := `
-		SELECT id, file_id, short_code, is_primary, created_at
+		SELECT id, file_id, domain_id, short_code, is_primary, created_at
 		FROM short_codes WHERE file_id = ?
 		ORDER BY is_primary DESC, created_at ASC`
 	
@@ -606,7 +2527,7 @@ func (db *Database) GetShortCodesByFileID(fileID string) ([]models.ShortCode, er
 	for rows.Next() {
 		var sc models.ShortCode
 		var fileID sql.NullString
-		err := rows.Scan(&sc.ID, &fileID, &sc.ShortCode, &sc.IsPrimary, &sc.CreatedAt)
+		err := rows.Scan(&sc.ID, &fileID, &sc.DomainID, &sc.ShortCode, &sc.IsPrimary, &sc.CreatedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -619,16 +2540,19 @@ func (db *Database) GetShortCodesByFileID(fileID string) ([]models.ShortCode, er
 	return shortCodes, nil
 }
 
-func (db *Database) GetUserFiles(userID string, limit, offset int) ([]models.File, error) {
+// GetUserFiles lists a user's files matching opts
+func (db *Database) GetUserFiles(userID string, opts *models.ListOptions) ([]models.File, error) {
+	where, order, args, err := fileListing.clauses(userID, opts, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
 	query := `
-		SELECT id, user_id, domain_id, filename, original_name, mime_type, file_size,
-			   s3_key, s3_bucket, title, description, downloads, analytics, is_public,
-			   password, expires_at, created_at, updated_at
-		FROM files WHERE user_id = ?
-		ORDER BY created_at DESC
+		SELECT ` + fileColumns + `
+		FROM files WHERE ` + where + `
+		ORDER BY ` + order + `
 		LIMIT ? OFFSET ?`
 	
-	rows, err := db.Query(query, userID, limit, offset)
+	rows, err := db.Query(query, append(args, opts.Limit, opts.Offset)...)
 	if err != nil {
 		return nil, err
 	}
@@ -637,70 +2561,71 @@ func (db *Database) GetUserFiles(userID string, limit, offset int) ([]models.Fil
 	var files []models.File
 	for rows.Next() {
 		var file models.File
-		err := rows.Scan(
-			&file.ID, &file.UserID, &file.DomainID, &file.Filename, &file.OriginalName,
-			&file.MimeType, &file.FileSize, &file.S3Key, &file.S3Bucket, &file.Title,
-			&file.Description, &file.Downloads, &file.Analytics, &file.IsPublic,
-			&file.Password, &file.ExpiresAt, &file.CreatedAt, &file.UpdatedAt,
-		)
+		err := scanFile(rows, &file)
 		if err != nil {
 			return nil, err
 		}
 		
-		// Load short codes for each file
+		// Load short codes and tags for each file
 		shortCodes, err := db.GetShortCodesByFileID(file.ID)
 		if err == nil {
 			file.ShortCodes = shortCodes
 		}
+		tags, err := db.GetFileTags(file.ID)
+		if err == nil {
+			file.Tags = tags
+		}
 		
 		files = append(files, file)
 	}
 	
-	return files, nil
+	return files, rows.Err()
 }
 
 func (db *Database) GetFileByID(fileID, userID string) (*models.File, error) {
 	file := &models.File{}
 	query := `
-		SELECT id, user_id, domain_id, filename, original_name, mime_type, file_size,
-			   s3_key, s3_bucket, title, description, downloads, analytics, is_public,
-			   password, expires_at, created_at, updated_at
-		FROM files WHERE id = ? AND user_id = ?`
+		SELECT ` + fileColumns + `
+		FROM files WHERE id = ? AND user_id = ? AND deleted_at IS NULL`
 	
-	err := db.QueryRow(query, fileID, userID).Scan(
-		&file.ID, &file.UserID, &file.DomainID, &file.Filename, &file.OriginalName,
-		&file.MimeType, &file.FileSize, &file.S3Key, &file.S3Bucket, &file.Title,
-		&file.Description, &file.Downloads, &file.Analytics, &file.IsPublic,
-		&file.Password, &file.ExpiresAt, &file.CreatedAt, &file.UpdatedAt,
-	)
+	err := scanFile(db.QueryRow(query, fileID, userID), file)
 	if err != nil {
 		return nil, err
 	}
 	
-	// Load short codes
+	// Load short codes and tags
 	shortCodes, err := db.GetShortCodesByFileID(file.ID)
 	if err == nil {
 		file.ShortCodes = shortCodes
 	}
+	tags, err := db.GetFileTags(file.ID)
+	if err == nil {
+		file.Tags = tags
+	}
 	
 	return file, nil
 }
 
 func (db *Database) UpdateFile(fileID, userID string, updates *models.UpdateFileRequest) error {
+	defer db.invalidateFile(fileID)
+
 	query := `
 		UPDATE files
 		SET title = COALESCE(?, title),
 			description = COALESCE(?, description),
-			analytics = ?,
-			is_public = ?,
-			password = COALESCE(?, password),
-			expires_at = COALESCE(?, expires_at),
+			analytics = COALESCE(?, analytics),
+			is_public = COALESCE(?, is_public),
+			password = CASE WHEN ? THEN NULL ELSE COALESCE(?, password) END,
+			starts_at = CASE WHEN ? THEN NULL ELSE COALESCE(?, starts_at) END,
+			expires_at = CASE WHEN ? THEN NULL ELSE COALESCE(?, expires_at) END,
 			updated_at = ?
-		WHERE id = ? AND user_id = ?`
+		WHERE id = ? AND user_id = ? AND deleted_at IS NULL`
 	
 	result, err := db.Exec(query,
 		updates.Title, updates.Description, updates.Analytics,
-		updates.IsPublic, updates.Password, updates.ExpiresAt,
+		updates.IsPublic, updates.Clears(models.ClearPassword), updates.Password,
+		updates.Clears(models.ClearStartsAt), utc(updates.StartsAt),
+		updates.Clears(models.ClearExpiresAt), utc(updates.ExpiresAt),
 		time.Now(), fileID, userID,
 	)
 	if err != nil {
@@ -720,6 +2645,8 @@ func (db *Database) UpdateFile(fileID, userID string, updates *models.UpdateFile
 }
 
 func (db *Database) DeleteFile(fileID, userID string) error {
+	defer db.invalidateFile(fileID)
+
 	query := `DELETE FROM files WHERE id = ? AND user_id = ?`
 	result, err := db.Exec(query, fileID, userID)
 	if err != nil {
@@ -748,20 +2675,102 @@ func (db *Database) IncrementFileDownloads(fileID string) error {
 func (db *Database) CreateFileDownload(download *models.FileDownload) error {
 	download.ID = utils.GenerateUUID()
 	query := `
-		INSERT INTO file_downloads (id, file_id, ip_address, user_agent, referer, country, created_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?)`
+		INSERT INTO file_downloads (id, file_id, ip_address, user_agent, referer, country, source, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
 	
 	_, err := db.Exec(query,
 		download.ID, download.FileID, download.IPAddress,
-		download.UserAgent, download.Referer, download.Country, time.Now(),
+		download.UserAgent, download.Referer, download.Country, download.Source, time.Now(),
 	)
 	
 	return err
 }
 
+// EventBatch is a set of clicks and downloads written together by
+// RecordEvents
+type EventBatch struct {
+	LinkClicks    map[string]int // Clicks to add to each link's count
+	Clicks        []*models.Click
+	FileDownloads map[string]int // Downloads to add to each file's count
+	Downloads     []*models.FileDownload
+}
+
+// RecordEvents writes a batch of clicks and downloads in one transaction.
+// Counts are added to links and files that still exist; click and download
+// rows keep the CreatedAt they were given.
+func (db *Database) RecordEvents(batch *EventBatch) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for linkID, clicks := range batch.LinkClicks {
+		if _, err := tx.Exec(`UPDATE links SET clicks = clicks + ? WHERE id = ?`, clicks, linkID); err != nil {
+			return err
+		}
+	}
+	for fileID, downloads := range batch.FileDownloads {
+		if _, err := tx.Exec(`UPDATE files SET downloads = downloads + ? WHERE id = ?`, downloads, fileID); err != nil {
+			return err
+		}
+	}
+
+	// Links and files deleted since the event was queued are skipped rather
+	// than failing the whole batch on the foreign key
+	if len(batch.Clicks) > 0 {
+		stmt, err := tx.Prepare(`
+			INSERT INTO clicks (id, link_id, ip_address, user_agent, referer, country, rule_id, variant_id, source, link_version, created_at)
+			SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+			WHERE EXISTS (SELECT 1 FROM links WHERE id = ?)`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for _, click := range batch.Clicks {
+			click.ID = utils.GenerateUUID()
+			_, err := stmt.Exec(
+				click.ID, click.LinkID, click.IPAddress,
+				click.UserAgent, click.Referer, click.Country, click.RuleID,
+				click.VariantID, click.Source, click.LinkVersion, click.CreatedAt,
+				click.LinkID,
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(batch.Downloads) > 0 {
+		stmt, err := tx.Prepare(`
+			INSERT INTO file_downloads (id, file_id, ip_address, user_agent, referer, country, source, created_at)
+			SELECT ?, ?, ?, ?, ?, ?, ?, ?
+			WHERE EXISTS (SELECT 1 FROM files WHERE id = ?)`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for _, download := range batch.Downloads {
+			download.ID = utils.GenerateUUID()
+			_, err := stmt.Exec(
+				download.ID, download.FileID, download.IPAddress,
+				download.UserAgent, download.Referer, download.Country, download.Source, download.CreatedAt,
+				download.FileID,
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
 func (db *Database) GetFileAnalytics(fileID, userID string) ([]models.FileDownload, error) {
 	query := `
-		SELECT fd.id, fd.file_id, fd.ip_address, fd.user_agent, fd.referer, fd.country, fd.created_at
+		SELECT fd.id, fd.file_id, fd.ip_address, fd.user_agent, fd.referer, fd.country, COALESCE(fd.source, ''), fd.created_at
 		FROM file_downloads fd
 		JOIN files f ON fd.file_id = f.id
 		WHERE f.id = ? AND f.user_id = ?
@@ -778,7 +2787,7 @@ func (db *Database) GetFileAnalytics(fileID, userID string) ([]models.FileDownlo
 		var download models.FileDownload
 		err := rows.Scan(
 			&download.ID, &download.FileID, &download.IPAddress,
-			&download.UserAgent, &download.Referer, &download.Country, &download.CreatedAt,
+			&download.UserAgent, &download.Referer, &download.Country, &download.Source, &download.CreatedAt,
 		)
 		if err != nil {
 			return nil, err
@@ -797,7 +2806,7 @@ func (db *Database) GetUserFileAnalytics(userID string) ([]models.UserFileStats,
 			COUNT(fd.id) as recent_downloads
 		FROM files f
 		LEFT JOIN file_downloads fd ON f.id = fd.file_id AND fd.created_at > datetime('now', '-30 days')
-		WHERE f.user_id = ?
+		WHERE f.user_id = ? AND f.deleted_at IS NULL
 		GROUP BY f.id, f.filename, f.original_name, f.mime_type, f.file_size, f.downloads, f.created_at
 		ORDER BY f.downloads DESC, f.created_at DESC`
 	
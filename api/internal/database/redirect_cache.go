@@ -0,0 +1,181 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"linker/internal/cache"
+	"linker/internal/models"
+)
+
+// Cache tags. Entries for a short code are tagged with the code in every
+// domain, since a code in the default namespace also answers for domains
+// without their own, and hits are tagged with the link or file they hold.
+// Lookups on a domain are also tagged, since changing the domain settings
+// decides whether they fall back to the default namespace.
+const (
+	missingTag   = "missing"
+	shortCodeTag = "code:"
+	ownerTag     = "id:"
+	domainTag    = "domain"
+)
+
+// RedirectCacheStats reports the counters of the link and file caches
+type RedirectCacheStats struct {
+	Enabled bool        `json:"enabled"`
+	Links   cache.Stats `json:"links"`
+	Files   cache.Stats `json:"files"`
+}
+
+// EnableRedirectCache caches GetLinkByShortCode and GetFileByShortCode.
+// Each keeps up to size short codes for ttl, and codes that matched nothing
+// for negativeTTL. Writes made through this Database invalidate the cache;
+// other writers, such as another process, are only seen once entries
+// expire.
+func (db *Database) EnableRedirectCache(size int, ttl, negativeTTL time.Duration) {
+	db.linkCache = cache.New(size, ttl, negativeTTL)
+	db.fileCache = cache.New(size, ttl, negativeTTL)
+}
+
+func (db *Database) RedirectCacheStats() RedirectCacheStats {
+	if db.linkCache == nil {
+		return RedirectCacheStats{}
+	}
+	return RedirectCacheStats{
+		Enabled: true,
+		Links:   db.linkCache.Stats(),
+		Files:   db.fileCache.Stats(),
+	}
+}
+
+// GetLinkByShortCode resolves a short code within a domain namespace, falling
+// back to the default namespace when the domain has no matching code and is
+// the default domain or has use_default_codes set.
+func (db *Database) GetLinkByShortCode(shortCode string, domainID *string) (*models.Link, error) {
+	if db.linkCache == nil {
+		return db.queryLinkByShortCode(shortCode, domainID)
+	}
+
+	key := redirectCacheKey(shortCode, domainID)
+	if value, found := db.linkCache.Get(key); found {
+		if value == nil {
+			return nil, sql.ErrNoRows
+		}
+		// Callers get their own copy so they can't change the cached link
+		link := *value.(*models.Link)
+		return &link, nil
+	}
+
+	generation := db.linkCache.Generation()
+	link, err := db.queryLinkByShortCode(shortCode, domainID)
+	if err == sql.ErrNoRows {
+		db.linkCache.Set(key, nil, lookupTags(domainID, shortCodeTag+shortCode, missingTag), time.Time{}, generation)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cached := *link
+	tags := lookupTags(domainID, shortCodeTag+shortCode, ownerTag+link.ID)
+	db.linkCache.Set(key, &cached, tags, nextTransition(link.StartsAt, link.ExpiresAt), generation)
+	return link, nil
+}
+
+// GetFileByShortCode resolves a short code within a domain namespace in the
+// same way as GetLinkByShortCode
+func (db *Database) GetFileByShortCode(shortCode string, domainID *string) (*models.File, error) {
+	if db.fileCache == nil {
+		return db.queryFileByShortCode(shortCode, domainID)
+	}
+
+	key := redirectCacheKey(shortCode, domainID)
+	if value, found := db.fileCache.Get(key); found {
+		if value == nil {
+			return nil, sql.ErrNoRows
+		}
+		file := *value.(*models.File)
+		return &file, nil
+	}
+
+	generation := db.fileCache.Generation()
+	file, err := db.queryFileByShortCode(shortCode, domainID)
+	if err == sql.ErrNoRows {
+		db.fileCache.Set(key, nil, lookupTags(domainID, shortCodeTag+shortCode, missingTag), time.Time{}, generation)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cached := *file
+	tags := lookupTags(domainID, shortCodeTag+shortCode, ownerTag+file.ID)
+	db.fileCache.Set(key, &cached, tags, nextTransition(file.StartsAt, file.ExpiresAt), generation)
+	return file, nil
+}
+
+// invalidateLink drops cached lookups that returned the link
+func (db *Database) invalidateLink(linkID string) {
+	if db.linkCache != nil {
+		db.linkCache.Invalidate(ownerTag + linkID)
+	}
+}
+
+// invalidateFile drops cached lookups that returned the file
+func (db *Database) invalidateFile(fileID string) {
+	if db.fileCache != nil {
+		db.fileCache.Invalidate(ownerTag + fileID)
+	}
+}
+
+// invalidateShortCode drops cached lookups of a short code in any domain,
+// including remembered misses, once it is added or removed
+func (db *Database) invalidateShortCode(shortCode string) {
+	if db.linkCache != nil {
+		db.linkCache.Invalidate(shortCodeTag + shortCode)
+		db.fileCache.Invalidate(shortCodeTag + shortCode)
+	}
+}
+
+// invalidateMissing drops every remembered miss, for when codes that
+// matched nothing start resolving again without being added
+func (db *Database) invalidateMissing() {
+	if db.linkCache != nil {
+		db.linkCache.Invalidate(missingTag)
+		db.fileCache.Invalidate(missingTag)
+	}
+}
+
+// invalidateDomains drops every lookup made on a domain, once domains are
+// added, changed or removed
+func (db *Database) invalidateDomains() {
+	if db.linkCache != nil {
+		db.linkCache.Invalidate(domainTag)
+		db.fileCache.Invalidate(domainTag)
+	}
+}
+
+func lookupTags(domainID *string, tags ...string) []string {
+	if domainID != nil {
+		tags = append(tags, domainTag)
+	}
+	return tags
+}
+
+func redirectCacheKey(shortCode string, domainID *string) string {
+	if domainID == nil {
+		return "/" + shortCode
+	}
+	return *domainID + "/" + shortCode
+}
+
+// nextTransition is when a cached link or file next becomes active or
+// expires, so the entry is dropped then; zero when neither is ahead
+func nextTransition(startsAt, expiresAt *time.Time) time.Time {
+	now := time.Now()
+	if startsAt != nil && startsAt.After(now) {
+		return *startsAt
+	}
+	if expiresAt != nil && expiresAt.After(now) {
+		return *expiresAt
+	}
+	return time.Time{}
+}
@@ -0,0 +1,288 @@
+package events
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"linker/internal/config"
+	"linker/internal/database"
+	"linker/internal/models"
+)
+
+// Stats are the recorder's counters, used to size its queue and batches
+type Stats struct {
+	Queued   int    `json:"queued"` // Events waiting to be written
+	Capacity int    `json:"capacity"`
+	Enqueued uint64 `json:"enqueued"`
+	// Events that had to wait for room in the queue before being accepted
+	Waited  uint64 `json:"waited"`
+	Dropped uint64 `json:"dropped"` // Events lost because the queue stayed full
+	Written uint64 `json:"written"`
+	Failed  uint64 `json:"failed"` // Events lost because their batch failed to write
+	Batches uint64 `json:"batches"`
+
+	LastFlushAt *time.Time `json:"last_flush_at,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+// event is one click or download. A click may only add to the link's count,
+// only record a row, or both.
+type event struct {
+	linkID    string
+	countLink bool
+	click     *models.Click
+
+	fileID   string
+	download *models.FileDownload
+}
+
+// Recorder queues clicks and downloads and writes them in batches from a
+// single worker, so requests never wait on the database to count a visit.
+// Events still queued when Stop is called are written before it returns.
+type Recorder struct {
+	db        *database.Database
+	queue     chan event
+	batchSize int
+	interval  time.Duration
+	maxWait   time.Duration
+
+	enqueued, waited, dropped atomic.Uint64
+	written, failed, batches  atomic.Uint64
+	droppedSinceLastFlush     atomic.Uint64
+
+	mu          sync.Mutex
+	lastFlushAt time.Time
+	lastError   string
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewRecorder(db *database.Database, cfg *config.Config) *Recorder {
+	ec := cfg.Events
+	defaults := config.DefaultEventsConfig()
+	if ec.QueueSize <= 0 {
+		ec.QueueSize = defaults.QueueSize
+	}
+	if ec.BatchSize <= 0 {
+		ec.BatchSize = defaults.BatchSize
+	}
+	if ec.FlushIntervalMs <= 0 {
+		ec.FlushIntervalMs = defaults.FlushIntervalMs
+	}
+	if ec.MaxWaitMs < 0 {
+		ec.MaxWaitMs = 0
+	}
+
+	return &Recorder{
+		db:        db,
+		queue:     make(chan event, ec.QueueSize),
+		batchSize: ec.BatchSize,
+		interval:  time.Duration(ec.FlushIntervalMs) * time.Millisecond,
+		maxWait:   time.Duration(ec.MaxWaitMs) * time.Millisecond,
+	}
+}
+
+// RecordClick queues a click on a link. count adds it to the link's click
+// count; click, when not nil, is stored for analytics.
+func (r *Recorder) RecordClick(linkID string, count bool, click *models.Click) {
+	if !count && click == nil {
+		return
+	}
+	if click != nil && click.CreatedAt.IsZero() {
+		click.CreatedAt = time.Now()
+	}
+	r.enqueue(event{linkID: linkID, countLink: count, click: click})
+}
+
+// RecordDownload queues a download of a file, adding it to the file's
+// download count. download, when not nil, is stored for analytics.
+func (r *Recorder) RecordDownload(fileID string, download *models.FileDownload) {
+	if download != nil && download.CreatedAt.IsZero() {
+		download.CreatedAt = time.Now()
+	}
+	r.enqueue(event{fileID: fileID, download: download})
+}
+
+// enqueue adds an event without blocking, or waiting at most maxWait for
+// room, and drops it when the queue is still full
+func (r *Recorder) enqueue(e event) {
+	select {
+	case r.queue <- e:
+		r.enqueued.Add(1)
+		return
+	default:
+	}
+
+	if r.maxWait > 0 {
+		timer := time.NewTimer(r.maxWait)
+		defer timer.Stop()
+
+		select {
+		case r.queue <- e:
+			r.enqueued.Add(1)
+			r.waited.Add(1)
+			return
+		case <-timer.C:
+		}
+	}
+
+	r.dropped.Add(1)
+	r.droppedSinceLastFlush.Add(1)
+}
+
+// Start writes queued events whenever a batch fills up and every flush
+// interval until Stop
+func (r *Recorder) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	r.done = make(chan struct{})
+
+	go func() {
+		defer close(r.done)
+
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		batch := make([]event, 0, r.batchSize)
+		for {
+			select {
+			case e := <-r.queue:
+				batch = append(batch, e)
+				if len(batch) >= r.batchSize {
+					batch = r.flush(batch)
+				}
+			case <-ticker.C:
+				batch = r.flush(batch)
+			case <-ctx.Done():
+				// Write whatever is left so a shutdown loses nothing that
+				// was accepted
+				for {
+					select {
+					case e := <-r.queue:
+						batch = append(batch, e)
+						if len(batch) >= r.batchSize {
+							batch = r.flush(batch)
+						}
+					default:
+						r.flush(batch)
+						return
+					}
+				}
+			}
+		}
+	}()
+}
+
+// Stop writes the events still queued and waits for the worker to exit.
+// Events recorded after Stop are not written.
+func (r *Recorder) Stop() {
+	if r.cancel == nil {
+		return
+	}
+	r.cancel()
+	<-r.done
+}
+
+// Flush writes a batch of queued events straight away. It is for recorders
+// that were never started, such as in tests, and returns how many events
+// were written.
+func (r *Recorder) Flush() int {
+	batch := make([]event, 0, r.batchSize)
+drain:
+	for len(batch) < r.batchSize {
+		select {
+		case e := <-r.queue:
+			batch = append(batch, e)
+		default:
+			break drain
+		}
+	}
+
+	before := r.written.Load()
+	r.flush(batch)
+	return int(r.written.Load() - before)
+}
+
+// flush writes a batch in one transaction and returns it emptied for reuse.
+// A batch that fails to write is logged and discarded rather than retried,
+// so a broken database can't back up the queue.
+func (r *Recorder) flush(batch []event) []event {
+	if dropped := r.droppedSinceLastFlush.Swap(0); dropped > 0 {
+		log.Printf("Dropped %d clicks and downloads because the event queue was full", dropped)
+	}
+	if len(batch) == 0 {
+		return batch
+	}
+
+	records := &database.EventBatch{
+		LinkClicks:    make(map[string]int),
+		FileDownloads: make(map[string]int),
+	}
+	for _, e := range batch {
+		if e.linkID != "" {
+			if e.countLink {
+				records.LinkClicks[e.linkID]++
+			}
+			if e.click != nil {
+				records.Clicks = append(records.Clicks, e.click)
+			}
+		}
+		if e.fileID != "" {
+			records.FileDownloads[e.fileID]++
+			if e.download != nil {
+				records.Downloads = append(records.Downloads, e.download)
+			}
+		}
+	}
+
+	err := r.db.RecordEvents(records)
+
+	r.mu.Lock()
+	r.lastFlushAt = time.Now()
+	if err != nil {
+		r.lastError = err.Error()
+	} else {
+		r.lastError = ""
+	}
+	r.mu.Unlock()
+
+	r.batches.Add(1)
+	if err != nil {
+		r.failed.Add(uint64(len(batch)))
+		log.Printf("Failed to write %d clicks and downloads: %v", len(batch), err)
+	} else {
+		r.written.Add(uint64(len(batch)))
+	}
+
+	return batch[:0]
+}
+
+// Stats returns the recorder's counters so far
+func (r *Recorder) Stats() Stats {
+	stats := Stats{
+		Capacity: cap(r.queue),
+		Enqueued: r.enqueued.Load(),
+		Waited:   r.waited.Load(),
+		Dropped:  r.dropped.Load(),
+		Written:  r.written.Load(),
+		Failed:   r.failed.Load(),
+		Batches:  r.batches.Load(),
+	}
+	// Includes the batch the worker is holding, not just the channel
+	if pending := int64(stats.Enqueued - stats.Written - stats.Failed); pending > 0 {
+		stats.Queued = int(pending)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.lastFlushAt.IsZero() {
+		lastFlushAt := r.lastFlushAt
+		stats.LastFlushAt = &lastFlushAt
+	}
+	stats.LastError = r.lastError
+	return stats
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"linker/internal/config"
+	"linker/internal/database"
+	"linker/internal/events"
+)
+
+type AdminHandler struct {
+	db     *database.Database
+	events *events.Recorder
+	config *config.Config
+}
+
+func NewAdminHandler(db *database.Database, recorder *events.Recorder, config *config.Config) *AdminHandler {
+	return &AdminHandler{
+		db:     db,
+		events: recorder,
+		config: config,
+	}
+}
+
+// Stats reports the server's runtime counters, such as redirect cache hit
+// rates and the click queue's backlog and drops, for sizing its caches and
+// queues. Only admin users may see them.
+func (h *AdminHandler) Stats(c *gin.Context) {
+	if !isAdmin(c, h.config) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"redirect_cache": h.db.RedirectCacheStats(),
+		"events":         h.events.Stats(),
+	})
+}
@@ -35,10 +35,24 @@ func (h *AnalyticsHandler) GetLinkAnalytics(c *gin.Context) {
 		return
 	}
 
+	variants, err := h.db.GetLinkVariantStats(linkID, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve analytics"})
+		return
+	}
+
+	versions, err := h.db.GetLinkVersionStats(linkID, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve analytics"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"link_id": linkID,
-		"clicks":  clicks,
-		"total":   len(clicks),
+		"link_id":  linkID,
+		"clicks":   clicks,
+		"total":    len(clicks),
+		"variants": variants,
+		"versions": versions,
 	})
 }
 
@@ -0,0 +1,299 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"linker/internal/config"
+	"linker/internal/database"
+	"linker/internal/models"
+	"linker/internal/urlpolicy"
+)
+
+var errDomainDisabled = errors.New("domain is disabled")
+
+type DomainsHandler struct {
+	db        *database.Database
+	urlPolicy *urlpolicy.Policy
+	config    *config.Config
+}
+
+func NewDomainsHandler(db *database.Database, urlPolicy *urlpolicy.Policy, config *config.Config) *DomainsHandler {
+	return &DomainsHandler{
+		db:        db,
+		urlPolicy: urlPolicy,
+		config:    config,
+	}
+}
+
+// CreateDomain, UpdateDomain and DeleteDomain change domains every user
+// shares, so only admin users may call them
+func (h *DomainsHandler) CreateDomain(c *gin.Context) {
+	if !isAdmin(c, h.config) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+		return
+	}
+
+	var req models.CreateDomainRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := normalizeHost(req.Domain)
+	if !h.isAllowedDomain(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Domain '" + name + "' is not in the allowed domains list"})
+		return
+	}
+
+	if _, err := h.db.GetDomainByName(name); err != sql.ErrNoRows {
+		c.JSON(http.StatusConflict, gin.H{"error": "Domain '" + name + "' already exists"})
+		return
+	}
+
+	if !h.checkFallbackURL(c, req.FallbackURL) || !checkBranding(c, req.Branding) {
+		return
+	}
+
+	enabled := true
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+
+	domain := &models.Domain{
+		Domain:          name,
+		IsDefault:       req.IsDefault,
+		Enabled:         enabled,
+		FallbackURL:     req.FallbackURL,
+		UseDefaultCodes: req.UseDefaultCodes,
+	}
+	if req.Branding != nil {
+		domain.Branding = *req.Branding
+	}
+
+	if err := h.db.CreateDomain(domain); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create domain"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, domain)
+}
+
+func (h *DomainsHandler) GetDomains(c *gin.Context) {
+	domains, err := h.db.GetDomains()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve domains"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"domains": domains})
+}
+
+func (h *DomainsHandler) GetDomain(c *gin.Context) {
+	domainID := c.Param("id")
+	if domainID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid domain ID"})
+		return
+	}
+
+	domain, err := h.db.GetDomainByID(domainID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Domain not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve domain"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, domain)
+}
+
+func (h *DomainsHandler) UpdateDomain(c *gin.Context) {
+	if !isAdmin(c, h.config) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+		return
+	}
+
+	domainID := c.Param("id")
+	if domainID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid domain ID"})
+		return
+	}
+
+	var req models.UpdateDomainRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.FallbackURL != nil && !h.checkFallbackURL(c, *req.FallbackURL) {
+		return
+	}
+	if !checkBranding(c, req.Branding) {
+		return
+	}
+
+	if err := h.db.UpdateDomain(domainID, &req); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Domain not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update domain"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Domain updated successfully"})
+}
+
+func (h *DomainsHandler) DeleteDomain(c *gin.Context) {
+	if !isAdmin(c, h.config) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+		return
+	}
+
+	domainID := c.Param("id")
+	if domainID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid domain ID"})
+		return
+	}
+
+	// Links and files keep a foreign key to their domain, so refuse to
+	// orphan them; the domain can be disabled instead.
+	inUse, err := h.db.CountDomainUsage(domainID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete domain"})
+		return
+	}
+	if inUse > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Domain is still used by links or files; disable it instead"})
+		return
+	}
+
+	if err := h.db.DeleteDomain(domainID); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Domain not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete domain"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Domain deleted successfully"})
+}
+
+// checkFallbackURL applies the URL policy to a domain's fallback URL,
+// writing the rejection when it fails. An empty URL clears the fallback.
+func (h *DomainsHandler) checkFallbackURL(c *gin.Context, fallbackURL string) bool {
+	if fallbackURL == "" {
+		return true
+	}
+
+	if err := h.urlPolicy.Check(fallbackURL, "", nil); err != nil {
+		if violation, ok := err.(*urlpolicy.Violation); ok {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":  violation.Message,
+				"reason": violation.Reason,
+				"field":  "fallback_url",
+			})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check fallback URL"})
+		}
+		return false
+	}
+	return true
+}
+
+// checkBranding only lets domain logos load over http or https, writing
+// the rejection when another scheme is used
+func checkBranding(c *gin.Context, branding *models.DomainBranding) bool {
+	if branding == nil || branding.LogoURL == "" {
+		return true
+	}
+
+	u, err := url.Parse(branding.LogoURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Logo URL must use http or https",
+			"field": "branding.logo_url",
+		})
+		return false
+	}
+	return true
+}
+
+func (h *DomainsHandler) isAllowedDomain(name string) bool {
+	for _, allowed := range h.config.AllowedDomains {
+		if normalizeHost(allowed) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// validateDomainID checks that a domain requested for a new link or file
+// exists and is enabled.
+func validateDomainID(db *database.Database, domainID *string) error {
+	if domainID == nil {
+		return nil
+	}
+
+	domain, err := db.GetDomainByID(*domainID)
+	if err != nil {
+		return err
+	}
+	if !domain.Enabled {
+		return errDomainDisabled
+	}
+
+	return nil
+}
+
+// resolveRequestDomain maps the request Host header to a domain record.
+// Hosts that are not registered resolve to the default domain, which is nil
+// when no domain has been marked as default. A disabled domain is returned
+// along with errDomainDisabled so its fallback URL can still be used.
+func resolveRequestDomain(db *database.Database, host string) (*models.Domain, error) {
+	name := normalizeHost(host)
+
+	domain, err := db.GetDomainByName(name)
+	if err == sql.ErrNoRows {
+		// Retry without the port so "example.com:8080" matches "example.com"
+		if hostname, _, splitErr := net.SplitHostPort(name); splitErr == nil {
+			domain, err = db.GetDomainByName(hostname)
+		}
+	}
+
+	if err == sql.ErrNoRows {
+		domain, err = db.GetDefaultDomain()
+		if err == sql.ErrNoRows || (err == nil && !domain.Enabled) {
+			return nil, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !domain.Enabled {
+		return domain, errDomainDisabled
+	}
+
+	return domain, nil
+}
+
+// domainIDOf returns the short code namespace for a resolved request domain.
+func domainIDOf(domain *models.Domain) *string {
+	if domain == nil {
+		return nil
+	}
+	return &domain.ID
+}
+
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+}
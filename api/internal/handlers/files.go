@@ -7,30 +7,47 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+	"log"
+	"net"
 	"net/http"
-	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"linker/internal/auth"
 	"linker/internal/config"
 	"linker/internal/database"
+	"linker/internal/events"
 	"linker/internal/middleware"
 	"linker/internal/models"
+	"linker/internal/pages"
+	"linker/internal/shortcode"
 	"linker/internal/storage"
 )
 
 type FilesHandler struct {
-	db       *database.Database
-	s3Client *storage.S3Client
-	config   *config.Config
+	db        *database.Database
+	s3Client  *storage.S3Client
+	generator *shortcode.Generator
+	blocklist *shortcode.Blocklist
+	pages     *pages.Renderer
+	events    *events.Recorder
+	config    *config.Config
+
+	trustedProxies []*net.IPNet
 }
 
-func NewFilesHandler(db *database.Database, s3Client *storage.S3Client, config *config.Config) *FilesHandler {
+func NewFilesHandler(db *database.Database, s3Client *storage.S3Client, generator *shortcode.Generator, blocklist *shortcode.Blocklist, renderer *pages.Renderer, recorder *events.Recorder, config *config.Config) *FilesHandler {
 	return &FilesHandler{
-		db:       db,
-		s3Client: s3Client,
-		config:   config,
+		db:        db,
+		s3Client:  s3Client,
+		generator: generator,
+		blocklist: blocklist,
+		pages:     renderer,
+		events:    recorder,
+		config:    config,
+
+		trustedProxies: parseTrustedProxies(config.TrustedProxies),
 	}
 }
 
@@ -84,22 +101,63 @@ func (h *FilesHandler) UploadFile(c *gin.Context) {
 	if domainID := c.PostForm("domain_id"); domainID != "" {
 		req.DomainID = &domainID
 	}
+	req.Tags = c.PostFormArray("tags")
+	if startsAt := c.PostForm("starts_at"); startsAt != "" {
+		parsed, err := time.Parse(time.RFC3339, startsAt)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "starts_at must be an RFC3339 timestamp"})
+			return
+		}
+		req.StartsAt = &parsed
+	}
+	if expiresAt := c.PostForm("expires_at"); expiresAt != "" {
+		parsed, err := time.Parse(time.RFC3339, expiresAt)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be an RFC3339 timestamp"})
+			return
+		}
+		req.ExpiresAt = &parsed
+	}
+
+	if !validActivationWindow(req.StartsAt, req.ExpiresAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "starts_at must be before expires_at"})
+		return
+	}
+
+	if err := validateDomainID(h.db, req.DomainID); err != nil {
+		if err == sql.ErrNoRows || err == errDomainDisabled {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Domain does not exist or is disabled"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate domain"})
+		}
+		return
+	}
 
 	// Parse short codes
 	shortCodes := c.PostFormArray("short_codes")
 	if len(shortCodes) == 0 {
 		// Generate a default short code
-		shortCodes = []string{generateFileShortCode()}
+		shortCode, err := h.generator.FileCode(shortCodeTaken(h.db, h.blocklist, req.DomainID))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate short code"})
+			return
+		}
+		shortCodes = []string{shortCode}
 	}
 	req.ShortCodes = shortCodes
 
-	// Check if short codes are already in use
+	// Check if short codes are blocked or already in use in the domain's namespace
 	for _, shortCode := range req.ShortCodes {
-		if _, err := h.db.GetLinkByShortCode(shortCode); err != sql.ErrNoRows {
-			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Short code '%s' already exists", shortCode)})
+		if !checkShortCodeAllowed(c, h.blocklist, h.config, shortCode) {
 			return
 		}
-		if _, err := h.db.GetFileByShortCode(shortCode); err != sql.ErrNoRows {
+
+		taken, err := h.db.ShortCodeExists(shortCode, req.DomainID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check short code availability"})
+			return
+		}
+		if taken {
 			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Short code '%s' already exists", shortCode)})
 			return
 		}
@@ -147,6 +205,7 @@ func (h *FilesHandler) UploadFile(c *gin.Context) {
 		Analytics:    req.Analytics,
 		IsPublic:     req.IsPublic,
 		Password:     hashedPassword,
+		StartsAt:     req.StartsAt,
 		ExpiresAt:    req.ExpiresAt,
 	}
 
@@ -168,6 +227,14 @@ func (h *FilesHandler) UploadFile(c *gin.Context) {
 		}
 	}
 
+	if len(req.Tags) > 0 {
+		if err := h.db.SetFileTags(fileRecord.ID, req.Tags); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save tags"})
+			return
+		}
+		fileRecord.Tags, _ = h.db.GetFileTags(fileRecord.ID)
+	}
+
 	// Load short codes back into file for response
 	shortCodeRecords, err := h.db.GetShortCodesByFileID(fileRecord.ID)
 	if err == nil {
@@ -231,22 +298,13 @@ func (h *FilesHandler) GetUserFiles(c *gin.Context) {
 		return
 	}
 
-	limit := 20
-	offset := 0
-
-	if l := c.Query("limit"); l != "" {
-		if parsedLimit, err := strconv.Atoi(l); err == nil && parsedLimit > 0 {
-			limit = parsedLimit
-		}
-	}
-
-	if o := c.Query("offset"); o != "" {
-		if parsedOffset, err := strconv.Atoi(o); err == nil && parsedOffset >= 0 {
-			offset = parsedOffset
-		}
+	opts, err := parseListOptions(c, models.SortDownloads)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	files, err := h.db.GetUserFiles(userID, limit, offset)
+	files, err := h.db.GetUserFiles(userID, opts)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve files"})
 		return
@@ -300,6 +358,30 @@ func (h *FilesHandler) UpdateFile(c *gin.Context) {
 		return
 	}
 
+	if field := req.SetAndCleared(); field != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": field + " can't be both set and cleared"})
+		return
+	}
+
+	// Setting one end of the window must keep it before the stored other end
+	if req.StartsAt != nil || req.ExpiresAt != nil {
+		file, err := h.db.GetFileByID(fileID, userID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve file"})
+			}
+			return
+		}
+		startsAt := updatedTime(req.StartsAt, req.Clears(models.ClearStartsAt), file.StartsAt)
+		expiresAt := updatedTime(req.ExpiresAt, req.Clears(models.ClearExpiresAt), file.ExpiresAt)
+		if !validActivationWindow(startsAt, expiresAt) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "starts_at must be before expires_at"})
+			return
+		}
+	}
+
 	// Hash password if provided
 	if req.Password != nil {
 		hashed, err := auth.HashPassword(*req.Password)
@@ -320,6 +402,14 @@ func (h *FilesHandler) UpdateFile(c *gin.Context) {
 		return
 	}
 
+	// A nil list leaves the tags alone; an empty one clears them
+	if req.Tags != nil {
+		if err := h.db.SetFileTags(fileID, req.Tags); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save tags"})
+			return
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "File updated successfully"})
 }
 
@@ -336,32 +426,17 @@ func (h *FilesHandler) DeleteFile(c *gin.Context) {
 		return
 	}
 
-	// Get file info before deletion for S3 cleanup
-	file, err := h.db.GetFileByID(fileID, userID)
-	if err != nil {
+	// The stored object is kept until the file is purged from the trash
+	if err := h.db.TrashFile(fileID, userID); err != nil {
 		if err == sql.ErrNoRows {
 			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
 		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve file"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete file"})
 		}
 		return
 	}
 
-	// Delete from database
-	err = h.db.DeleteFile(fileID, userID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete file"})
-		return
-	}
-
-	// Delete from S3
-	if h.s3Client != nil {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-		h.s3Client.Delete(ctx, file.S3Key) // Don't fail if S3 deletion fails
-	}
-
-	c.JSON(http.StatusOK, gin.H{"message": "File deleted successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "File moved to trash"})
 }
 
 func (h *FilesHandler) DownloadFile(c *gin.Context) {
@@ -371,43 +446,44 @@ func (h *FilesHandler) DownloadFile(c *gin.Context) {
 		return
 	}
 
-	// Check password if provided
-	password := c.Query("password")
-
-	file, err := h.db.GetFileByShortCode(shortCode)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
-		}
+	if strings.HasSuffix(shortCode, qrSuffix) {
+		h.serveQRCode(c, strings.TrimSuffix(shortCode, qrSuffix))
 		return
 	}
 
-	// Check if file is expired
-	if file.ExpiresAt != nil && time.Now().After(*file.ExpiresAt) {
-		c.JSON(http.StatusGone, gin.H{"error": "File has expired"})
+	file, domain, ok := h.lookupFile(c, shortCode)
+	if !ok {
 		return
 	}
 
 	// Check if file is public or password protected
 	if !file.IsPublic {
 		if file.Password == nil {
-			c.JSON(http.StatusForbidden, gin.H{"error": "File is private"})
-			return
-		}
-		
-		if password == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "Password required", 
-				"password_required": true,
-			})
+			respondUnavailable(c, h.pages, domain, http.StatusForbidden, pages.Private, "File is private", nil)
 			return
 		}
 
-		if !auth.CheckPassword(password, *file.Password) {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
-			return
+		// Browsers unlock the file through the form, which sets a cookie;
+		// scripts may still pass the password as a query parameter
+		if !h.isFileUnlocked(c, file) {
+			password := c.Query("password")
+			if password == "" {
+				if wantsHTML(c) {
+					renderUnlockForm(c, h.pages, domain, http.StatusUnauthorized, "file", "")
+				} else {
+					respondUnavailable(c, h.pages, domain, http.StatusUnauthorized, pages.Password, "Password required", gin.H{"password_required": true})
+				}
+				return
+			}
+
+			if !auth.CheckPassword(password, *file.Password) {
+				if wantsHTML(c) {
+					renderUnlockForm(c, h.pages, domain, http.StatusUnauthorized, "file", "Incorrect password")
+				} else {
+					respondUnavailable(c, h.pages, domain, http.StatusUnauthorized, pages.Password, "Invalid password", nil)
+				}
+				return
+			}
 		}
 	}
 
@@ -427,23 +503,21 @@ func (h *FilesHandler) DownloadFile(c *gin.Context) {
 		return
 	}
 
-	// Track download
-	if err := h.db.IncrementFileDownloads(file.ID); err != nil {
-		// Log error but don't fail the download
-	}
-
+	// Track download in the background
+	var download *models.FileDownload
 	if file.Analytics {
-		download := &models.FileDownload{
+		download = &models.FileDownload{
 			FileID:    file.ID,
 			IPAddress: getClientIP(c),
 			UserAgent: c.GetHeader("User-Agent"),
 			Referer:   c.GetHeader("Referer"),
+			Country:   getClientCountry(c, h.trustedProxies),
 		}
-		
-		if err := h.db.CreateFileDownload(download); err != nil {
-			// Log error but don't fail the download
+		if c.Query(qrMarkerParam) == qrMarkerValue {
+			download.Source = qrMarkerValue
 		}
 	}
+	h.events.RecordDownload(file.ID, download)
 
 	// Stream file from S3
 	if h.s3Client == nil {
@@ -470,6 +544,84 @@ func (h *FilesHandler) DownloadFile(c *gin.Context) {
 	io.Copy(c.Writer, reader)
 }
 
+// UnlockFile checks the password posted by a file's unlock form. A correct
+// password sets a signed cookie and redirects back to the download, so the
+// password never appears in the URL.
+func (h *FilesHandler) UnlockFile(c *gin.Context) {
+	file, domain, ok := h.lookupFile(c, c.Param("shortCode"))
+	if !ok {
+		return
+	}
+
+	if !file.IsPublic && file.Password != nil {
+		if !auth.CheckPassword(c.PostForm("password"), *file.Password) {
+			if wantsHTML(c) {
+				renderUnlockForm(c, h.pages, domain, http.StatusUnauthorized, "file", "Incorrect password")
+			} else {
+				respondUnavailable(c, h.pages, domain, http.StatusUnauthorized, pages.Password, "Incorrect password", nil)
+			}
+			return
+		}
+
+		token := auth.GenerateUnlockToken(file.ID, *file.Password, time.Now().Add(unlockTTL), h.config.JWTSecret)
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(unlockCookieName(file.ID), token, int(unlockTTL.Seconds()), "/", "", c.Request.TLS != nil, true)
+	}
+
+	c.Redirect(http.StatusSeeOther, c.Request.URL.RequestURI())
+}
+
+// lookupFile resolves the short code for the request host and rejects files
+// that are not active. It writes the error response itself and reports
+// whether to continue.
+func (h *FilesHandler) lookupFile(c *gin.Context, shortCode string) (*models.File, *models.Domain, bool) {
+	domain, err := resolveRequestDomain(h.db, c.Request.Host)
+	if err != nil {
+		if err == errDomainDisabled {
+			respondUnavailable(c, h.pages, domain, http.StatusNotFound, pages.NotFound, "File not found", nil)
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return nil, nil, false
+	}
+
+	file, err := h.db.GetFileByShortCode(shortCode, domainIDOf(domain))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondUnavailable(c, h.pages, domain, http.StatusNotFound, pages.NotFound, "File not found", nil)
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return nil, nil, false
+	}
+
+	// Check if file is not yet published
+	if file.StartsAt != nil && time.Now().Before(*file.StartsAt) {
+		respondUnavailable(c, h.pages, domain, http.StatusForbidden, pages.Unavailable, "File is not yet available", gin.H{
+			"starts_at": file.StartsAt,
+		})
+		return nil, nil, false
+	}
+
+	// Check if file is expired
+	if file.ExpiresAt != nil && time.Now().After(*file.ExpiresAt) {
+		respondUnavailable(c, h.pages, domain, http.StatusGone, pages.Expired, "File has expired", nil)
+		return nil, nil, false
+	}
+
+	return file, domain, true
+}
+
+// isFileUnlocked reports whether the visitor holds a valid unlock cookie for
+// the file's current password
+func (h *FilesHandler) isFileUnlocked(c *gin.Context, file *models.File) bool {
+	token, err := c.Cookie(unlockCookieName(file.ID))
+	if err != nil || file.Password == nil {
+		return false
+	}
+	return auth.ValidateUnlockToken(token, file.ID, *file.Password, h.config.JWTSecret)
+}
+
 func (h *FilesHandler) GetFileAnalytics(c *gin.Context) {
 	fileID := c.Param("id")
 	if fileID == "" {
@@ -543,12 +695,6 @@ func (h *FilesHandler) GetFileAnalyticsSummary(c *gin.Context) {
 }
 
 // Helper functions
-func generateFileShortCode() string {
-	bytes := make([]byte, 6)
-	rand.Read(bytes)
-	return "f-" + hex.EncodeToString(bytes)[:8] // "f-" prefix for files
-}
-
 func generateUniqueFilename(originalFilename string) string {
 	bytes := make([]byte, 8)
 	rand.Read(bytes)
@@ -568,4 +714,70 @@ func getClientIP(c *gin.Context) string {
 	}
 	
 	return clientIP
-}
\ No newline at end of file
+}
+
+// countryHeaders are set by CDNs and load balancers that geolocate the client
+var countryHeaders = []string{
+	"CF-IPCountry",
+	"CloudFront-Viewer-Country",
+	"X-AppEngine-Country",
+	"X-Country-Code",
+}
+
+// parseTrustedProxies parses the configured proxy IPs and CIDR ranges,
+// skipping any that are invalid
+func parseTrustedProxies(entries []string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Printf("Ignoring invalid trusted proxy %q: %v", entry, err)
+			continue
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// fromTrustedProxy reports whether the request's connection comes from one
+// of the trusted proxies
+func fromTrustedProxy(c *gin.Context, trusted []*net.IPNet) bool {
+	host, _, err := net.SplitHostPort(strings.TrimSpace(c.Request.RemoteAddr))
+	if err != nil {
+		host = c.Request.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// getClientCountry returns the visitor's ISO 3166 country code as resolved by
+// an upstream proxy, or "" when no proxy provided one. The headers are only
+// read from trusted proxies, since any client can send them.
+func getClientCountry(c *gin.Context, trusted []*net.IPNet) string {
+	if !fromTrustedProxy(c, trusted) {
+		return ""
+	}
+	for _, header := range countryHeaders {
+		country := strings.ToUpper(strings.TrimSpace(c.GetHeader(header)))
+		// Cloudflare uses XX for unknown and T1 for Tor exit nodes
+		if len(country) == 2 && country != "XX" && country != "T1" {
+			return country
+		}
+	}
+	return ""
+}
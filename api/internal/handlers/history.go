@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"linker/internal/middleware"
+	"linker/internal/models"
+)
+
+// GetLinkHistory lists every recorded version of a link, newest first
+func (h *LinksHandler) GetLinkHistory(c *gin.Context) {
+	linkID := c.Param("id")
+	if linkID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid link ID"})
+		return
+	}
+
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	link, err := h.db.GetLinkByID(linkID, userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Link not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve link"})
+		}
+		return
+	}
+
+	versions, err := h.db.GetLinkHistory(linkID, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve link history"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"link_id":         linkID,
+		"current_version": link.Version,
+		"versions":        versions,
+	})
+}
+
+// RollbackLink restores the settings of an earlier version. The rollback is
+// itself recorded as a new version, so it can be undone the same way.
+func (h *LinksHandler) RollbackLink(c *gin.Context) {
+	linkID := c.Param("id")
+	if linkID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid link ID"})
+		return
+	}
+
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req models.RollbackLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	link, err := h.db.GetLinkByID(linkID, userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Link not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve link"})
+		}
+		return
+	}
+
+	version, err := h.db.GetLinkVersion(linkID, userID, req.Version)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve version"})
+		}
+		return
+	}
+	if version.Version == link.Version {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Version %d is already live", version.Version)})
+		return
+	}
+
+	// The policy may have changed since the version was live
+	if !h.checkDestinations(c, linkID, version.OriginalURL, version.FallbackURL, version.RedirectRules, version.Variants) {
+		return
+	}
+
+	recorded, err := h.db.RollbackLink(linkID, userID, version, middleware.GetAPITokenID(c))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Link not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore version"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("Link rolled back to version %d", version.Version),
+		"version": recorded,
+	})
+}
+
+// recordLinkVersion snapshots the link's saved settings into its history,
+// crediting the change to the authenticated user and API token. It writes
+// the error response itself and reports whether to continue. The returned
+// version is nil when an update changed nothing.
+func (h *LinksHandler) recordLinkVersion(c *gin.Context, linkID, action string, restoredVersion *int) (*models.LinkVersion, bool) {
+	userID, _ := middleware.GetUserID(c)
+
+	link, err := h.db.GetLinkByID(linkID, userID)
+	if err == nil {
+		var version *models.LinkVersion
+		version, err = h.db.RecordLinkVersion(link, action, restoredVersion, userID, middleware.GetAPITokenID(c))
+		if err == nil {
+			return version, true
+		}
+	}
+
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record link history"})
+	return nil, false
+}
@@ -1,24 +1,38 @@
 package handlers
 
 import (
-	"crypto/rand"
 	"database/sql"
-	"encoding/hex"
+	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"linker/internal/auth"
+	"linker/internal/config"
 	"linker/internal/database"
 	"linker/internal/middleware"
 	"linker/internal/models"
+	"linker/internal/shortcode"
+	"linker/internal/urlpolicy"
 )
 
 type LinksHandler struct {
-	db *database.Database
+	db        *database.Database
+	generator *shortcode.Generator
+	blocklist *shortcode.Blocklist
+	urlPolicy *urlpolicy.Policy
+	config    *config.Config
 }
 
-func NewLinksHandler(db *database.Database) *LinksHandler {
-	return &LinksHandler{db: db}
+func NewLinksHandler(db *database.Database, generator *shortcode.Generator, blocklist *shortcode.Blocklist, urlPolicy *urlpolicy.Policy, config *config.Config) *LinksHandler {
+	return &LinksHandler{
+		db:        db,
+		generator: generator,
+		blocklist: blocklist,
+		urlPolicy: urlPolicy,
+		config:    config,
+	}
 }
 
 func (h *LinksHandler) CreateLink(c *gin.Context) {
@@ -34,27 +48,85 @@ func (h *LinksHandler) CreateLink(c *gin.Context) {
 		return
 	}
 
+	if !validActivationWindow(req.StartsAt, req.ExpiresAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "starts_at must be before expires_at"})
+		return
+	}
+
+	if err := validateDomainID(h.db, req.DomainID); err != nil {
+		if err == sql.ErrNoRows || err == errDomainDisabled {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Domain does not exist or is disabled"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate domain"})
+		}
+		return
+	}
+
+	if !h.checkDestinations(c, "", req.OriginalURL, req.FallbackURL, req.RedirectRules, req.Variants) {
+		return
+	}
+
 	// Generate short codes if none provided
 	if len(req.ShortCodes) == 0 {
-		req.ShortCodes = []string{generateShortCode()}
+		shortCode, err := h.generator.LinkCode(shortCodeTaken(h.db, h.blocklist, req.DomainID))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate short code"})
+			return
+		}
+		req.ShortCodes = []string{shortCode}
 	}
 
-	// Check if any short codes already exist
+	// Check if any short codes are blocked or already exist in the domain's namespace
 	for _, shortCode := range req.ShortCodes {
-		if _, err := h.db.GetLinkByShortCode(shortCode); err != sql.ErrNoRows {
+		if !checkShortCodeAllowed(c, h.blocklist, h.config, shortCode) {
+			return
+		}
+
+		taken, err := h.db.ShortCodeExists(shortCode, req.DomainID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check short code availability"})
+			return
+		}
+		if taken {
 			c.JSON(http.StatusConflict, gin.H{"error": "Short code '" + shortCode + "' already exists"})
 			return
 		}
 	}
 
+	// Hash password if provided
+	var hashedPassword *string
+	if req.Password != nil {
+		hashed, err := auth.HashPassword(*req.Password)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+			return
+		}
+		hashedPassword = &hashed
+	}
+
 	link := &models.Link{
-		UserID:      userID,
-		DomainID:    req.DomainID,
-		OriginalURL: req.OriginalURL,
-		Title:       req.Title,
-		Description: req.Description,
-		Analytics:   req.Analytics,
-		ExpiresAt:   req.ExpiresAt,
+		UserID:        userID,
+		DomainID:      req.DomainID,
+		OriginalURL:   req.OriginalURL,
+		Title:         req.Title,
+		Description:   req.Description,
+		Analytics:     req.Analytics,
+		ForwardQuery:  req.ForwardQuery,
+		ForwardPath:   req.ForwardPath,
+		RedirectType:  req.RedirectType,
+		UTMSource:     req.UTMSource,
+		UTMMedium:     req.UTMMedium,
+		UTMCampaign:   req.UTMCampaign,
+		UTMTerm:       req.UTMTerm,
+		UTMContent:    req.UTMContent,
+		OGTitle:       req.OGTitle,
+		OGDescription: req.OGDescription,
+		OGImage:       req.OGImage,
+		FallbackURL:   req.FallbackURL,
+		Password:      hashedPassword,
+		MaxClicks:     req.MaxClicks,
+		StartsAt:      req.StartsAt,
+		ExpiresAt:     req.ExpiresAt,
 	}
 
 	if err := h.db.CreateLink(link); err != nil {
@@ -71,11 +143,44 @@ func (h *LinksHandler) CreateLink(c *gin.Context) {
 		}
 	}
 
+	if len(req.RedirectRules) > 0 {
+		rules, err := h.db.SetLinkRedirectRules(link.ID, req.RedirectRules)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save redirect rules"})
+			return
+		}
+		link.RedirectRules = rules
+	}
+
+	if len(req.Variants) > 0 {
+		variants, err := h.db.SetLinkVariants(link.ID, req.Variants)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save variants"})
+			return
+		}
+		link.Variants = variants
+	}
+
+	if len(req.Tags) > 0 {
+		if err := h.db.SetLinkTags(link.ID, req.Tags); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save tags"})
+			return
+		}
+		link.Tags, _ = h.db.GetLinkTags(link.ID)
+	}
+
+	version, ok := h.recordLinkVersion(c, link.ID, models.LinkVersionCreate, nil)
+	if !ok {
+		return
+	}
+	link.Version = version.Version
+
 	// Load short codes back into link for response
 	shortCodes, err := h.db.GetShortCodesByLinkID(link.ID)
 	if err == nil {
 		link.ShortCodes = shortCodes
 	}
+	link.ExpandedURL = expandDestination(link.OriginalURL, link, nil)
 
 	c.JSON(http.StatusCreated, link)
 }
@@ -87,6 +192,40 @@ func (h *LinksHandler) GetUserLinks(c *gin.Context) {
 		return
 	}
 
+	opts, err := parseListOptions(c, models.SortClicks)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	links, err := h.db.GetUserLinks(userID, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve links"})
+		return
+	}
+
+	for i := range links {
+		links[i].ExpandedURL = expandDestination(links[i].OriginalURL, &links[i], nil)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"links": links})
+}
+
+// GetBrokenLinks lists links whose destination failed its last health
+// check, optionally narrowed with ?status=4xx|5xx|dns|error
+func (h *LinksHandler) GetBrokenLinks(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	filter := c.Query("status")
+	if !database.IsValidBrokenFilter(filter) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be one of 4xx, 5xx, dns or error"})
+		return
+	}
+
 	limit := 20
 	offset := 0
 
@@ -102,9 +241,9 @@ func (h *LinksHandler) GetUserLinks(c *gin.Context) {
 		}
 	}
 
-	links, err := h.db.GetUserLinks(userID, limit, offset)
+	links, err := h.db.GetBrokenLinks(userID, filter, limit, offset)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve links"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve broken links"})
 		return
 	}
 
@@ -133,10 +272,42 @@ func (h *LinksHandler) GetLink(c *gin.Context) {
 		}
 		return
 	}
+	link.ExpandedURL = expandDestination(link.OriginalURL, link, nil)
 
 	c.JSON(http.StatusOK, link)
 }
 
+// PreviewLink returns the destination a visitor would be sent to. Query
+// parameters on this request stand in for the visitor's query string.
+func (h *LinksHandler) PreviewLink(c *gin.Context) {
+	linkID := c.Param("id")
+	if linkID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid link ID"})
+		return
+	}
+
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	link, err := h.db.GetLinkByID(linkID, userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Link not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve link"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"link_id":     link.ID,
+		"destination": expandDestination(link.OriginalURL, link, c.Request.URL.Query()),
+	})
+}
+
 func (h *LinksHandler) UpdateLink(c *gin.Context) {
 	linkID := c.Param("id")
 	if linkID == "" {
@@ -156,7 +327,52 @@ func (h *LinksHandler) UpdateLink(c *gin.Context) {
 		return
 	}
 
-	if err := h.db.UpdateLink(linkID, userID, &req); err != nil {
+	originalURL, fallbackURL := "", ""
+	if req.OriginalURL != nil {
+		originalURL = *req.OriginalURL
+	}
+	if req.FallbackURL != nil {
+		fallbackURL = *req.FallbackURL
+	}
+	if !h.checkDestinations(c, linkID, originalURL, fallbackURL, req.RedirectRules, req.Variants) {
+		return
+	}
+
+	if field := req.SetAndCleared(); field != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": field + " can't be both set and cleared"})
+		return
+	}
+
+	// Setting one end of the window must keep it before the stored other end
+	if req.StartsAt != nil || req.ExpiresAt != nil {
+		link, err := h.db.GetLinkByID(linkID, userID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Link not found"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve link"})
+			}
+			return
+		}
+		startsAt := updatedTime(req.StartsAt, req.Clears(models.ClearStartsAt), link.StartsAt)
+		expiresAt := updatedTime(req.ExpiresAt, req.Clears(models.ClearExpiresAt), link.ExpiresAt)
+		if !validActivationWindow(startsAt, expiresAt) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "starts_at must be before expires_at"})
+			return
+		}
+	}
+
+	// Hash password if provided
+	if req.Password != nil {
+		hashed, err := auth.HashPassword(*req.Password)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+			return
+		}
+		req.Password = &hashed
+	}
+
+	if _, err := h.db.UpdateLinkWithHistory(linkID, userID, &req, middleware.GetAPITokenID(c)); err != nil {
 		if err == sql.ErrNoRows {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Link not found"})
 		} else {
@@ -181,7 +397,8 @@ func (h *LinksHandler) DeleteLink(c *gin.Context) {
 		return
 	}
 
-	if err := h.db.DeleteLink(linkID, userID); err != nil {
+	// Deleted links go to the trash and keep their short codes until purged
+	if err := h.db.TrashLink(linkID, userID); err != nil {
 		if err == sql.ErrNoRows {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Link not found"})
 		} else {
@@ -190,11 +407,92 @@ func (h *LinksHandler) DeleteLink(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Link deleted successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Link moved to trash"})
 }
 
-func generateShortCode() string {
-	bytes := make([]byte, 4)
-	rand.Read(bytes)
-	return hex.EncodeToString(bytes)
-}
\ No newline at end of file
+// checkDestinations applies the URL policy to every destination a link
+// request sets, writing the rejection when one fails. An empty originalURL
+// or fallbackURL is skipped so partial updates only check what they change.
+func (h *LinksHandler) checkDestinations(c *gin.Context, linkID, originalURL, fallbackURL string, rules []models.RedirectRuleRequest, variants []models.LinkVariantRequest) bool {
+	type destination struct {
+		field string
+		url   string
+	}
+
+	var destinations []destination
+	if originalURL != "" {
+		destinations = append(destinations, destination{"original_url", originalURL})
+	}
+	if fallbackURL != "" {
+		destinations = append(destinations, destination{"fallback_url", fallbackURL})
+	}
+	for i, rule := range rules {
+		destinations = append(destinations, destination{fmt.Sprintf("redirect_rules[%d].destination_url", i), rule.DestinationURL})
+	}
+	for i, variant := range variants {
+		destinations = append(destinations, destination{fmt.Sprintf("variants[%d].destination_url", i), variant.DestinationURL})
+	}
+
+	for _, d := range destinations {
+		err := h.urlPolicy.Check(d.url, linkID, h.resolveShortLink)
+		if err == nil {
+			continue
+		}
+
+		if violation, ok := err.(*urlpolicy.Violation); ok {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":  violation.Message,
+				"reason": violation.Reason,
+				"field":  d.field,
+			})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check destination URL"})
+		}
+		return false
+	}
+
+	return true
+}
+
+// resolveShortLink finds the link behind one of our own short URLs so the
+// URL policy can follow redirect chains. Only the original URL is followed,
+// not rule or variant destinations.
+func (h *LinksHandler) resolveShortLink(host, shortCode string) (string, string, error) {
+	domain, err := resolveRequestDomain(h.db, host)
+	if err == errDomainDisabled {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	link, err := h.db.GetLinkByShortCode(shortCode, domainIDOf(domain))
+	if err == sql.ErrNoRows {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return link.ID, link.OriginalURL, nil
+}
+
+// validActivationWindow reports whether an availability window opens before
+// it closes. Open-ended windows are always valid.
+func validActivationWindow(startsAt, expiresAt *time.Time) bool {
+	if startsAt == nil || expiresAt == nil {
+		return true
+	}
+	return startsAt.Before(*expiresAt)
+}
+
+// updatedTime is the value a partial update leaves in a time setting: the
+// new value when set, none when cleared, and the stored value otherwise
+func updatedTime(set *time.Time, cleared bool, stored *time.Time) *time.Time {
+	if set != nil {
+		return set
+	}
+	if cleared {
+		return nil
+	}
+	return stored
+}
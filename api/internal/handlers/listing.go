@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"linker/internal/models"
+)
+
+// maxListTags caps how many tags a listing may filter on
+const maxListTags = 20
+
+var expiryFilters = map[string]bool{
+	models.ExpiryActive:    true,
+	models.ExpiryExpired:   true,
+	models.ExpiryScheduled: true,
+	models.ExpiryNever:     true,
+}
+
+// parseListOptions reads the listing query parameters shared by links and
+// files: tag (repeatable or comma separated), domain_id, expiry,
+// created_after, created_before, q, sort, order, limit and offset. countSort
+// is the resource's own usage sort, clicks or downloads.
+func parseListOptions(c *gin.Context, countSort string) (*models.ListOptions, error) {
+	opts := &models.ListOptions{
+		Sort:       models.SortCreated,
+		Descending: true,
+		Limit:      20,
+	}
+
+	if l := c.Query("limit"); l != "" {
+		if parsedLimit, err := strconv.Atoi(l); err == nil && parsedLimit > 0 {
+			opts.Limit = parsedLimit
+		}
+	}
+
+	if o := c.Query("offset"); o != "" {
+		if parsedOffset, err := strconv.Atoi(o); err == nil && parsedOffset >= 0 {
+			opts.Offset = parsedOffset
+		}
+	}
+
+	for _, value := range c.QueryArray("tag") {
+		for _, tag := range strings.Split(value, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				opts.Tags = append(opts.Tags, tag)
+			}
+		}
+	}
+	if len(opts.Tags) > maxListTags {
+		return nil, fmt.Errorf("at most %d tags may be given", maxListTags)
+	}
+
+	if domainID := c.Query("domain_id"); domainID != "" {
+		opts.DomainID = &domainID
+	}
+
+	if expiry := c.Query("expiry"); expiry != "" {
+		if !expiryFilters[expiry] {
+			return nil, fmt.Errorf("expiry must be one of active, expired, scheduled or never")
+		}
+		opts.Expiry = expiry
+	}
+
+	var err error
+	if opts.CreatedAfter, err = parseListDate(c.Query("created_after"), false); err != nil {
+		return nil, fmt.Errorf("created_after must be an RFC3339 timestamp or a YYYY-MM-DD date")
+	}
+	if opts.CreatedBefore, err = parseListDate(c.Query("created_before"), true); err != nil {
+		return nil, fmt.Errorf("created_before must be an RFC3339 timestamp or a YYYY-MM-DD date")
+	}
+
+	opts.Search = strings.TrimSpace(c.Query("q"))
+
+	if sort := c.Query("sort"); sort != "" {
+		if sort != models.SortCreated && sort != models.SortUpdated && sort != countSort {
+			return nil, fmt.Errorf("sort must be one of created, updated or %s", countSort)
+		}
+		opts.Sort = sort
+	}
+
+	switch c.Query("order") {
+	case "", "desc":
+	case "asc":
+		opts.Descending = false
+	default:
+		return nil, fmt.Errorf("order must be asc or desc")
+	}
+
+	return opts, nil
+}
+
+// parseListDate accepts an RFC3339 timestamp or a plain date. A plain date
+// used as an upper bound includes the whole day.
+func parseListDate(value string, endOfDay bool) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return &parsed, nil
+	}
+
+	parsed, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, err
+	}
+	if endOfDay {
+		parsed = parsed.AddDate(0, 0, 1)
+	}
+	return &parsed, nil
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"linker/internal/database"
+	"linker/internal/pages"
+)
+
+// PagesHandler serves the pages of a short domain that aren't tied to a
+// link or file
+type PagesHandler struct {
+	db    *database.Database
+	pages *pages.Renderer
+}
+
+func NewPagesHandler(db *database.Database, renderer *pages.Renderer) *PagesHandler {
+	return &PagesHandler{
+		db:    db,
+		pages: renderer,
+	}
+}
+
+// Landing serves the domain's landing page to browsers visiting the root
+// of a short domain. Other clients get a JSON 404, as do disabled domains.
+func (h *PagesHandler) Landing(c *gin.Context) {
+	domain, err := resolveRequestDomain(h.db, c.Request.Host)
+	if err != nil && err != errDomainDisabled {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err == errDomainDisabled || !wantsHTML(c) {
+		respondUnavailable(c, h.pages, domain, http.StatusNotFound, pages.NotFound, "Not found", nil)
+		return
+	}
+
+	renderPage(c, h.pages, domain, http.StatusOK, pages.Landing, &pages.Data{})
+}
+
+// NotFound shows browsers the domain's not-found page for paths no route
+// matches. API paths and other clients keep the default response.
+func (h *PagesHandler) NotFound(c *gin.Context) {
+	if strings.HasPrefix(c.Request.URL.Path, "/api/") || !wantsHTML(c) {
+		return
+	}
+
+	domain, err := resolveRequestDomain(h.db, c.Request.Host)
+	if err != nil && err != errDomainDisabled {
+		return
+	}
+
+	respondUnavailable(c, h.pages, domain, http.StatusNotFound, pages.NotFound, "The page you were looking for doesn't exist.", nil)
+}
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"linker/internal/database"
+	"linker/internal/middleware"
+	"linker/internal/models"
+	"linker/internal/qr"
+)
+
+const (
+	// qrSuffix on a public short URL returns its QR code instead of redirecting
+	qrSuffix = ".qr"
+
+	// QR codes point at the short URL with this query marker so scans can
+	// be told apart from other clicks and downloads
+	qrMarkerParam = "via"
+	qrMarkerValue = "qr"
+)
+
+func (h *LinksHandler) GetLinkQR(c *gin.Context) {
+	linkID := c.Param("id")
+	if linkID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid link ID"})
+		return
+	}
+
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	link, err := h.db.GetLinkByID(linkID, userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Link not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve link"})
+		}
+		return
+	}
+
+	shortCode, ok := selectShortCode(c, link.ShortCodes)
+	if !ok {
+		return
+	}
+
+	shortURL, err := buildShortURL(c, h.db, link.DomainID, h.config.LinkPrefix, shortCode)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build short URL"})
+		return
+	}
+
+	writeQRCode(c, shortURL+"?"+qrMarkerParam+"="+qrMarkerValue, false)
+}
+
+func (h *FilesHandler) GetFileQR(c *gin.Context) {
+	fileID := c.Param("id")
+	if fileID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file ID"})
+		return
+	}
+
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	file, err := h.db.GetFileByID(fileID, userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve file"})
+		}
+		return
+	}
+
+	shortCode, ok := selectShortCode(c, file.ShortCodes)
+	if !ok {
+		return
+	}
+
+	shortURL, err := buildShortURL(c, h.db, file.DomainID, h.config.FilePrefix, shortCode)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build short URL"})
+		return
+	}
+
+	writeQRCode(c, shortURL+"?"+qrMarkerParam+"="+qrMarkerValue, false)
+}
+
+// serveQRCode renders the QR code for a public "<prefix>/<code>.qr" request.
+// It only needs the short code to exist, so it skips the availability checks
+// made when following the link.
+func (h *RedirectHandler) serveQRCode(c *gin.Context, shortCode string) {
+	domain, err := resolveRequestDomain(h.db, c.Request.Host)
+	if err != nil {
+		if err == errDomainDisabled {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Link not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return
+	}
+
+	if _, err := h.db.GetLinkByShortCode(shortCode, domainIDOf(domain)); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Link not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return
+	}
+
+	shortURL := fmt.Sprintf("%s://%s/%s/%s", requestScheme(c), c.Request.Host, h.config.LinkPrefix, shortCode)
+	writeQRCode(c, shortURL+"?"+qrMarkerParam+"="+qrMarkerValue, true)
+}
+
+// serveQRCode is the file equivalent of RedirectHandler.serveQRCode
+func (h *FilesHandler) serveQRCode(c *gin.Context, shortCode string) {
+	domain, err := resolveRequestDomain(h.db, c.Request.Host)
+	if err != nil {
+		if err == errDomainDisabled {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return
+	}
+
+	if _, err := h.db.GetFileByShortCode(shortCode, domainIDOf(domain)); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return
+	}
+
+	shortURL := fmt.Sprintf("%s://%s/%s/%s", requestScheme(c), c.Request.Host, h.config.FilePrefix, shortCode)
+	writeQRCode(c, shortURL+"?"+qrMarkerParam+"="+qrMarkerValue, true)
+}
+
+// selectShortCode picks the short code named by the short_code query
+// parameter, or the primary short code when none is given.
+func selectShortCode(c *gin.Context, shortCodes []models.ShortCode) (string, bool) {
+	if len(shortCodes) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No short code found"})
+		return "", false
+	}
+
+	requested := c.Query("short_code")
+	if requested == "" {
+		for _, sc := range shortCodes {
+			if sc.IsPrimary {
+				return sc.ShortCode, true
+			}
+		}
+		return shortCodes[0].ShortCode, true
+	}
+
+	for _, sc := range shortCodes {
+		if sc.ShortCode == requested {
+			return sc.ShortCode, true
+		}
+	}
+
+	c.JSON(http.StatusNotFound, gin.H{"error": "Short code '" + requested + "' not found"})
+	return "", false
+}
+
+// buildShortURL returns the public URL of a short code, using the owning
+// domain when there is one and the request host otherwise.
+func buildShortURL(c *gin.Context, db *database.Database, domainID *string, prefix, shortCode string) (string, error) {
+	host := c.Request.Host
+	if domainID != nil {
+		domain, err := db.GetDomainByID(*domainID)
+		if err != nil {
+			return "", err
+		}
+		host = domain.Domain
+	}
+
+	return fmt.Sprintf("%s://%s/%s/%s", requestScheme(c), host, prefix, shortCode), nil
+}
+
+func requestScheme(c *gin.Context) string {
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+		return strings.ToLower(proto)
+	}
+	if c.Request.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// writeQRCode renders content as a QR code. Only the unauthenticated ".qr"
+// route is public; shared caches must not keep the owner's copies.
+func writeQRCode(c *gin.Context, content string, public bool) {
+	opts, err := qr.ParseOptions(c.Request.URL.Query())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	image, err := qr.Render(content, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate QR code"})
+		return
+	}
+
+	if public {
+		c.Header("Cache-Control", "public, max-age=86400")
+	} else {
+		c.Header("Cache-Control", "private, max-age=86400")
+	}
+	c.Data(http.StatusOK, opts.ContentType(), image)
+}
@@ -2,67 +2,491 @@ package handlers
 
 import (
 	"database/sql"
+	"html/template"
+	"log"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
+	"path"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"linker/internal/auth"
+	"linker/internal/config"
 	"linker/internal/database"
+	"linker/internal/events"
 	"linker/internal/models"
+	"linker/internal/pages"
+	"linker/internal/utils"
 )
 
+// unlockTTL is how long a visitor stays unlocked after entering a link or file password
+const unlockTTL = 30 * time.Minute
+
+// variantCookieTTL is how long a visitor keeps the same A/B variant
+const variantCookieTTL = 30 * 24 * time.Hour
+
+// permanentRedirectMaxAge bounds how long browsers may cache a 301 or 308,
+// so a changed destination is eventually picked up
+const permanentRedirectMaxAge = 24 * time.Hour
+
+// previewPageTemplate is served to link preview crawlers in place of the
+// redirect so they show the link's own OpenGraph metadata
+var previewPageTemplate = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<meta property="og:type" content="website">
+<meta property="og:url" content="{{.URL}}">
+<meta property="og:title" content="{{.Title}}">
+{{if .Description}}<meta property="og:description" content="{{.Description}}">
+<meta name="description" content="{{.Description}}">
+{{end}}{{if .Image}}<meta property="og:image" content="{{.Image}}">
+<meta name="twitter:card" content="summary_large_image">
+<meta name="twitter:image" content="{{.Image}}">
+{{else}}<meta name="twitter:card" content="summary">
+{{end}}<meta name="twitter:title" content="{{.Title}}">
+{{if .Description}}<meta name="twitter:description" content="{{.Description}}">
+{{end}}<meta http-equiv="refresh" content="0; url={{.Destination}}">
+</head>
+<body>
+<p><a href="{{.Destination}}">{{.Title}}</a></p>
+</body>
+</html>`))
+
 type RedirectHandler struct {
-	db        *database.Database
-	analytics bool
+	db     *database.Database
+	pages  *pages.Renderer
+	events *events.Recorder
+	config *config.Config
+
+	trustedProxies []*net.IPNet
 }
 
-func NewRedirectHandler(db *database.Database, analytics bool) *RedirectHandler {
+func NewRedirectHandler(db *database.Database, renderer *pages.Renderer, recorder *events.Recorder, config *config.Config) *RedirectHandler {
 	return &RedirectHandler{
-		db:        db,
-		analytics: analytics,
+		db:     db,
+		pages:  renderer,
+		events: recorder,
+		config: config,
+
+		trustedProxies: parseTrustedProxies(config.TrustedProxies),
 	}
 }
 
 func (h *RedirectHandler) Redirect(c *gin.Context) {
-	shortCode := c.Param("shortCode")
-	if shortCode == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Short code required"})
+	if shortCode := c.Param("shortCode"); strings.HasSuffix(shortCode, qrSuffix) && c.Param("path") == "" {
+		h.serveQRCode(c, strings.TrimSuffix(shortCode, qrSuffix))
 		return
 	}
 
-	link, err := h.db.GetLinkByShortCode(shortCode)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Link not found"})
+	link, domain, ok := h.lookupLink(c)
+	if !ok {
+		return
+	}
+
+	// Extra path segments are only served by links that opt in
+	extraPath := c.Param("path")
+	if extraPath == "/" {
+		extraPath = ""
+	}
+	if extraPath != "" && !link.ForwardPath {
+		respondLinkUnavailable(c, h.pages, nil, domain, http.StatusNotFound, pages.NotFound, "Link not found", nil)
+		return
+	}
+
+	if link.Password != nil && !h.isUnlocked(c, link) {
+		if wantsHTML(c) {
+			renderUnlockForm(c, h.pages, domain, http.StatusUnauthorized, "link", "")
 		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			respondUnavailable(c, h.pages, domain, http.StatusUnauthorized, pages.Password, "Password required", gin.H{"password_required": true})
 		}
 		return
 	}
 
-	if link.ExpiresAt != nil && time.Now().After(*link.ExpiresAt) {
-		c.JSON(http.StatusGone, gin.H{"error": "Link has expired"})
+	// Strip the QR scan marker so it isn't forwarded to the destination
+	query := c.Request.URL.Query()
+	source := ""
+	if query.Get(qrMarkerParam) == qrMarkerValue {
+		source = qrMarkerValue
+		query.Del(qrMarkerParam)
+	}
+
+	// Preview bots get the link's metadata instead of following the
+	// redirect; their fetches are not counted as clicks
+	if hasOpenGraph(link) && utils.IsSocialCrawler(c.GetHeader("User-Agent")) {
+		destination := joinDestinationPath(link.OriginalURL, extraPath)
+		h.renderPreviewPage(c, link, expandDestination(destination, link, query))
 		return
 	}
 
-	if err := h.db.IncrementLinkClicks(link.ID); err != nil {
-		// Log error but don't fail the redirect
+	// Clicks are counted in the background, except on links with a click
+	// limit, which must be counted before the visitor is let through
+	if link.MaxClicks != nil {
+		counted, err := h.db.IncrementLinkClicks(link.ID)
+		if err != nil {
+			// Letting the visitor through uncounted could exceed the limit
+			log.Printf("Failed to count click on link %s: %v", link.ID, err)
+			c.Header("Retry-After", "5")
+			respondUnavailable(c, h.pages, domain, http.StatusServiceUnavailable, pages.Unavailable, "Link is temporarily unavailable, please try again", nil)
+			return
+		}
+		if !counted {
+			// Another visitor used the last allowed click
+			respondLinkUnavailable(c, h.pages, link, domain, http.StatusGone, pages.Expired, "Link has reached its click limit", nil)
+			return
+		}
+	}
+
+	// Targeted rules take precedence over A/B variants, which take
+	// precedence over the link's own URL
+	country := getClientCountry(c, h.trustedProxies)
+	destination := link.OriginalURL
+	var ruleID, variantID *string
+	if rule := selectRedirectRule(link.RedirectRules, c.GetHeader("User-Agent"), c.GetHeader("Accept-Language"), country); rule != nil {
+		destination = rule.DestinationURL
+		ruleID = &rule.ID
+	} else if variant := h.assignVariant(c, link); variant != nil {
+		destination = variant.DestinationURL
+		variantID = &variant.ID
 	}
+	destination = joinDestinationPath(destination, extraPath)
+	destination = expandDestination(destination, link, query)
 
-	if h.analytics && link.Analytics {
-		click := &models.Click{
+	var click *models.Click
+	if h.config.Analytics && link.Analytics {
+		click = &models.Click{
 			LinkID:    link.ID,
 			IPAddress: h.getClientIP(c),
 			UserAgent: c.GetHeader("User-Agent"),
 			Referer:   c.GetHeader("Referer"),
+			Country:   country,
+			RuleID:    ruleID,
+			VariantID: variantID,
+			Source:    source,
+		}
+		if link.Version > 0 {
+			click.LinkVersion = &link.Version
+		}
+	}
+	h.events.RecordClick(link.ID, link.MaxClicks == nil, click)
+
+	// The destination depends on these headers once rules are in play
+	if len(link.RedirectRules) > 0 {
+		c.Header("Vary", "User-Agent, Accept-Language")
+	}
+
+	c.Header("Cache-Control", redirectCacheControl(link))
+	c.Redirect(redirectStatus(link), destination)
+}
+
+// redirectStatus returns the link's configured redirect status, falling
+// back to 302 for anything unexpected
+func redirectStatus(link *models.Link) int {
+	switch link.RedirectType {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return link.RedirectType
+	default:
+		return http.StatusFound
+	}
+}
+
+// redirectCacheControl only lets browsers cache permanent redirects of
+// links that send every visitor to the same place. Click limits, activation
+// windows, passwords, rules and variants all need to see every visit.
+func redirectCacheControl(link *models.Link) string {
+	if link.MaxClicks != nil || link.StartsAt != nil || link.ExpiresAt != nil ||
+		link.Password != nil || len(link.RedirectRules) > 0 || len(link.Variants) > 0 {
+		return "no-store"
+	}
+
+	switch redirectStatus(link) {
+	case http.StatusMovedPermanently, http.StatusPermanentRedirect:
+		return "public, max-age=" + strconv.Itoa(int(permanentRedirectMaxAge.Seconds()))
+	default:
+		return "private, no-cache"
+	}
+}
+
+// selectRedirectRule returns the first rule, in position order, whose
+// criteria all match the visitor. Languages match on the visitor's most
+// preferred language, so a rule for "en" matches "en-GB".
+func selectRedirectRule(rules []models.RedirectRule, userAgent, acceptLanguage, country string) *models.RedirectRule {
+	if len(rules) == 0 {
+		return nil
+	}
+
+	platform := utils.ParsePlatform(userAgent)
+	language := ""
+	if langs := utils.ParseAcceptLanguage(acceptLanguage); len(langs) > 0 {
+		language = langs[0]
+	}
+
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Platform != "" && rule.Platform != platform {
+			continue
+		}
+		if rule.Language != "" && language != rule.Language && !strings.HasPrefix(language, rule.Language+"-") {
+			continue
+		}
+		if rule.Country != "" && !strings.EqualFold(rule.Country, country) {
+			continue
+		}
+		return rule
+	}
+
+	return nil
+}
+
+// pathPlaceholder marks where forwarded path segments go in a destination URL
+const pathPlaceholder = "{path}"
+
+// joinDestinationPath adds the path captured after a short code to a
+// destination URL. The path is cleaned so ".." can't climb above the
+// destination, and escaped so it can't change the host or add parameters. It
+// replaces a {path} placeholder when the destination has one and is appended
+// to the destination's path otherwise.
+func joinDestinationPath(destination, extraPath string) string {
+	cleaned := ""
+	if extraPath != "" {
+		cleaned = strings.TrimPrefix(path.Clean("/"+extraPath), "/")
+	}
+
+	if i := strings.Index(destination, pathPlaceholder); i >= 0 {
+		if cleaned == "" {
+			// Avoid leaving an empty segment behind, e.g. "/{path}/issues"
+			destination = strings.ReplaceAll(destination, pathPlaceholder+"/", "")
+			return strings.ReplaceAll(destination, pathPlaceholder, "")
+		}
+
+		var escaped string
+		if q := strings.IndexAny(destination, "?#"); q >= 0 && q < i {
+			escaped = url.QueryEscape(cleaned)
+		} else {
+			segments := strings.Split(cleaned, "/")
+			for j, segment := range segments {
+				segments[j] = url.PathEscape(segment)
+			}
+			escaped = strings.Join(segments, "/")
+		}
+		return strings.ReplaceAll(destination, pathPlaceholder, escaped)
+	}
+
+	if cleaned == "" {
+		return destination
+	}
+
+	u, err := url.Parse(destination)
+	if err != nil {
+		return destination
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + cleaned
+	u.RawPath = ""
+	return u.String()
+}
+
+// expandDestination adds the link's UTM parameters and, when the link forwards
+// query strings, the visitor's query parameters to a destination URL. When the
+// same parameter is set more than once the visitor's value wins, then the
+// link's UTM field, then the value already in the destination.
+func expandDestination(destination string, link *models.Link, incoming url.Values) string {
+	u, err := url.Parse(destination)
+	if err != nil {
+		return destination
+	}
+
+	params := u.Query()
+	changed := false
+
+	utm := []struct{ key, value string }{
+		{"utm_source", link.UTMSource},
+		{"utm_medium", link.UTMMedium},
+		{"utm_campaign", link.UTMCampaign},
+		{"utm_term", link.UTMTerm},
+		{"utm_content", link.UTMContent},
+	}
+	for _, p := range utm {
+		if p.value != "" {
+			params.Set(p.key, p.value)
+			changed = true
+		}
+	}
+
+	if link.ForwardQuery {
+		for key, values := range incoming {
+			params[key] = values
+			changed = true
+		}
+	}
+
+	// Leave untouched URLs byte-for-byte as the user entered them
+	if !changed {
+		return destination
+	}
+
+	u.RawQuery = params.Encode()
+	return u.String()
+}
+
+// assignVariant returns the visitor's A/B variant for a link. Returning
+// visitors keep the variant stored in their cookie; new visitors are assigned
+// one at random in proportion to the variant weights.
+func (h *RedirectHandler) assignVariant(c *gin.Context, link *models.Link) *models.LinkVariant {
+	if len(link.Variants) == 0 {
+		return nil
+	}
+
+	cookieName := variantCookieName(link.ID)
+	if variantID, err := c.Cookie(cookieName); err == nil {
+		for i := range link.Variants {
+			if link.Variants[i].ID == variantID {
+				return &link.Variants[i]
+			}
+		}
+	}
+
+	variant := pickWeightedVariant(link.Variants)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(cookieName, variant.ID, int(variantCookieTTL.Seconds()), "/", "", c.Request.TLS != nil, true)
+	return variant
+}
+
+func pickWeightedVariant(variants []models.LinkVariant) *models.LinkVariant {
+	total := 0
+	for _, v := range variants {
+		total += v.Weight
+	}
+
+	n := rand.Intn(total)
+	for i := range variants {
+		n -= variants[i].Weight
+		if n < 0 {
+			return &variants[i]
+		}
+	}
+	return &variants[len(variants)-1]
+}
+
+func variantCookieName(linkID string) string {
+	return "linker_variant_" + linkID
+}
+
+// Unlock checks the password submitted from the unlock form. On success it
+// stores a signed cookie and sends the visitor back through Redirect, which
+// records the click.
+func (h *RedirectHandler) Unlock(c *gin.Context) {
+	link, domain, ok := h.lookupLink(c)
+	if !ok {
+		return
+	}
+
+	if link.Password != nil {
+		if !auth.CheckPassword(c.PostForm("password"), *link.Password) {
+			if wantsHTML(c) {
+				renderUnlockForm(c, h.pages, domain, http.StatusUnauthorized, "link", "Incorrect password")
+			} else {
+				respondUnavailable(c, h.pages, domain, http.StatusUnauthorized, pages.Password, "Incorrect password", nil)
+			}
+			return
+		}
+
+		token := auth.GenerateUnlockToken(link.ID, *link.Password, time.Now().Add(unlockTTL), h.config.JWTSecret)
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(unlockCookieName(link.ID), token, int(unlockTTL.Seconds()), "/", "", c.Request.TLS != nil, true)
+	}
+
+	c.Redirect(http.StatusSeeOther, c.Request.URL.RequestURI())
+}
+
+// lookupLink resolves the short code for the request host and rejects links
+// that are not active. It writes the error response, or the redirect to a
+// fallback URL, itself and reports whether to continue.
+func (h *RedirectHandler) lookupLink(c *gin.Context) (*models.Link, *models.Domain, bool) {
+	shortCode := c.Param("shortCode")
+	if shortCode == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Short code required"})
+		return nil, nil, false
+	}
+
+	domain, err := resolveRequestDomain(h.db, c.Request.Host)
+	if err != nil {
+		if err == errDomainDisabled {
+			respondLinkUnavailable(c, h.pages, nil, domain, http.StatusNotFound, pages.NotFound, "Link not found", nil)
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		}
-		
-		if err := h.db.CreateClick(click); err != nil {
-			// Log error but don't fail the redirect
+		return nil, nil, false
+	}
+
+	link, err := h.db.GetLinkByShortCode(shortCode, domainIDOf(domain))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondLinkUnavailable(c, h.pages, nil, domain, http.StatusNotFound, pages.NotFound, "Link not found", nil)
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		}
+		return nil, nil, false
+	}
+
+	if link.StartsAt != nil && time.Now().Before(*link.StartsAt) {
+		respondLinkUnavailable(c, h.pages, link, domain, http.StatusForbidden, pages.Unavailable, "Link is not yet available", gin.H{
+			"starts_at": link.StartsAt,
+		})
+		return nil, nil, false
 	}
 
-	c.Redirect(http.StatusFound, link.OriginalURL)
+	if link.ExpiresAt != nil && time.Now().After(*link.ExpiresAt) {
+		respondLinkUnavailable(c, h.pages, link, domain, http.StatusGone, pages.Expired, "Link has expired", nil)
+		return nil, nil, false
+	}
+
+	if link.MaxClicks != nil && link.Clicks >= *link.MaxClicks {
+		respondLinkUnavailable(c, h.pages, link, domain, http.StatusGone, pages.Expired, "Link has reached its click limit", nil)
+		return nil, nil, false
+	}
+
+	return link, domain, true
+}
+
+func hasOpenGraph(link *models.Link) bool {
+	return link.OGTitle != "" || link.OGDescription != "" || link.OGImage != ""
+}
+
+func (h *RedirectHandler) renderPreviewPage(c *gin.Context, link *models.Link, destination string) {
+	title := link.OGTitle
+	if title == "" {
+		title = link.Title
+	}
+	if title == "" {
+		title = destination
+	}
+
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusOK)
+	previewPageTemplate.Execute(c.Writer, gin.H{
+		"URL":         requestScheme(c) + "://" + c.Request.Host + c.Request.URL.RequestURI(),
+		"Title":       title,
+		"Description": link.OGDescription,
+		"Image":       link.OGImage,
+		"Destination": destination,
+	})
+}
+
+func (h *RedirectHandler) isUnlocked(c *gin.Context, link *models.Link) bool {
+	token, err := c.Cookie(unlockCookieName(link.ID))
+	if err != nil || link.Password == nil {
+		return false
+	}
+	return auth.ValidateUnlockToken(token, link.ID, *link.Password, h.config.JWTSecret)
+}
+
+func unlockCookieName(linkID string) string {
+	return "linker_unlock_" + linkID
 }
 
 func (h *RedirectHandler) getClientIP(c *gin.Context) string {
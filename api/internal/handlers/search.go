@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"linker/internal/database"
+	"linker/internal/middleware"
+	"linker/internal/models"
+)
+
+// maxSearchLimit caps a search page, since every result loads its full link
+// or file
+const maxSearchLimit = 100
+
+// maxSearchOffset caps how deep search pages go, since each page ranks
+// limit+offset matches from every index. Deeper pages are empty; narrowing
+// the query finds those results faster.
+const maxSearchOffset = 1000
+
+type SearchHandler struct {
+	db *database.Database
+}
+
+func NewSearchHandler(db *database.Database) *SearchHandler {
+	return &SearchHandler{db: db}
+}
+
+// Search ranks the user's links and files against ?q=, optionally limited
+// to one kind with ?type=link|file
+func (h *SearchHandler) Search(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+		return
+	}
+
+	var types []string
+	switch resultType := c.Query("type"); resultType {
+	case "":
+	case models.SearchTypeLink, models.SearchTypeFile:
+		types = []string{resultType}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be link or file"})
+		return
+	}
+
+	limit := 20
+	offset := 0
+
+	if l := c.Query("limit"); l != "" {
+		if parsedLimit, err := strconv.Atoi(l); err == nil && parsedLimit > 0 {
+			limit = parsedLimit
+		}
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+
+	if o := c.Query("offset"); o != "" {
+		if parsedOffset, err := strconv.Atoi(o); err == nil && parsedOffset >= 0 {
+			offset = parsedOffset
+		}
+	}
+	if offset >= maxSearchOffset {
+		c.JSON(http.StatusOK, gin.H{"query": query, "results": []models.SearchResult{}})
+		return
+	}
+	if offset+limit > maxSearchOffset {
+		limit = maxSearchOffset - offset
+	}
+
+	results, err := h.db.Search(userID, query, types, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search"})
+		return
+	}
+
+	for _, result := range results {
+		if result.Link != nil {
+			result.Link.ExpandedURL = expandDestination(result.Link.OriginalURL, result.Link, nil)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"query": query, "results": results})
+}
@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"linker/internal/config"
+	"linker/internal/database"
+	"linker/internal/middleware"
+	"linker/internal/models"
+	"linker/internal/shortcode"
+)
+
+// shortCodeOwner adapts a link or file to the shared alias handlers
+type shortCodeOwner struct {
+	kind     string // "Link" or "File", for error messages
+	domainID *string
+	list     func() ([]models.ShortCode, error)
+	create   func(shortCode string) error
+	remove   func(shortCode string) error
+	promote  func(shortCode string) error
+}
+
+func (h *LinksHandler) AddShortCode(c *gin.Context) {
+	if owner, ok := h.shortCodeOwner(c); ok {
+		addShortCode(c, h.db, h.blocklist, h.config, owner)
+	}
+}
+
+func (h *LinksHandler) DeleteShortCode(c *gin.Context) {
+	if owner, ok := h.shortCodeOwner(c); ok {
+		deleteShortCode(c, owner)
+	}
+}
+
+func (h *LinksHandler) SetPrimaryShortCode(c *gin.Context) {
+	if owner, ok := h.shortCodeOwner(c); ok {
+		setPrimaryShortCode(c, owner)
+	}
+}
+
+func (h *FilesHandler) AddShortCode(c *gin.Context) {
+	if owner, ok := h.shortCodeOwner(c); ok {
+		addShortCode(c, h.db, h.blocklist, h.config, owner)
+	}
+}
+
+func (h *FilesHandler) DeleteShortCode(c *gin.Context) {
+	if owner, ok := h.shortCodeOwner(c); ok {
+		deleteShortCode(c, owner)
+	}
+}
+
+func (h *FilesHandler) SetPrimaryShortCode(c *gin.Context) {
+	if owner, ok := h.shortCodeOwner(c); ok {
+		setPrimaryShortCode(c, owner)
+	}
+}
+
+func (h *LinksHandler) shortCodeOwner(c *gin.Context) (*shortCodeOwner, bool) {
+	linkID := c.Param("id")
+	if linkID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid link ID"})
+		return nil, false
+	}
+
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return nil, false
+	}
+
+	link, err := h.db.GetLinkByID(linkID, userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Link not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve link"})
+		}
+		return nil, false
+	}
+
+	return &shortCodeOwner{
+		kind:     "Link",
+		domainID: link.DomainID,
+		list:     func() ([]models.ShortCode, error) { return h.db.GetShortCodesByLinkID(link.ID) },
+		create:   func(code string) error { return h.db.CreateShortCode(link.ID, code, false) },
+		remove:   func(code string) error { return h.db.DeleteLinkShortCode(link.ID, code) },
+		promote:  func(code string) error { return h.db.SetPrimaryLinkShortCode(link.ID, code) },
+	}, true
+}
+
+func (h *FilesHandler) shortCodeOwner(c *gin.Context) (*shortCodeOwner, bool) {
+	fileID := c.Param("id")
+	if fileID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file ID"})
+		return nil, false
+	}
+
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return nil, false
+	}
+
+	file, err := h.db.GetFileByID(fileID, userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve file"})
+		}
+		return nil, false
+	}
+
+	return &shortCodeOwner{
+		kind:     "File",
+		domainID: file.DomainID,
+		list:     func() ([]models.ShortCode, error) { return h.db.GetShortCodesByFileID(file.ID) },
+		create:   func(code string) error { return h.db.CreateFileShortCode(file.ID, code, false) },
+		remove:   func(code string) error { return h.db.DeleteFileShortCode(file.ID, code) },
+		promote:  func(code string) error { return h.db.SetPrimaryFileShortCode(file.ID, code) },
+	}, true
+}
+
+func addShortCode(c *gin.Context, db *database.Database, blocklist *shortcode.Blocklist, cfg *config.Config, owner *shortCodeOwner) {
+	var req models.AddShortCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !middleware.IsValidShortCode(req.ShortCode) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid short code format. Short codes must be 3-32 characters long and contain only letters, numbers, hyphens, and underscores.",
+		})
+		return
+	}
+
+	if !checkShortCodeAllowed(c, blocklist, cfg, req.ShortCode) {
+		return
+	}
+
+	// Links and files share one namespace per domain
+	taken, err := db.ShortCodeExists(req.ShortCode, owner.domainID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check short code availability"})
+		return
+	}
+	if taken {
+		c.JSON(http.StatusConflict, gin.H{"error": "Short code '" + req.ShortCode + "' already exists"})
+		return
+	}
+
+	if err := owner.create(req.ShortCode); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create short code"})
+		return
+	}
+
+	if req.IsPrimary {
+		if err := owner.promote(req.ShortCode); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set primary short code"})
+			return
+		}
+	}
+
+	respondWithShortCodes(c, http.StatusCreated, owner)
+}
+
+func deleteShortCode(c *gin.Context, owner *shortCodeOwner) {
+	shortCode := c.Param("shortCode")
+
+	if err := owner.remove(shortCode); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Short code not found"})
+		case database.ErrLastShortCode:
+			c.JSON(http.StatusConflict, gin.H{"error": owner.kind + " must keep at least one short code"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete short code"})
+		}
+		return
+	}
+
+	respondWithShortCodes(c, http.StatusOK, owner)
+}
+
+func setPrimaryShortCode(c *gin.Context, owner *shortCodeOwner) {
+	shortCode := c.Param("shortCode")
+
+	if err := owner.promote(shortCode); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Short code not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set primary short code"})
+		}
+		return
+	}
+
+	respondWithShortCodes(c, http.StatusOK, owner)
+}
+
+// checkShortCodeAllowed rejects reserved and denylisted short codes unless
+// the user is an admin, writing the error response when it does
+func checkShortCodeAllowed(c *gin.Context, blocklist *shortcode.Blocklist, cfg *config.Config, shortCode string) bool {
+	err := blocklist.Check(shortCode)
+	if err == nil || isAdmin(c, cfg) {
+		return true
+	}
+
+	if err == shortcode.ErrReserved {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Short code '" + shortCode + "' is reserved"})
+	} else {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Short code '" + shortCode + "' is not allowed"})
+	}
+	return false
+}
+
+// shortCodeTaken treats blocked codes as taken so generated codes avoid them
+func shortCodeTaken(db *database.Database, blocklist *shortcode.Blocklist, domainID *string) shortcode.TakenFunc {
+	return func(code string) (bool, error) {
+		if blocklist.Check(code) != nil {
+			return true, nil
+		}
+		return db.ShortCodeExists(code, domainID)
+	}
+}
+
+func isAdmin(c *gin.Context, cfg *config.Config) bool {
+	username, exists := middleware.GetUsername(c)
+	if !exists {
+		return false
+	}
+	for _, admin := range cfg.AdminUsers {
+		if admin == username {
+			return true
+		}
+	}
+	return false
+}
+
+func respondWithShortCodes(c *gin.Context, status int, owner *shortCodeOwner) {
+	shortCodes, err := owner.list()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve short codes"})
+		return
+	}
+
+	c.JSON(status, gin.H{"short_codes": shortCodes})
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"linker/internal/database"
+	"linker/internal/middleware"
+	"linker/internal/trash"
+)
+
+type TrashHandler struct {
+	db     *database.Database
+	purger *trash.Purger
+}
+
+func NewTrashHandler(db *database.Database, purger *trash.Purger) *TrashHandler {
+	return &TrashHandler{db: db, purger: purger}
+}
+
+// GetTrash lists the user's deleted links and files with when each will be
+// purged
+func (h *TrashHandler) GetTrash(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	links, err := h.db.GetTrashedLinks(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve trash"})
+		return
+	}
+	files, err := h.db.GetTrashedFiles(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve trash"})
+		return
+	}
+
+	for i := range links {
+		purgeAt := h.purger.PurgeAt(*links[i].DeletedAt)
+		links[i].PurgeAt = &purgeAt
+	}
+	for i := range files {
+		purgeAt := h.purger.PurgeAt(*files[i].DeletedAt)
+		files[i].PurgeAt = &purgeAt
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"links":          links,
+		"files":          files,
+		"retention_days": int(h.purger.Retention() / (24 * time.Hour)),
+	})
+}
+
+func (h *TrashHandler) RestoreLink(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	if err := h.db.RestoreLink(c.Param("id"), userID, h.purger.Cutoff(time.Now())); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Link not found in trash"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore link"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Link restored successfully"})
+}
+
+func (h *TrashHandler) RestoreFile(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	if err := h.db.RestoreFile(c.Param("id"), userID, h.purger.Cutoff(time.Now())); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found in trash"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore file"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "File restored successfully"})
+}
+
+// PurgeLink permanently deletes a link in the trash, freeing its short codes
+func (h *TrashHandler) PurgeLink(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	if err := h.db.PurgeLink(c.Param("id"), userID); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Link not found in trash"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge link"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Link permanently deleted"})
+}
+
+// PurgeFile permanently deletes a file in the trash along with its stored
+// object
+func (h *TrashHandler) PurgeFile(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	file, err := h.db.GetTrashedFile(c.Param("id"), userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found in trash"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve file"})
+		}
+		return
+	}
+
+	if err := h.purger.PurgeFile(c.Request.Context(), file); err != nil {
+		if errors.Is(err, trash.ErrStorageUnavailable) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "File storage is not enabled, so the file can't be purged yet"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge file"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "File permanently deleted"})
+}
+
+// EmptyTrash permanently deletes everything in the user's trash
+func (h *TrashHandler) EmptyTrash(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	purged, err := h.purger.Empty(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to empty trash"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Trash emptied", "purged": purged})
+}
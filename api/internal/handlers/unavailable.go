@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"linker/internal/models"
+	"linker/internal/pages"
+)
+
+// wantsHTML reports whether the client prefers an HTML page to JSON, as
+// browsers do. Clients that send no Accept header, or accept anything, get
+// JSON.
+func wantsHTML(c *gin.Context) bool {
+	return c.NegotiateFormat(gin.MIMEJSON, gin.MIMEHTML) == gin.MIMEHTML
+}
+
+// respondUnavailable writes an error for a short link or file that can't be
+// served: the domain's page for browsers, otherwise the JSON error with any
+// extra fields
+func respondUnavailable(c *gin.Context, renderer *pages.Renderer, domain *models.Domain, status int, page, message string, extra gin.H) {
+	c.Header("Cache-Control", "no-store")
+
+	if !wantsHTML(c) {
+		body := gin.H{"error": message}
+		for key, value := range extra {
+			body[key] = value
+		}
+		c.JSON(status, body)
+		return
+	}
+
+	renderPage(c, renderer, domain, status, page, &pages.Data{Message: message})
+}
+
+// respondLinkUnavailable sends visitors of a link that can't be followed to
+// the link's fallback URL, then to the fallback of the domain they used, and
+// otherwise writes the error. link is nil when no link matched.
+func respondLinkUnavailable(c *gin.Context, renderer *pages.Renderer, link *models.Link, domain *models.Domain, status int, page, message string, extra gin.H) {
+	fallback := ""
+	if link != nil {
+		fallback = link.FallbackURL
+	}
+	if fallback == "" && domain != nil {
+		fallback = domain.FallbackURL
+	}
+
+	if fallback == "" {
+		respondUnavailable(c, renderer, domain, status, page, message, extra)
+		return
+	}
+
+	// The link may come back, so the fallback must not be cached
+	c.Header("Cache-Control", "no-store")
+	c.Redirect(http.StatusFound, fallback)
+}
+
+// renderUnlockForm asks for a link or file password. The form posts back to
+// the same URL, which sets an unlock cookie, so the password never ends up in
+// a URL, browser history or logs.
+func renderUnlockForm(c *gin.Context, renderer *pages.Renderer, domain *models.Domain, status int, resource, message string) {
+	// Drop a password a script passed in the query string
+	action := *c.Request.URL
+	query := action.Query()
+	query.Del("password")
+	action.RawQuery = query.Encode()
+
+	c.Header("Cache-Control", "no-store")
+	renderPage(c, renderer, domain, status, pages.Password, &pages.Data{
+		Resource: resource,
+		Action:   action.RequestURI(),
+		Method:   http.MethodPost,
+		Error:    message,
+	})
+}
+
+// renderPage writes one of the public pages, falling back to a plain status
+// message when its templates are broken so visitors still get an answer
+func renderPage(c *gin.Context, renderer *pages.Renderer, domain *models.Domain, status int, page string, data *pages.Data) {
+	if err := renderer.Render(c.Writer, status, page, domain, data); err != nil {
+		log.Printf("Failed to render %s page: %v", page, err)
+		c.String(status, http.StatusText(status))
+	}
+}
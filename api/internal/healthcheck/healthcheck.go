@@ -0,0 +1,330 @@
+package healthcheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"linker/internal/config"
+	"linker/internal/database"
+	"linker/internal/urlpolicy"
+)
+
+// Failure kinds stored in health_error when no response was received
+const (
+	ErrorDNS        = "dns_error"
+	ErrorTimeout    = "timeout"
+	ErrorTLS        = "tls_error"
+	ErrorConnection = "connection_error"
+	ErrorBlocked    = "blocked"
+	ErrorInvalidURL = "invalid_url"
+)
+
+const (
+	userAgent = "Linker-HealthCheck/1.0"
+	// Enough of a GET body to let the connection be reused
+	maxDrainBytes = 64 << 10
+)
+
+var errBlockedAddress = errors.New("destination resolves to a private address")
+
+// Result is the outcome of checking one destination. StatusCode is zero when
+// the request failed before a response arrived, and Error says why.
+type Result struct {
+	StatusCode int
+	Error      string
+	Latency    time.Duration
+}
+
+// Checker periodically requests each link's destination and records the
+// status code, latency and check time on the link
+type Checker struct {
+	db          *database.Database
+	client      *http.Client
+	interval    time.Duration
+	recheck     time.Duration
+	batchSize   int
+	concurrency int
+	hosts       *hostLimiter
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewChecker(db *database.Database, cfg *config.Config) *Checker {
+	hc := cfg.HealthCheck
+	defaults := config.DefaultHealthCheckConfig()
+	if hc.IntervalMinutes <= 0 {
+		hc.IntervalMinutes = defaults.IntervalMinutes
+	}
+	if hc.BatchSize <= 0 {
+		hc.BatchSize = defaults.BatchSize
+	}
+	if hc.Concurrency <= 0 {
+		hc.Concurrency = defaults.Concurrency
+	}
+	if hc.TimeoutSeconds <= 0 {
+		hc.TimeoutSeconds = defaults.TimeoutSeconds
+	}
+
+	dialer := &net.Dialer{Timeout: time.Duration(hc.TimeoutSeconds) * time.Second}
+	if !hc.AllowPrivateNetworks {
+		// Any user can point a link anywhere and read the result back, so
+		// internal services are refused whatever the URL policy allows.
+		// Checked at dial time so redirects and DNS changes can't reach
+		// them either.
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || urlpolicy.IsPrivateIP(ip) {
+				return errBlockedAddress
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	// Dial destinations directly so the address check sees them
+	transport.Proxy = nil
+
+	return &Checker{
+		db: db,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   time.Duration(hc.TimeoutSeconds) * time.Second,
+		},
+		interval:    time.Duration(hc.IntervalMinutes) * time.Minute,
+		recheck:     time.Duration(hc.RecheckHours) * time.Hour,
+		batchSize:   hc.BatchSize,
+		concurrency: hc.Concurrency,
+		hosts:       newHostLimiter(time.Duration(hc.PerHostDelayMs) * time.Millisecond),
+	}
+}
+
+// Start runs a pass straight away and then every interval until Stop
+func (c *Checker) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	c.done = make(chan struct{})
+
+	go func() {
+		defer close(c.done)
+
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+
+		for {
+			if checked, err := c.RunOnce(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Link health check failed: %v", err)
+			} else if checked > 0 {
+				log.Printf("Checked %d link destinations", checked)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop cancels in-flight checks and waits for the worker to exit
+func (c *Checker) Stop() {
+	if c.cancel == nil {
+		return
+	}
+	c.cancel()
+	<-c.done
+}
+
+// RunOnce checks every link that is due, up to the batch size, and returns
+// how many were checked
+func (c *Checker) RunOnce(ctx context.Context) (int, error) {
+	targets, err := c.db.GetLinksDueForHealthCheck(time.Now().Add(-c.recheck), c.batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		checked int
+		lastErr error
+	)
+	sem := make(chan struct{}, c.concurrency)
+
+	for _, target := range targets {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return checked, ctx.Err()
+		case sem <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func(target database.HealthCheckTarget) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			result := c.Check(ctx, target.OriginalURL)
+			if ctx.Err() != nil {
+				// Cancelled checks say nothing about the destination
+				return
+			}
+
+			err := c.db.UpdateLinkHealth(target.LinkID, target.OriginalURL,
+				nullableInt(result.StatusCode), nullableString(result.Error), result.Latency, time.Now())
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				lastErr = fmt.Errorf("failed to save health of link %s: %w", target.LinkID, err)
+				return
+			}
+			checked++
+		}(target)
+	}
+
+	wg.Wait()
+	return checked, lastErr
+}
+
+// Check requests a destination with HEAD, falling back to GET when HEAD
+// fails or returns an error status, since many servers mishandle HEAD
+func (c *Checker) Check(ctx context.Context, rawURL string) Result {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return Result{Error: ErrorInvalidURL}
+	}
+
+	if err := c.hosts.wait(ctx, strings.ToLower(u.Host)); err != nil {
+		return Result{Error: classifyError(err)}
+	}
+
+	start := time.Now()
+	status, err := c.do(ctx, http.MethodHead, rawURL)
+	if (err == nil && status >= 400) || (err != nil && !isDNSError(err)) {
+		if err := c.hosts.wait(ctx, strings.ToLower(u.Host)); err != nil {
+			return Result{Error: classifyError(err)}
+		}
+		start = time.Now()
+		status, err = c.do(ctx, http.MethodGet, rawURL)
+	}
+	latency := time.Since(start)
+
+	if err != nil {
+		return Result{Error: classifyError(err), Latency: latency}
+	}
+	return Result{StatusCode: status, Latency: latency}
+}
+
+func (c *Checker) do(ctx context.Context, method, rawURL string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxDrainBytes))
+
+	return resp.StatusCode, nil
+}
+
+func isDNSError(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr)
+}
+
+func classifyError(err error) string {
+	var netErr net.Error
+	switch {
+	case isDNSError(err):
+		return ErrorDNS
+	case errors.Is(err, errBlockedAddress):
+		return ErrorBlocked
+	case errors.As(err, &netErr) && netErr.Timeout(), errors.Is(err, context.DeadlineExceeded):
+		return ErrorTimeout
+	case strings.Contains(err.Error(), "tls:"), strings.Contains(err.Error(), "x509:"):
+		return ErrorTLS
+	default:
+		return ErrorConnection
+	}
+}
+
+func nullableInt(n int) *int {
+	if n == 0 {
+		return nil
+	}
+	return &n
+}
+
+func nullableString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// hostLimiter spaces out requests to the same host. Each caller reserves
+// the next free slot for its host and sleeps until it arrives.
+type hostLimiter struct {
+	delay time.Duration
+	mu    sync.Mutex
+	next  map[string]time.Time
+}
+
+func newHostLimiter(delay time.Duration) *hostLimiter {
+	return &hostLimiter{
+		delay: delay,
+		next:  make(map[string]time.Time),
+	}
+}
+
+func (l *hostLimiter) wait(ctx context.Context, host string) error {
+	if l.delay <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	slot := l.next[host]
+	if slot.Before(now) {
+		slot = now
+	}
+	l.next[host] = slot.Add(l.delay)
+
+	// Forget hosts whose slots have passed so the map doesn't grow forever
+	for h, t := range l.next {
+		if t.Before(now) {
+			delete(l.next, h)
+		}
+	}
+	l.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(slot))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
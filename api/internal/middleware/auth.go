@@ -68,7 +68,7 @@ func AuthMiddlewareWithAPITokens(jwtSecret string, db *database.Database) gin.Ha
 		}
 
 		// Then try API token
-		userID, err := validateAPIToken(db, tokenString)
+		userID, tokenID, err := validateAPIToken(db, tokenString)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			c.Abort()
@@ -86,6 +86,7 @@ func AuthMiddlewareWithAPITokens(jwtSecret string, db *database.Database) gin.Ha
 		c.Set("user_id", userID)
 		c.Set("username", user.Username)
 		c.Set("token_type", "api")
+		c.Set("api_token_id", tokenID)
 		c.Next()
 	}
 }
@@ -98,7 +99,26 @@ func GetUserID(c *gin.Context) (string, bool) {
 	return userID.(string), true
 }
 
-func validateAPIToken(db *database.Database, tokenString string) (string, error) {
+func GetUsername(c *gin.Context) (string, bool) {
+	username, exists := c.Get("username")
+	if !exists {
+		return "", false
+	}
+	return username.(string), true
+}
+
+// GetAPITokenID returns the ID of the API token that authenticated the
+// request, or nil when it was authenticated with a JWT
+func GetAPITokenID(c *gin.Context) *string {
+	tokenID, exists := c.Get("api_token_id")
+	if !exists {
+		return nil
+	}
+	id := tokenID.(string)
+	return &id
+}
+
+func validateAPIToken(db *database.Database, tokenString string) (string, string, error) {
 	// Hash the provided token
 	hasher := sha256.New()
 	hasher.Write([]byte(tokenString))
@@ -106,16 +126,16 @@ func validateAPIToken(db *database.Database, tokenString string) (string, error)
 
 	token, err := db.GetAPITokenByHash(tokenHash)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
 	// Check if token is expired
 	if token.ExpiresAt != nil && time.Now().After(*token.ExpiresAt) {
-		return "", sql.ErrNoRows
+		return "", "", sql.ErrNoRows
 	}
 
 	// Update last used timestamp
 	db.UpdateAPITokenLastUsed(token.ID)
 
-	return token.UserID, nil
+	return token.UserID, token.ID, nil
 }
\ No newline at end of file
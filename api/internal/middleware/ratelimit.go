@@ -43,47 +43,55 @@ func NewRateLimiter() *RateLimiter {
 }
 
 func (rl *RateLimiter) FileUploadMiddleware(maxRequests int, window time.Duration) gin.HandlerFunc {
+	return rl.Limit("upload", maxRequests, window, "Too many upload requests. Please try again later.")
+}
+
+// Limit allows each client maxRequests requests to the routes sharing
+// bucket, until it has been idle for window, and rejects the rest with 429
+// and message
+func (rl *RateLimiter) Limit(bucket string, maxRequests int, window time.Duration, message string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		clientIP := c.ClientIP()
-		
-		rl.mu.Lock()
-		defer rl.mu.Unlock()
-		
-		now := time.Now()
-		info, exists := rl.clients[clientIP]
-		
-		if !exists {
-			rl.clients[clientIP] = &clientInfo{
-				requests:    1,
-				lastRequest: now,
-			}
-			c.Next()
-			return
-		}
-		
-		// Reset counter if window has passed
-		if now.Sub(info.lastRequest) > window {
-			info.requests = 1
-			info.lastRequest = now
-			c.Next()
-			return
-		}
-		
-		// Check if limit exceeded
-		if info.requests >= maxRequests {
-			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error": "Too many upload requests. Please try again later.",
-			})
+		if !rl.allow(bucket+"|"+c.ClientIP(), maxRequests, window) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": message})
 			c.Abort()
 			return
 		}
-		
-		info.requests++
-		info.lastRequest = now
 		c.Next()
 	}
 }
 
+func (rl *RateLimiter) allow(key string, maxRequests int, window time.Duration) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	
+	now := time.Now()
+	info, exists := rl.clients[key]
+	
+	if !exists {
+		rl.clients[key] = &clientInfo{
+			requests:    1,
+			lastRequest: now,
+		}
+		return true
+	}
+	
+	// Reset counter if window has passed
+	if now.Sub(info.lastRequest) > window {
+		info.requests = 1
+		info.lastRequest = now
+		return true
+	}
+	
+	// Check if limit exceeded
+	if info.requests >= maxRequests {
+		return false
+	}
+	
+	info.requests++
+	info.lastRequest = now
+	return true
+}
+
 func (rl *RateLimiter) Stop() {
 	rl.cleanup.Stop()
 }
\ No newline at end of file
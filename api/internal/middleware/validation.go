@@ -23,7 +23,7 @@ func FileUploadValidationMiddleware() gin.HandlerFunc {
 		// Validate short codes if provided
 		shortCodes := c.PostFormArray("short_codes")
 		for _, shortCode := range shortCodes {
-			if !isValidShortCode(shortCode) {
+			if !IsValidShortCode(shortCode) {
 				c.JSON(http.StatusBadRequest, gin.H{
 					"error": "Invalid short code format. Short codes must be 3-32 characters long and contain only letters, numbers, hyphens, and underscores.",
 				})
@@ -32,6 +32,25 @@ func FileUploadValidationMiddleware() gin.HandlerFunc {
 			}
 		}
 
+		// Validate tags if provided
+		tags := c.PostFormArray("tags")
+		if len(tags) > 20 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "At most 20 tags may be given",
+			})
+			c.Abort()
+			return
+		}
+		for _, tag := range tags {
+			if strings.TrimSpace(tag) == "" || len(tag) > 50 {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "Tags must be 1-50 characters long",
+				})
+				c.Abort()
+				return
+			}
+		}
+
 		// Validate optional fields
 		if title := c.PostForm("title"); title != "" && len(title) > 255 {
 			c.JSON(http.StatusBadRequest, gin.H{
@@ -61,8 +80,8 @@ func FileUploadValidationMiddleware() gin.HandlerFunc {
 	}
 }
 
-// isValidShortCode checks if a short code meets the requirements
-func isValidShortCode(shortCode string) bool {
+// IsValidShortCode checks if a short code meets the requirements
+func IsValidShortCode(shortCode string) bool {
 	if len(shortCode) < 3 || len(shortCode) > 32 {
 		return false
 	}
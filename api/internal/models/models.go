@@ -20,58 +20,317 @@ type Domain struct {
 	Enabled   bool      `json:"enabled" db:"enabled"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	// Where visitors go when a link on this domain can't be followed and
+	// has no fallback of its own
+	FallbackURL string `json:"fallback_url,omitempty" db:"fallback_url"`
+	// Whether short codes of the default domain also resolve on this domain
+	// when it has no matching code. The default domain always uses them.
+	UseDefaultCodes bool `json:"use_default_codes" db:"use_default_codes"`
+	// How the domain's pages look to visitors
+	Branding DomainBranding `json:"branding"`
+}
+
+// DomainBranding customises the HTML pages served on a domain. Empty fields
+// keep the built-in look.
+type DomainBranding struct {
+	Name            string `json:"name,omitempty" db:"brand_name" binding:"omitempty,max=100"`
+	LogoURL         string `json:"logo_url,omitempty" db:"logo_url" binding:"omitempty,url"`
+	PrimaryColor    string `json:"primary_color,omitempty" db:"primary_color" binding:"omitempty,hexcolor"`
+	BackgroundColor string `json:"background_color,omitempty" db:"background_color" binding:"omitempty,hexcolor"`
+	FooterText      string `json:"footer_text,omitempty" db:"footer_text" binding:"omitempty,max=500"`
+}
+
+type CreateDomainRequest struct {
+	Domain          string          `json:"domain" binding:"required,hostname_port|hostname"`
+	IsDefault       bool            `json:"is_default"`
+	Enabled         *bool           `json:"enabled,omitempty"`
+	FallbackURL     string          `json:"fallback_url,omitempty" binding:"omitempty,url"`
+	UseDefaultCodes bool            `json:"use_default_codes"`
+	Branding        *DomainBranding `json:"branding,omitempty"`
+}
+
+type UpdateDomainRequest struct {
+	IsDefault       *bool   `json:"is_default,omitempty"`
+	Enabled         *bool   `json:"enabled,omitempty"`
+	FallbackURL     *string `json:"fallback_url,omitempty" binding:"omitempty,url|len=0"`
+	UseDefaultCodes *bool   `json:"use_default_codes,omitempty"`
+	// Replaces the whole branding; an empty object restores the defaults
+	Branding *DomainBranding `json:"branding,omitempty"`
 }
 
 type Link struct {
-	ID          string      `json:"id" db:"id"`
-	UserID      string      `json:"user_id" db:"user_id"`
-	DomainID    *string     `json:"domain_id,omitempty" db:"domain_id"`
-	Domain      *Domain     `json:"domain,omitempty" db:"-"`
-	ShortCodes  []ShortCode `json:"short_codes,omitempty" db:"-"`
-	OriginalURL string      `json:"original_url" db:"original_url"`
-	Title       string      `json:"title,omitempty" db:"title"`
-	Description string      `json:"description,omitempty" db:"description"`
-	Clicks      int         `json:"clicks" db:"clicks"`
-	Analytics   bool        `json:"analytics" db:"analytics"`
-	ExpiresAt   *time.Time  `json:"expires_at,omitempty" db:"expires_at"`
-	CreatedAt   time.Time   `json:"created_at" db:"created_at"`
-	UpdatedAt   time.Time   `json:"updated_at" db:"updated_at"`
+	ID            string         `json:"id" db:"id"`
+	UserID        string         `json:"user_id" db:"user_id"`
+	DomainID      *string        `json:"domain_id,omitempty" db:"domain_id"`
+	Domain        *Domain        `json:"domain,omitempty" db:"-"`
+	ShortCodes    []ShortCode    `json:"short_codes,omitempty" db:"-"`
+	OriginalURL   string         `json:"original_url" db:"original_url"`
+	ExpandedURL   string         `json:"expanded_url,omitempty" db:"-"`
+	RedirectRules []RedirectRule `json:"redirect_rules,omitempty" db:"-"`
+	Variants      []LinkVariant  `json:"variants,omitempty" db:"-"`
+	Tags          []string       `json:"tags,omitempty" db:"-"`
+	Title         string         `json:"title,omitempty" db:"title"`
+	Description   string         `json:"description,omitempty" db:"description"`
+	Clicks        int            `json:"clicks" db:"clicks"`
+	MaxClicks     *int           `json:"max_clicks,omitempty" db:"max_clicks"`
+	Analytics     bool           `json:"analytics" db:"analytics"`
+	ForwardQuery  bool           `json:"forward_query" db:"forward_query"`
+	ForwardPath   bool           `json:"forward_path" db:"forward_path"`
+	RedirectType  int            `json:"redirect_type" db:"redirect_type"`
+	Version       int            `json:"version" db:"version"`
+	UTMSource     string         `json:"utm_source,omitempty" db:"utm_source"`
+	UTMMedium     string         `json:"utm_medium,omitempty" db:"utm_medium"`
+	UTMCampaign   string         `json:"utm_campaign,omitempty" db:"utm_campaign"`
+	UTMTerm       string         `json:"utm_term,omitempty" db:"utm_term"`
+	UTMContent    string         `json:"utm_content,omitempty" db:"utm_content"`
+	OGTitle       string         `json:"og_title,omitempty" db:"og_title"`
+	OGDescription string         `json:"og_description,omitempty" db:"og_description"`
+	OGImage       string         `json:"og_image,omitempty" db:"og_image"`
+	FallbackURL   string         `json:"fallback_url,omitempty" db:"fallback_url"` // Used once the link expires or runs out of clicks
+	// Last background check of OriginalURL; HealthError is set instead of
+	// HealthStatusCode when no response was received
+	HealthStatusCode *int       `json:"health_status_code,omitempty" db:"health_status_code"`
+	HealthError      *string    `json:"health_error,omitempty" db:"health_error"`
+	HealthLatencyMs  *int       `json:"health_latency_ms,omitempty" db:"health_latency_ms"`
+	HealthCheckedAt  *time.Time `json:"health_checked_at,omitempty" db:"health_checked_at"`
+	Password         *string    `json:"-" db:"password"`
+	StartsAt         *time.Time `json:"starts_at,omitempty" db:"starts_at"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+	CreatedAt        time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at" db:"updated_at"`
+	// Set while the link is in the trash; PurgeAt is when it will be
+	// removed for good
+	DeletedAt *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	PurgeAt   *time.Time `json:"purge_at,omitempty" db:"-"`
 }
 
 type ShortCode struct {
 	ID        string    `json:"id" db:"id"`
 	LinkID    string    `json:"link_id" db:"link_id"`
+	DomainID  *string   `json:"domain_id,omitempty" db:"domain_id"`
 	ShortCode string    `json:"short_code" db:"short_code"`
 	IsPrimary bool      `json:"is_primary" db:"is_primary"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 }
 
+type AddShortCodeRequest struct {
+	ShortCode string `json:"short_code" binding:"required"`
+	IsPrimary bool   `json:"is_primary"`
+}
+
+// RedirectRule sends matching visitors to an alternate destination. Empty
+// criteria match anything; all non-empty criteria must match.
+type RedirectRule struct {
+	ID             string    `json:"id" db:"id"`
+	LinkID         string    `json:"link_id" db:"link_id"`
+	Position       int       `json:"position" db:"position"`
+	Platform       string    `json:"platform,omitempty" db:"platform"`
+	Language       string    `json:"language,omitempty" db:"language"`
+	Country        string    `json:"country,omitempty" db:"country"`
+	DestinationURL string    `json:"destination_url" db:"destination_url"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+}
+
+// RedirectRuleRequest sets a redirect rule. ID names an existing rule of
+// the link to update in place; without one, an existing rule with the same
+// conditions and destination is kept, so rules keep their IDs across updates.
+type RedirectRuleRequest struct {
+	ID             string `json:"id,omitempty"`
+	Platform       string `json:"platform,omitempty" binding:"omitempty,oneof=ios android windows macos linux other"`
+	Language       string `json:"language,omitempty" binding:"omitempty,max=35"`
+	Country        string `json:"country,omitempty" binding:"omitempty,len=2,alpha"`
+	DestinationURL string `json:"destination_url" binding:"required,url"`
+}
+
+// LinkVariant is one destination in a weighted A/B rotation. Visitors are
+// assigned a variant with probability Weight / total weight.
+type LinkVariant struct {
+	ID             string    `json:"id" db:"id"`
+	LinkID         string    `json:"link_id" db:"link_id"`
+	Position       int       `json:"position" db:"position"`
+	Label          string    `json:"label,omitempty" db:"label"`
+	DestinationURL string    `json:"destination_url" db:"destination_url"`
+	Weight         int       `json:"weight" db:"weight"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+}
+
+// LinkVariantRequest sets a variant. ID names an existing variant of the
+// link to update in place; without one, an existing variant with the same
+// label and destination is kept, so variants keep their IDs across updates.
+type LinkVariantRequest struct {
+	ID             string `json:"id,omitempty"`
+	Label          string `json:"label,omitempty" binding:"max=100"`
+	DestinationURL string `json:"destination_url" binding:"required,url"`
+	Weight         int    `json:"weight" binding:"required,min=1,max=10000"`
+}
+
+type VariantStats struct {
+	VariantID      string `json:"variant_id"`
+	Label          string `json:"label,omitempty"`
+	DestinationURL string `json:"destination_url"`
+	Weight         int    `json:"weight"`
+	Clicks         int    `json:"clicks"`
+	Removed        bool   `json:"removed,omitempty"` // No longer one of the link's variants
+}
+
 type Click struct {
-	ID        string    `json:"id" db:"id"`
-	LinkID    string    `json:"link_id" db:"link_id"`
-	IPAddress string    `json:"ip_address" db:"ip_address"`
-	UserAgent string    `json:"user_agent" db:"user_agent"`
-	Referer   string    `json:"referer,omitempty" db:"referer"`
-	Country   string    `json:"country,omitempty" db:"country"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	ID          string    `json:"id" db:"id"`
+	LinkID      string    `json:"link_id" db:"link_id"`
+	IPAddress   string    `json:"ip_address" db:"ip_address"`
+	UserAgent   string    `json:"user_agent" db:"user_agent"`
+	Referer     string    `json:"referer,omitempty" db:"referer"`
+	Country     string    `json:"country,omitempty" db:"country"`
+	RuleID      *string   `json:"rule_id,omitempty" db:"rule_id"`
+	VariantID   *string   `json:"variant_id,omitempty" db:"variant_id"`
+	Source      string    `json:"source,omitempty" db:"source"`
+	LinkVersion *int      `json:"link_version,omitempty" db:"link_version"` // Link version live at the time
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
 }
 
 type CreateLinkRequest struct {
-	OriginalURL string     `json:"original_url" binding:"required,url"`
-	ShortCodes  []string   `json:"short_codes,omitempty"`
-	DomainID    *string    `json:"domain_id,omitempty"`
-	Title       string     `json:"title,omitempty"`
-	Description string     `json:"description,omitempty"`
-	Analytics   bool       `json:"analytics"`
-	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	OriginalURL   string                `json:"original_url" binding:"required,url"`
+	ShortCodes    []string              `json:"short_codes,omitempty"`
+	DomainID      *string               `json:"domain_id,omitempty"`
+	Title         string                `json:"title,omitempty"`
+	Description   string                `json:"description,omitempty"`
+	Analytics     bool                  `json:"analytics"`
+	ForwardQuery  bool                  `json:"forward_query"`
+	ForwardPath   bool                  `json:"forward_path"`
+	RedirectType  int                   `json:"redirect_type,omitempty" binding:"omitempty,oneof=301 302 307 308"`
+	UTMSource     string                `json:"utm_source,omitempty" binding:"max=255"`
+	UTMMedium     string                `json:"utm_medium,omitempty" binding:"max=255"`
+	UTMCampaign   string                `json:"utm_campaign,omitempty" binding:"max=255"`
+	UTMTerm       string                `json:"utm_term,omitempty" binding:"max=255"`
+	UTMContent    string                `json:"utm_content,omitempty" binding:"max=255"`
+	OGTitle       string                `json:"og_title,omitempty" binding:"max=300"`
+	OGDescription string                `json:"og_description,omitempty" binding:"max=1000"`
+	OGImage       string                `json:"og_image,omitempty" binding:"omitempty,url"`
+	FallbackURL   string                `json:"fallback_url,omitempty" binding:"omitempty,url"`
+	Password      *string               `json:"password,omitempty" binding:"omitempty,min=6"`
+	MaxClicks     *int                  `json:"max_clicks,omitempty" binding:"omitempty,min=1"`
+	StartsAt      *time.Time            `json:"starts_at,omitempty"`
+	ExpiresAt     *time.Time            `json:"expires_at,omitempty"`
+	RedirectRules []RedirectRuleRequest `json:"redirect_rules,omitempty" binding:"omitempty,dive"`
+	Variants      []LinkVariantRequest  `json:"variants,omitempty" binding:"omitempty,dive"`
+	Tags          []string              `json:"tags,omitempty" binding:"omitempty,max=20,dive,required,max=50"`
 }
 
+// UpdateLinkRequest changes only the fields it sets; fields left out keep
+// their stored values
 type UpdateLinkRequest struct {
-	OriginalURL string     `json:"original_url,omitempty" binding:"omitempty,url"`
-	Title       string     `json:"title,omitempty"`
-	Description string     `json:"description,omitempty"`
-	Analytics   bool       `json:"analytics"`
-	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	OriginalURL   *string    `json:"original_url,omitempty" binding:"omitempty,url"`
+	Title         *string    `json:"title,omitempty"`
+	Description   *string    `json:"description,omitempty"`
+	Analytics     *bool      `json:"analytics,omitempty"`
+	ForwardQuery  *bool      `json:"forward_query,omitempty"`
+	ForwardPath   *bool      `json:"forward_path,omitempty"`
+	RedirectType  *int       `json:"redirect_type,omitempty" binding:"omitempty,oneof=301 302 307 308"`
+	UTMSource     *string    `json:"utm_source,omitempty" binding:"omitempty,max=255"`
+	UTMMedium     *string    `json:"utm_medium,omitempty" binding:"omitempty,max=255"`
+	UTMCampaign   *string    `json:"utm_campaign,omitempty" binding:"omitempty,max=255"`
+	UTMTerm       *string    `json:"utm_term,omitempty" binding:"omitempty,max=255"`
+	UTMContent    *string    `json:"utm_content,omitempty" binding:"omitempty,max=255"`
+	OGTitle       *string    `json:"og_title,omitempty" binding:"omitempty,max=300"`
+	OGDescription *string    `json:"og_description,omitempty" binding:"omitempty,max=1000"`
+	OGImage       *string    `json:"og_image,omitempty" binding:"omitempty,url|len=0"`
+	FallbackURL   *string    `json:"fallback_url,omitempty" binding:"omitempty,url|len=0"`
+	Password      *string    `json:"password,omitempty" binding:"omitempty,min=6"`
+	MaxClicks     *int       `json:"max_clicks,omitempty" binding:"omitempty,min=1"`
+	StartsAt      *time.Time `json:"starts_at,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	// RedirectRules replaces the link's rules when present; send an empty
+	// list to remove them all
+	RedirectRules []RedirectRuleRequest `json:"redirect_rules" binding:"omitempty,dive"`
+	// Variants replaces the link's A/B variants in the same way
+	Variants []LinkVariantRequest `json:"variants" binding:"omitempty,dive"`
+	// Tags replaces the link's tags in the same way
+	Tags []string `json:"tags" binding:"omitempty,max=20,dive,required,max=50"`
+	// Clear lists settings to remove, from the Clear* names
+	Clear []string `json:"clear,omitempty" binding:"omitempty,dive,oneof=password max_clicks starts_at expires_at"`
+}
+
+// Settings an update can remove by naming them in its Clear list
+const (
+	ClearPassword  = "password"
+	ClearMaxClicks = "max_clicks"
+	ClearStartsAt  = "starts_at"
+	ClearExpiresAt = "expires_at"
+)
+
+// Clears reports whether the update removes field
+func (r *UpdateLinkRequest) Clears(field string) bool {
+	return containsString(r.Clear, field)
+}
+
+// SetAndCleared returns the first setting the update both sets and clears,
+// or "" when there is none
+func (r *UpdateLinkRequest) SetAndCleared() string {
+	set := map[string]bool{
+		ClearPassword:  r.Password != nil,
+		ClearMaxClicks: r.MaxClicks != nil,
+		ClearStartsAt:  r.StartsAt != nil,
+		ClearExpiresAt: r.ExpiresAt != nil,
+	}
+	return firstSet(r.Clear, set)
+}
+
+// LinkVersion is a snapshot of a link's editable settings, recorded each
+// time they change
+type LinkVersion struct {
+	ID      string `json:"id" db:"id"`
+	LinkID  string `json:"link_id" db:"link_id"`
+	Version int    `json:"version" db:"version"`
+	Action  string `json:"action" db:"action"` // One of the LinkVersion* actions
+	// RestoredVersion is the version a rollback copied
+	RestoredVersion *int     `json:"restored_version,omitempty" db:"restored_version"`
+	ChangedFields   []string `json:"changed_fields" db:"changed_fields"`
+	UserID          *string  `json:"user_id,omitempty" db:"user_id"`
+	APITokenID      *string  `json:"api_token_id,omitempty" db:"api_token_id"`
+
+	OriginalURL       string                `json:"original_url" db:"original_url"`
+	Title             string                `json:"title,omitempty" db:"title"`
+	Description       string                `json:"description,omitempty" db:"description"`
+	Analytics         bool                  `json:"analytics" db:"analytics"`
+	ForwardQuery      bool                  `json:"forward_query" db:"forward_query"`
+	ForwardPath       bool                  `json:"forward_path" db:"forward_path"`
+	RedirectType      int                   `json:"redirect_type" db:"redirect_type"`
+	UTMSource         string                `json:"utm_source,omitempty" db:"utm_source"`
+	UTMMedium         string                `json:"utm_medium,omitempty" db:"utm_medium"`
+	UTMCampaign       string                `json:"utm_campaign,omitempty" db:"utm_campaign"`
+	UTMTerm           string                `json:"utm_term,omitempty" db:"utm_term"`
+	UTMContent        string                `json:"utm_content,omitempty" db:"utm_content"`
+	OGTitle           string                `json:"og_title,omitempty" db:"og_title"`
+	OGDescription     string                `json:"og_description,omitempty" db:"og_description"`
+	OGImage           string                `json:"og_image,omitempty" db:"og_image"`
+	FallbackURL       string                `json:"fallback_url,omitempty" db:"fallback_url"`
+	Password          *string               `json:"-" db:"password"`
+	PasswordProtected bool                  `json:"password_protected" db:"-"`
+	MaxClicks         *int                  `json:"max_clicks,omitempty" db:"max_clicks"`
+	StartsAt          *time.Time            `json:"starts_at,omitempty" db:"starts_at"`
+	ExpiresAt         *time.Time            `json:"expires_at,omitempty" db:"expires_at"`
+	RedirectRules     []RedirectRuleRequest `json:"redirect_rules" db:"redirect_rules"`
+	Variants          []LinkVariantRequest  `json:"variants" db:"variants"`
+	Tags              []string              `json:"tags" db:"tags"`
+	CreatedAt         time.Time             `json:"created_at" db:"created_at"`
+}
+
+const (
+	LinkVersionInitial  = "initial" // Settings when history started being kept
+	LinkVersionCreate   = "create"
+	LinkVersionUpdate   = "update"
+	LinkVersionRollback = "rollback"
+)
+
+type RollbackLinkRequest struct {
+	Version int `json:"version" binding:"required,min=1"`
+}
+
+// VersionStats counts the clicks a link received while a version was live
+type VersionStats struct {
+	Version     int       `json:"version"`
+	OriginalURL string    `json:"original_url"`
+	CreatedAt   time.Time `json:"created_at"`
+	Clicks      int       `json:"clicks"`
 }
 
 type RegisterRequest struct {
@@ -160,6 +419,7 @@ type File struct {
 	DomainID     *string    `json:"domain_id,omitempty" db:"domain_id"`
 	Domain       *Domain    `json:"domain,omitempty" db:"-"`
 	ShortCodes   []ShortCode `json:"short_codes,omitempty" db:"-"`
+	Tags         []string   `json:"tags,omitempty" db:"-"`
 	Filename     string     `json:"filename" db:"filename"`
 	OriginalName string     `json:"original_name" db:"original_name"`
 	MimeType     string     `json:"mime_type" db:"mime_type"`
@@ -172,9 +432,12 @@ type File struct {
 	Analytics    bool       `json:"analytics" db:"analytics"`
 	IsPublic     bool       `json:"is_public" db:"is_public"`
 	Password     *string    `json:"-" db:"password"`
+	StartsAt     *time.Time `json:"starts_at,omitempty" db:"starts_at"`
 	ExpiresAt    *time.Time `json:"expires_at,omitempty" db:"expires_at"`
 	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
 	UpdatedAt    time.Time  `json:"updated_at" db:"updated_at"`
+	DeletedAt    *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	PurgeAt      *time.Time `json:"purge_at,omitempty" db:"-"`
 }
 
 type FileDownload struct {
@@ -184,29 +447,118 @@ type FileDownload struct {
 	UserAgent string    `json:"user_agent" db:"user_agent"`
 	Referer   string    `json:"referer,omitempty" db:"referer"`
 	Country   string    `json:"country,omitempty" db:"country"`
+	Source    string    `json:"source,omitempty" db:"source"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 }
 
 type CreateFileRequest struct {
 	ShortCodes  []string   `json:"short_codes,omitempty"`
 	DomainID    *string    `json:"domain_id,omitempty"`
+	Tags        []string   `json:"tags,omitempty"`
 	Title       string     `json:"title,omitempty"`
 	Description string     `json:"description,omitempty"`
 	Analytics   bool       `json:"analytics"`
 	IsPublic    bool       `json:"is_public"`
 	Password    *string    `json:"password,omitempty"`
+	StartsAt    *time.Time `json:"starts_at,omitempty"`
 	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
 }
 
+// UpdateFileRequest changes only the fields it sets, like UpdateLinkRequest
 type UpdateFileRequest struct {
-	Title       string     `json:"title,omitempty"`
-	Description string     `json:"description,omitempty"`
-	Analytics   bool       `json:"analytics"`
-	IsPublic    bool       `json:"is_public"`
+	Title       *string    `json:"title,omitempty"`
+	Description *string    `json:"description,omitempty"`
+	Analytics   *bool      `json:"analytics,omitempty"`
+	IsPublic    *bool      `json:"is_public,omitempty"`
 	Password    *string    `json:"password,omitempty"`
+	StartsAt    *time.Time `json:"starts_at,omitempty"`
 	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	// Tags replaces the file's tags when present; send an empty list to
+	// remove them all
+	Tags []string `json:"tags" binding:"omitempty,max=20,dive,required,max=50"`
+	// Clear lists settings to remove, from the Clear* names
+	Clear []string `json:"clear,omitempty" binding:"omitempty,dive,oneof=password starts_at expires_at"`
 }
 
+// Clears reports whether the update removes field
+func (r *UpdateFileRequest) Clears(field string) bool {
+	return containsString(r.Clear, field)
+}
+
+// SetAndCleared returns the first setting the update both sets and clears,
+// or "" when there is none
+func (r *UpdateFileRequest) SetAndCleared() string {
+	set := map[string]bool{
+		ClearPassword:  r.Password != nil,
+		ClearStartsAt:  r.StartsAt != nil,
+		ClearExpiresAt: r.ExpiresAt != nil,
+	}
+	return firstSet(r.Clear, set)
+}
+
+func firstSet(cleared []string, set map[string]bool) string {
+	for _, field := range cleared {
+		if set[field] {
+			return field
+		}
+	}
+	return ""
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ListOptions filters, sorts and pages the link and file listings
+type ListOptions struct {
+	Tags          []string // Matches resources carrying every tag
+	DomainID      *string  // DomainDefault matches resources without a domain
+	Expiry        string   // One of the Expiry* values
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	Search        string // Substring of the title, description and URL or filename
+	Sort          string // One of the Sort* values
+	Descending    bool
+	Limit         int
+	Offset        int
+}
+
+const (
+	DomainDefault = "default"
+
+	ExpiryActive    = "active"    // Currently reachable
+	ExpiryExpired   = "expired"   // Past expires_at, or out of clicks for links
+	ExpiryScheduled = "scheduled" // starts_at is in the future
+	ExpiryNever     = "never"     // No expires_at
+
+	SortCreated   = "created"
+	SortUpdated   = "updated"
+	SortClicks    = "clicks"
+	SortDownloads = "downloads"
+)
+
+// SearchResult is one link or file matching a full-text search. Snippet
+// is HTML with the matched terms wrapped in <mark>; everything else in it
+// is escaped.
+type SearchResult struct {
+	Type    string  `json:"type"` // SearchTypeLink or SearchTypeFile
+	ID      string  `json:"id"`
+	Snippet string  `json:"snippet"`
+	Score   float64 `json:"score"` // Higher is more relevant
+	Link    *Link   `json:"link,omitempty"`
+	File    *File   `json:"file,omitempty"`
+}
+
+const (
+	SearchTypeLink = "link"
+	SearchTypeFile = "file"
+)
+
 type FileAnalyticsSummary struct {
 	FileID             string         `json:"file_id"`
 	TotalDownloads     int            `json:"total_downloads"`
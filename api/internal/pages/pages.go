@@ -0,0 +1,171 @@
+package pages
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"linker/internal/config"
+	"linker/internal/models"
+)
+
+// Pages served to visitors. Each is a template file of the same name with
+// an .html extension.
+const (
+	Landing     = "landing"
+	NotFound    = "not_found"
+	Expired     = "expired"
+	Unavailable = "unavailable" // Not yet active
+	Private     = "private"
+	Password    = "password"
+)
+
+// layoutFile is the document every page is rendered through. Page files
+// define the "title" and "content" templates it calls.
+const layoutFile = "layout.html"
+
+//go:embed templates/*.html
+var builtin embed.FS
+
+// Data is what page templates are executed with
+type Data struct {
+	Host     string
+	Branding models.DomainBranding
+
+	// Explanation shown on error pages
+	Message string
+
+	// Password form
+	Resource string // "link" or "file"
+	Action   string
+	Method   string
+	Error    string
+}
+
+// Renderer renders the public pages for each domain. Templates are looked
+// up in the domain's subdirectory of the templates directory, then the
+// templates directory itself, then the built-in set, so an override only
+// needs the files it changes.
+type Renderer struct {
+	dir    string
+	reload bool
+
+	mu    sync.RWMutex
+	cache map[string]*template.Template
+}
+
+// NewRenderer creates a renderer for the configured templates directory.
+// Outside production templates are read again on every render so edits show
+// up without a restart.
+func NewRenderer(cfg *config.Config) *Renderer {
+	return &Renderer{
+		dir:    cfg.Pages.TemplatesDir,
+		reload: cfg.Environment != "production",
+		cache:  make(map[string]*template.Template),
+	}
+}
+
+// Render writes a page with the given status, branded for domain, which is
+// nil for hosts without a domain record. Nothing is written when the
+// templates fail to load or execute.
+func (r *Renderer) Render(w http.ResponseWriter, status int, page string, domain *models.Domain, data *Data) error {
+	if domain != nil {
+		data.Host = domain.Domain
+		data.Branding = domain.Branding
+	}
+
+	tmpl, err := r.template(data.Host, page)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, layoutFile, data); err != nil {
+		return fmt.Errorf("failed to execute %s page: %w", page, err)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+func (r *Renderer) template(host, page string) (*template.Template, error) {
+	if r.reload {
+		return r.load(host, page)
+	}
+
+	key := host + "/" + page
+	r.mu.RLock()
+	tmpl, ok := r.cache[key]
+	r.mu.RUnlock()
+	if ok {
+		return tmpl, nil
+	}
+
+	tmpl, err := r.load(host, page)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.cache[key] = tmpl
+	r.mu.Unlock()
+	return tmpl, nil
+}
+
+// load parses the layout and page files from least to most specific, so
+// templates defined by a later file replace those of an earlier one
+func (r *Renderer) load(host, page string) (*template.Template, error) {
+	names := []string{layoutFile, page + ".html"}
+	tmpl := template.New(page)
+
+	for _, name := range names {
+		content, err := builtin.ReadFile("templates/" + name)
+		if err != nil {
+			return nil, fmt.Errorf("unknown page %q", page)
+		}
+		if _, err := tmpl.New(name).Parse(string(content)); err != nil {
+			return nil, fmt.Errorf("failed to parse built-in %s: %w", name, err)
+		}
+	}
+
+	if r.dir == "" {
+		return tmpl, nil
+	}
+
+	dirs := []string{r.dir}
+	if isSafeDirName(host) {
+		dirs = append(dirs, filepath.Join(r.dir, host))
+	}
+
+	for _, dir := range dirs {
+		for _, name := range names {
+			path := filepath.Join(dir, name)
+			content, err := os.ReadFile(path)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if _, err := tmpl.New(name).Parse(string(content)); err != nil {
+				return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+			}
+		}
+	}
+
+	return tmpl, nil
+}
+
+// isSafeDirName keeps host names from reaching outside the templates
+// directory
+func isSafeDirName(host string) bool {
+	return host != "" && host != "." && host != ".." && !strings.ContainsAny(host, `/\`)
+}
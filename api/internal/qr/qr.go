@@ -0,0 +1,232 @@
+package qr
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"net/url"
+	"strconv"
+	"strings"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const (
+	FormatPNG = "png"
+	FormatSVG = "svg"
+
+	MinSize   = 64
+	MaxSize   = 2048
+	MaxMargin = 16
+)
+
+// Options controls how a QR code is rendered
+type Options struct {
+	Format     string
+	Size       int // Width and height in pixels
+	Margin     int // Quiet zone in modules
+	Level      qrcode.RecoveryLevel
+	Foreground color.NRGBA
+	Background color.NRGBA
+}
+
+// DefaultOptions returns a 256px black on white PNG with medium error
+// correction and the standard 4 module quiet zone
+func DefaultOptions() Options {
+	return Options{
+		Format:     FormatPNG,
+		Size:       256,
+		Margin:     4,
+		Level:      qrcode.Medium,
+		Foreground: color.NRGBA{0, 0, 0, 255},
+		Background: color.NRGBA{255, 255, 255, 255},
+	}
+}
+
+var recoveryLevels = map[string]qrcode.RecoveryLevel{
+	"L": qrcode.Low,
+	"M": qrcode.Medium,
+	"Q": qrcode.High,
+	"H": qrcode.Highest,
+}
+
+// ParseOptions reads rendering options from query parameters: format
+// (png|svg), size, margin, ecc (L|M|Q|H), fg and bg (hex colours).
+// Missing parameters keep their defaults.
+func ParseOptions(values url.Values) (Options, error) {
+	opts := DefaultOptions()
+
+	if format := strings.ToLower(values.Get("format")); format != "" {
+		if format != FormatPNG && format != FormatSVG {
+			return opts, fmt.Errorf("format must be %s or %s", FormatPNG, FormatSVG)
+		}
+		opts.Format = format
+	}
+
+	if size := values.Get("size"); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil || n < MinSize || n > MaxSize {
+			return opts, fmt.Errorf("size must be between %d and %d", MinSize, MaxSize)
+		}
+		opts.Size = n
+	}
+
+	if margin := values.Get("margin"); margin != "" {
+		n, err := strconv.Atoi(margin)
+		if err != nil || n < 0 || n > MaxMargin {
+			return opts, fmt.Errorf("margin must be between 0 and %d", MaxMargin)
+		}
+		opts.Margin = n
+	}
+
+	if ecc := strings.ToUpper(values.Get("ecc")); ecc != "" {
+		level, ok := recoveryLevels[ecc]
+		if !ok {
+			return opts, fmt.Errorf("ecc must be one of L, M, Q or H")
+		}
+		opts.Level = level
+	}
+
+	if fg := values.Get("fg"); fg != "" {
+		c, err := parseHexColor(fg)
+		if err != nil {
+			return opts, fmt.Errorf("fg: %w", err)
+		}
+		opts.Foreground = c
+	}
+
+	if bg := values.Get("bg"); bg != "" {
+		c, err := parseHexColor(bg)
+		if err != nil {
+			return opts, fmt.Errorf("bg: %w", err)
+		}
+		opts.Background = c
+	}
+
+	return opts, nil
+}
+
+// ContentType returns the MIME type for the rendered format
+func (o Options) ContentType() string {
+	if o.Format == FormatSVG {
+		return "image/svg+xml"
+	}
+	return "image/png"
+}
+
+// Render encodes content as a QR code in the format selected by opts
+func Render(content string, opts Options) ([]byte, error) {
+	code, err := qrcode.New(content, opts.Level)
+	if err != nil {
+		return nil, err
+	}
+	code.DisableBorder = true
+	modules := code.Bitmap()
+
+	if opts.Format == FormatSVG {
+		return renderSVG(modules, opts), nil
+	}
+	return renderPNG(modules, opts)
+}
+
+func renderPNG(modules [][]bool, opts Options) ([]byte, error) {
+	total := len(modules) + 2*opts.Margin
+
+	// Scale modules to whole pixels so edges stay sharp, growing the image
+	// when the requested size is too small to fit every module
+	scale := opts.Size / total
+	if scale < 1 {
+		scale = 1
+	}
+	size := opts.Size
+	if total*scale > size {
+		size = total * scale
+	}
+	offset := (size-total*scale)/2 + opts.Margin*scale
+
+	palette := color.Palette{opts.Background, opts.Foreground}
+	img := image.NewPaletted(image.Rect(0, 0, size, size), palette)
+	for y, row := range modules {
+		for x, dark := range row {
+			if !dark {
+				continue
+			}
+			for py := 0; py < scale; py++ {
+				for px := 0; px < scale; px++ {
+					img.SetColorIndex(offset+x*scale+px, offset+y*scale+py, 1)
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func renderSVG(modules [][]bool, opts Options) []byte {
+	total := len(modules) + 2*opts.Margin
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		opts.Size, opts.Size, total, total)
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="%s"%s/>`,
+		total, total, hexColor(opts.Background), opacityAttr(opts.Background))
+	fmt.Fprintf(&buf, `<path fill="%s"%s d="`, hexColor(opts.Foreground), opacityAttr(opts.Foreground))
+
+	// One horizontal run per path segment keeps the output small
+	for y, row := range modules {
+		for x := 0; x < len(row); {
+			if !row[x] {
+				x++
+				continue
+			}
+			start := x
+			for x < len(row) && row[x] {
+				x++
+			}
+			fmt.Fprintf(&buf, "M%d %dh%dv1h-%dz", start+opts.Margin, y+opts.Margin, x-start, x-start)
+		}
+	}
+
+	buf.WriteString(`"/></svg>`)
+	return buf.Bytes()
+}
+
+// parseHexColor accepts RRGGBB or RRGGBBAA, with or without a leading '#'
+func parseHexColor(s string) (color.NRGBA, error) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) != 6 && len(s) != 8 {
+		return color.NRGBA{}, fmt.Errorf("colour must be a hex value like ff0000")
+	}
+
+	n, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("colour must be a hex value like ff0000")
+	}
+	if len(s) == 6 {
+		n = n<<8 | 0xff
+	}
+
+	return color.NRGBA{
+		R: uint8(n >> 24),
+		G: uint8(n >> 16),
+		B: uint8(n >> 8),
+		A: uint8(n),
+	}, nil
+}
+
+func hexColor(c color.NRGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+func opacityAttr(c color.NRGBA) string {
+	if c.A == 255 {
+		return ""
+	}
+	return fmt.Sprintf(` fill-opacity="%.3f"`, float64(c.A)/255)
+}
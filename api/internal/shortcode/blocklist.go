@@ -0,0 +1,157 @@
+package shortcode
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	"linker/internal/config"
+)
+
+var (
+	ErrReserved = errors.New("short code is reserved")
+	ErrBlocked  = errors.New("short code is not allowed")
+)
+
+// builtinReserved are kept free for pages and routes that may be added
+// later, in addition to the routes the server registers
+var builtinReserved = []string{
+	"about", "account", "admin", "api", "app", "assets", "auth", "dashboard",
+	"favicon.ico", "health", "help", "login", "logout", "metrics",
+	"register", "robots.txt", "settings", "signup", "static", "status", "www",
+}
+
+// Blocklist holds the short codes users may not claim. Reserved codes are
+// matched exactly, ignoring case. Denylist words are matched against whole
+// words of the code, so "badword" blocks "my-bad_word" but not
+// "notabadwordsmith". Words are split at hyphens, underscores and other
+// punctuation, between letters and digits and before capitals, and a word may
+// span several parts. Entries starting with '*' are matched anywhere in the
+// code instead, ignoring case, hyphens and underscores.
+type Blocklist struct {
+	reserved map[string]bool
+	denied   map[string]bool
+	anywhere []string
+}
+
+// NewBlocklist builds the reserved list from the built-in entries and the
+// configured ones, and loads the denylist file when one is configured
+func NewBlocklist(cfg *config.ShortCodeConfig) (*Blocklist, error) {
+	b := &Blocklist{reserved: make(map[string]bool), denied: make(map[string]bool)}
+	b.Reserve(builtinReserved...)
+	b.Reserve(cfg.Reserved...)
+
+	if cfg.DenylistFile != "" {
+		words, err := readWordList(cfg.DenylistFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read short code denylist: %w", err)
+		}
+		for _, word := range words {
+			if rest, ok := strings.CutPrefix(word, "*"); ok {
+				if normalized := normalize(rest); normalized != "" {
+					b.anywhere = append(b.anywhere, normalized)
+				}
+			} else if normalized := normalize(word); normalized != "" {
+				b.denied[normalized] = true
+			}
+		}
+	}
+
+	return b, nil
+}
+
+// Reserve adds codes to the reserved list. It is not safe to call once the
+// server is handling requests.
+func (b *Blocklist) Reserve(codes ...string) {
+	for _, code := range codes {
+		if code = strings.ToLower(strings.TrimSpace(code)); code != "" {
+			b.reserved[code] = true
+		}
+	}
+}
+
+// Check returns ErrReserved or ErrBlocked when the code may not be claimed
+func (b *Blocklist) Check(shortCode string) error {
+	if b.reserved[strings.ToLower(shortCode)] {
+		return ErrReserved
+	}
+
+	if len(b.denied) > 0 {
+		parts := splitWords(shortCode)
+		for i := range parts {
+			word := ""
+			for _, part := range parts[i:] {
+				word += part
+				if b.denied[word] {
+					return ErrBlocked
+				}
+			}
+		}
+	}
+
+	normalized := normalize(shortCode)
+	for _, word := range b.anywhere {
+		if strings.Contains(normalized, word) {
+			return ErrBlocked
+		}
+	}
+
+	return nil
+}
+
+// splitWords splits a code into lowercase parts at punctuation, between
+// letters and digits and before a capital that follows a lowercase letter
+func splitWords(s string) []string {
+	var parts []string
+	var current []rune
+	var prev rune
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			if len(current) > 0 {
+				parts = append(parts, string(current))
+				current = nil
+			}
+			prev = 0
+			continue
+		}
+		if len(current) > 0 && (unicode.IsDigit(r) != unicode.IsDigit(prev) || unicode.IsUpper(r) && unicode.IsLower(prev)) {
+			parts = append(parts, string(current))
+			current = nil
+		}
+		current = append(current, unicode.ToLower(r))
+		prev = r
+	}
+	if len(current) > 0 {
+		parts = append(parts, string(current))
+	}
+	return parts
+}
+
+func normalize(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	return strings.NewReplacer("-", "", "_", "").Replace(s)
+}
+
+// readWordList reads one entry per line, skipping blank lines and lines
+// starting with '#'
+func readWordList(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	return words, scanner.Err()
+}
@@ -0,0 +1,148 @@
+package shortcode
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"sync"
+
+	"linker/internal/config"
+)
+
+const (
+	AlphabetBase62      = "base62"
+	AlphabetLowercase   = "lowercase"
+	AlphabetUnambiguous = "unambiguous"
+
+	// Generated codes must still pass the custom short code validation
+	minCodeLength = 3
+	maxCodeLength = 32
+)
+
+var alphabets = map[string]string{
+	AlphabetBase62:    "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	AlphabetLowercase: "0123456789abcdefghijklmnopqrstuvwxyz",
+	// Leaves out 0/O/o, 1/I/i/l and similar pairs that are easy to misread
+	AlphabetUnambiguous: "23456789abcdefghjkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ",
+}
+
+var validPrefix = regexp.MustCompile(`^[a-zA-Z0-9_-]*$`)
+
+// ErrExhausted is returned when no free short code was found, even at the
+// maximum length
+var ErrExhausted = errors.New("no unused short code available")
+
+// TakenFunc reports whether a candidate short code is already in use
+type TakenFunc func(shortCode string) (bool, error)
+
+// Generator produces random short codes for links and files. When every
+// attempt at the current length collides the namespace is treated as
+// filling up and the length grows by one, up to MaxLength. The grown length
+// is kept for later calls.
+type Generator struct {
+	alphabet   string
+	maxLength  int
+	linkPrefix string
+	filePrefix string
+	attempts   int
+
+	mu     sync.Mutex
+	length int
+}
+
+func NewGenerator(cfg *config.ShortCodeConfig) (*Generator, error) {
+	alphabet, ok := alphabets[cfg.Alphabet]
+	if !ok {
+		return nil, fmt.Errorf("unknown short code alphabet %q", cfg.Alphabet)
+	}
+	if cfg.Attempts < 1 {
+		return nil, fmt.Errorf("short code attempts must be at least 1")
+	}
+	if cfg.MaxLength < cfg.Length {
+		return nil, fmt.Errorf("short code max_length must not be less than length")
+	}
+
+	for _, prefix := range []string{cfg.LinkPrefix, cfg.FilePrefix} {
+		if !validPrefix.MatchString(prefix) {
+			return nil, fmt.Errorf("short code prefix %q may only contain letters, numbers, hyphens, and underscores", prefix)
+		}
+		if len(prefix)+cfg.Length < minCodeLength || len(prefix)+cfg.MaxLength > maxCodeLength {
+			return nil, fmt.Errorf("short codes with prefix %q must be %d-%d characters long", prefix, minCodeLength, maxCodeLength)
+		}
+	}
+
+	return &Generator{
+		alphabet:   alphabet,
+		maxLength:  cfg.MaxLength,
+		linkPrefix: cfg.LinkPrefix,
+		filePrefix: cfg.FilePrefix,
+		attempts:   cfg.Attempts,
+		length:     cfg.Length,
+	}, nil
+}
+
+// LinkCode returns an unused short code for a link
+func (g *Generator) LinkCode(taken TakenFunc) (string, error) {
+	return g.generate(g.linkPrefix, taken)
+}
+
+// FileCode returns an unused short code for a file
+func (g *Generator) FileCode(taken TakenFunc) (string, error) {
+	return g.generate(g.filePrefix, taken)
+}
+
+// Length returns the length of the random part of new short codes
+func (g *Generator) Length() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.length
+}
+
+func (g *Generator) generate(prefix string, taken TakenFunc) (string, error) {
+	for length := g.Length(); length <= g.maxLength; length++ {
+		for i := 0; i < g.attempts; i++ {
+			random, err := g.random(length)
+			if err != nil {
+				return "", err
+			}
+
+			code := prefix + random
+			exists, err := taken(code)
+			if err != nil {
+				return "", err
+			}
+			if !exists {
+				return code, nil
+			}
+		}
+
+		g.grow(length + 1)
+	}
+
+	return "", ErrExhausted
+}
+
+// grow raises the length used for new codes, never past MaxLength and never
+// below a length another caller already grew to
+func (g *Generator) grow(length int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if length > g.length && length <= g.maxLength {
+		g.length = length
+	}
+}
+
+func (g *Generator) random(length int) (string, error) {
+	max := big.NewInt(int64(len(g.alphabet)))
+	code := make([]byte, length)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = g.alphabet[n.Int64()]
+	}
+	return string(code), nil
+}
@@ -0,0 +1,187 @@
+package trash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"linker/internal/config"
+	"linker/internal/database"
+	"linker/internal/models"
+	"linker/internal/storage"
+)
+
+const (
+	// Most files purged per pass, each needing a storage request
+	batchSize      = 100
+	storageTimeout = 10 * time.Second
+)
+
+// ErrStorageUnavailable is returned when purging a file without a storage
+// client, since its stored object can't be removed
+var ErrStorageUnavailable = errors.New("file storage is not enabled")
+
+// Purger permanently removes links and files that have been in the trash
+// longer than the retention period, including each file's stored object
+type Purger struct {
+	db        *database.Database
+	s3Client  *storage.S3Client
+	retention time.Duration
+	interval  time.Duration
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewPurger creates a purger. s3Client may be nil when storage is disabled,
+// in which case only links are purged. Trashed files are kept until storage
+// is enabled again so their stored objects are not orphaned.
+func NewPurger(db *database.Database, s3Client *storage.S3Client, cfg *config.Config) *Purger {
+	tc := cfg.Trash
+	defaults := config.DefaultTrashConfig()
+	if tc.RetentionDays <= 0 {
+		tc.RetentionDays = defaults.RetentionDays
+	}
+	if tc.PurgeIntervalMinutes <= 0 {
+		tc.PurgeIntervalMinutes = defaults.PurgeIntervalMinutes
+	}
+
+	return &Purger{
+		db:        db,
+		s3Client:  s3Client,
+		retention: time.Duration(tc.RetentionDays) * 24 * time.Hour,
+		interval:  time.Duration(tc.PurgeIntervalMinutes) * time.Minute,
+	}
+}
+
+// Retention is how long items stay in the trash
+func (p *Purger) Retention() time.Duration {
+	return p.retention
+}
+
+// Cutoff is the deletion time before which items are due to be purged
+func (p *Purger) Cutoff(now time.Time) time.Time {
+	return now.Add(-p.retention)
+}
+
+// PurgeAt is when an item deleted at deletedAt will be purged
+func (p *Purger) PurgeAt(deletedAt time.Time) time.Time {
+	return deletedAt.Add(p.retention)
+}
+
+// Start runs a pass straight away and then every interval until Stop
+func (p *Purger) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	p.done = make(chan struct{})
+
+	go func() {
+		defer close(p.done)
+
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			if purged, err := p.RunOnce(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Trash purge failed: %v", err)
+			} else if purged > 0 {
+				log.Printf("Purged %d items from the trash", purged)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop cancels a running pass and waits for the worker to exit
+func (p *Purger) Stop() {
+	if p.cancel == nil {
+		return
+	}
+	p.cancel()
+	<-p.done
+}
+
+// RunOnce purges every link and up to a batch of files whose retention has
+// passed, and returns how many were removed. Files whose stored object could
+// not be deleted stay in the trash and are retried on the next pass.
+func (p *Purger) RunOnce(ctx context.Context) (int, error) {
+	cutoff := p.Cutoff(time.Now())
+
+	links, err := p.db.PurgeTrashedLinks("", cutoff)
+	if err != nil {
+		return 0, err
+	}
+	purged := int(links)
+	if p.s3Client == nil {
+		return purged, nil
+	}
+
+	files, err := p.db.GetFilesDueForPurge(cutoff, batchSize)
+	if err != nil {
+		return purged, err
+	}
+
+	var lastErr error
+	for i := range files {
+		if ctx.Err() != nil {
+			return purged, ctx.Err()
+		}
+		if err := p.PurgeFile(ctx, &files[i]); err != nil {
+			lastErr = err
+			continue
+		}
+		purged++
+	}
+	return purged, lastErr
+}
+
+// PurgeFile deletes a trashed file's stored object and then its row, so a
+// storage failure leaves the file in the trash rather than orphaning the
+// object. It returns ErrStorageUnavailable without a storage client.
+func (p *Purger) PurgeFile(ctx context.Context, file *models.File) error {
+	if p.s3Client == nil {
+		return ErrStorageUnavailable
+	}
+
+	storageCtx, cancel := context.WithTimeout(ctx, storageTimeout)
+	defer cancel()
+	if err := p.s3Client.Delete(storageCtx, file.S3Key); err != nil {
+		return fmt.Errorf("failed to delete stored object of file %s: %w", file.ID, err)
+	}
+
+	return p.db.PurgeFile(file.ID, file.UserID)
+}
+
+// Empty purges everything in a user's trash straight away and returns how
+// many items were removed. Without a storage client files are left in the
+// trash.
+func (p *Purger) Empty(ctx context.Context, userID string) (int, error) {
+	links, err := p.db.PurgeTrashedLinks(userID, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	purged := int(links)
+	if p.s3Client == nil {
+		return purged, nil
+	}
+
+	files, err := p.db.GetTrashedFiles(userID)
+	if err != nil {
+		return purged, err
+	}
+
+	for i := range files {
+		if err := p.PurgeFile(ctx, &files[i]); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
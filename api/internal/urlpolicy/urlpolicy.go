@@ -0,0 +1,237 @@
+package urlpolicy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"linker/internal/config"
+)
+
+// Rejection reasons returned to API clients
+const (
+	ReasonInvalidURL       = "invalid_url"
+	ReasonSchemeNotAllowed = "scheme_not_allowed"
+	ReasonHostDenied       = "host_denied"
+	ReasonPrivateNetwork   = "private_network"
+	ReasonRedirectLoop     = "redirect_loop"
+	ReasonChainTooLong     = "redirect_chain_too_long"
+)
+
+const lookupTimeout = 2 * time.Second
+
+// Violation explains why a destination URL was rejected
+type Violation struct {
+	Reason  string
+	Message string
+}
+
+func (v *Violation) Error() string {
+	return v.Message
+}
+
+// ResolveFunc looks up the link behind one of our own short codes. It
+// returns an empty linkID when the code does not belong to a link.
+type ResolveFunc func(host, shortCode string) (linkID, destination string, err error)
+
+// Policy decides which destination URLs links may point at
+type Policy struct {
+	schemes       map[string]bool
+	deniedHosts   map[string]bool
+	maxChainHops  int
+	blockPrivate  bool
+	ownHosts      map[string]bool
+	linkPrefix    string
+	lookupIPAddrs func(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// New builds a policy from the configuration, loading the host denylist
+// when one is configured. Hosts in allowed_domains are treated as our own
+// short domains when looking for redirect chains.
+func New(cfg *config.Config) (*Policy, error) {
+	p := &Policy{
+		schemes:       make(map[string]bool),
+		deniedHosts:   make(map[string]bool),
+		maxChainHops:  cfg.URLPolicy.MaxChainHops,
+		blockPrivate:  cfg.URLPolicy.BlockPrivateNetworks,
+		ownHosts:      make(map[string]bool),
+		linkPrefix:    cfg.LinkPrefix,
+		lookupIPAddrs: net.DefaultResolver.LookupIPAddr,
+	}
+
+	for _, scheme := range cfg.URLPolicy.AllowedSchemes {
+		p.schemes[strings.ToLower(scheme)] = true
+	}
+
+	for _, host := range append([]string{cfg.DefaultDomain}, cfg.AllowedDomains...) {
+		if host = normalizeHost(host); host != "" {
+			p.ownHosts[host] = true
+		}
+	}
+
+	if cfg.URLPolicy.DenylistFile != "" {
+		hosts, err := readHostsFile(cfg.URLPolicy.DenylistFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read host denylist: %w", err)
+		}
+		for _, host := range hosts {
+			p.deniedHosts[normalizeHost(host)] = true
+		}
+	}
+
+	return p, nil
+}
+
+// Check validates a destination URL. linkID is the link being updated, so
+// pointing a link at itself is caught; it is empty for new links. resolve
+// may be nil when redirect chains should not be followed.
+func (p *Policy) Check(rawURL, linkID string, resolve ResolveFunc) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme == "" {
+		return &Violation{ReasonInvalidURL, "Destination is not a valid absolute URL"}
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	if !p.schemes[scheme] {
+		return &Violation{ReasonSchemeNotAllowed, fmt.Sprintf("URL scheme '%s' is not allowed", scheme)}
+	}
+
+	host := normalizeHost(u.Hostname())
+	if host == "" {
+		return &Violation{ReasonInvalidURL, "Destination URL has no host"}
+	}
+	if p.deniedHosts[host] {
+		return &Violation{ReasonHostDenied, fmt.Sprintf("Destination host '%s' is blocked", host)}
+	}
+
+	// Our own domains often resolve to private addresses behind a proxy
+	if p.blockPrivate && !p.isOwnHost(u) && p.isPrivateHost(host) {
+		return &Violation{ReasonPrivateNetwork, fmt.Sprintf("Destination host '%s' is on a private network", host)}
+	}
+
+	if resolve != nil {
+		return p.checkChain(u, linkID, resolve)
+	}
+	return nil
+}
+
+// checkChain follows destinations that are our own short links, rejecting
+// loops and chains longer than the configured number of hops
+func (p *Policy) checkChain(u *url.URL, linkID string, resolve ResolveFunc) error {
+	seen := make(map[string]bool)
+	if linkID != "" {
+		seen[linkID] = true
+	}
+
+	for hops := 1; ; hops++ {
+		host, shortCode, ok := p.shortLink(u)
+		if !ok {
+			return nil
+		}
+
+		nextID, destination, err := resolve(host, shortCode)
+		if err != nil {
+			return err
+		}
+		if nextID == "" {
+			return nil
+		}
+		if seen[nextID] {
+			return &Violation{ReasonRedirectLoop, "Destination forms a redirect loop"}
+		}
+		if hops > p.maxChainHops {
+			return &Violation{ReasonChainTooLong, fmt.Sprintf("Destination redirects through more than %d short link(s)", p.maxChainHops)}
+		}
+		seen[nextID] = true
+
+		if u, err = url.Parse(destination); err != nil {
+			return nil
+		}
+	}
+}
+
+// shortLink reports whether u is one of our own short link URLs
+func (p *Policy) shortLink(u *url.URL) (host, shortCode string, ok bool) {
+	if !p.isOwnHost(u) {
+		return "", "", false
+	}
+
+	rest := strings.TrimPrefix(u.Path, "/"+p.linkPrefix+"/")
+	if rest == u.Path || rest == "" {
+		return "", "", false
+	}
+
+	shortCode, _, _ = strings.Cut(rest, "/")
+	return u.Host, shortCode, true
+}
+
+func (p *Policy) isOwnHost(u *url.URL) bool {
+	return p.ownHosts[normalizeHost(u.Host)] || p.ownHosts[normalizeHost(u.Hostname())]
+}
+
+func (p *Policy) isPrivateHost(host string) bool {
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return IsPrivateIP(ip)
+	}
+
+	// A name that does not resolve yet is allowed; it is checked again
+	// whenever the link is updated
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+	addrs, err := p.lookupIPAddrs(ctx, host)
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if IsPrivateIP(addr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPrivateIP reports whether ip is loopback, RFC1918, link-local or unspecified
+func IsPrivateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast()
+}
+
+func normalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	host = strings.TrimSuffix(host, ".")
+	return strings.Trim(host, "[]")
+}
+
+// readHostsFile reads host names from a file in hosts-file format, e.g.
+// "0.0.0.0 bad.example another.example". Lines with a single field are taken
+// as a bare host name. Everything after '#' is a comment.
+func readHostsFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var hosts []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 1:
+			hosts = append(hosts, fields[0])
+		case len(fields) > 1:
+			hosts = append(hosts, fields[1:]...)
+		}
+	}
+	return hosts, scanner.Err()
+}
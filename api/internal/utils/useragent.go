@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Platforms recognised by ParsePlatform
+const (
+	PlatformIOS     = "ios"
+	PlatformAndroid = "android"
+	PlatformWindows = "windows"
+	PlatformMacOS   = "macos"
+	PlatformLinux   = "linux"
+	PlatformOther   = "other"
+)
+
+// ParsePlatform extracts the operating system family from a User-Agent header
+func ParsePlatform(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+
+	// Order matters: iPadOS and Android UAs also mention desktop platforms
+	switch {
+	case strings.Contains(ua, "iphone"), strings.Contains(ua, "ipad"), strings.Contains(ua, "ipod"):
+		return PlatformIOS
+	case strings.Contains(ua, "android"):
+		return PlatformAndroid
+	case strings.Contains(ua, "windows"):
+		return PlatformWindows
+	case strings.Contains(ua, "macintosh"), strings.Contains(ua, "mac os x"):
+		return PlatformMacOS
+	case strings.Contains(ua, "linux"), strings.Contains(ua, "x11"):
+		return PlatformLinux
+	default:
+		return PlatformOther
+	}
+}
+
+// socialCrawlers are the user agent tokens of link preview bots used by chat
+// apps and social networks
+var socialCrawlers = []string{
+	"slackbot",
+	"twitterbot",
+	"discordbot",
+	"facebookexternalhit",
+	"linkedinbot",
+	"telegrambot",
+	"whatsapp",
+}
+
+// IsSocialCrawler reports whether a User-Agent belongs to a link preview bot
+func IsSocialCrawler(userAgent string) bool {
+	ua := strings.ToLower(userAgent)
+	for _, crawler := range socialCrawlers {
+		if strings.Contains(ua, crawler) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseAcceptLanguage returns the language tags from an Accept-Language
+// header, lowercased and ordered by preference. Tags with q=0 are dropped.
+func ParseAcceptLanguage(header string) []string {
+	type weighted struct {
+		tag     string
+		quality float64
+	}
+
+	var langs []weighted
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		if quality <= 0 {
+			continue
+		}
+
+		langs = append(langs, weighted{tag: tag, quality: quality})
+	}
+
+	sort.SliceStable(langs, func(i, j int) bool {
+		return langs[i].quality > langs[j].quality
+	})
+
+	tags := make([]string, 0, len(langs))
+	for _, lang := range langs {
+		tags = append(tags, lang.tag)
+	}
+	return tags
+}
@@ -2,6 +2,9 @@ package main
 
 import (
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"linker/internal/api"
 	"linker/internal/config"
@@ -19,8 +22,21 @@ func main() {
 
 	server := api.NewServer(cfg, db)
 	
+	// Shut down cleanly on a signal so queued clicks and downloads are
+	// written before exiting
+	stopped := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		<-signals
+		log.Println("Shutting down server")
+		server.Shutdown()
+		close(stopped)
+	}()
+
 	log.Printf("Starting server on port %s", cfg.Port)
 	if err := server.Start(); err != nil {
 		log.Fatal("Server failed to start:", err)
 	}
+	<-stopped
 }
\ No newline at end of file
@@ -0,0 +1,50 @@
+-- Scope short code uniqueness to (domain, short_code) so branded domains can
+-- reuse the same short code. A NULL domain_id is the default domain namespace.
+-- SQLite can't drop the column-level UNIQUE constraint, so recreate the table.
+
+CREATE TABLE short_codes_scoped (
+    id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(4))) || '-' || lower(hex(randomblob(2))) || '-4' || substr(lower(hex(randomblob(2))),2) || '-' || substr('89ab',abs(random()) % 4 + 1, 1) || substr(lower(hex(randomblob(2))),2) || '-' || lower(hex(randomblob(6)))),
+    link_id TEXT,
+    file_id TEXT,
+    domain_id TEXT,
+    short_code TEXT NOT NULL,
+    is_primary BOOLEAN DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (link_id) REFERENCES links (id) ON DELETE CASCADE,
+    FOREIGN KEY (file_id) REFERENCES files (id) ON DELETE CASCADE,
+    FOREIGN KEY (domain_id) REFERENCES domains (id)
+);
+
+-- Copy existing data, taking the namespace from the owning link or file
+INSERT INTO short_codes_scoped (id, link_id, file_id, domain_id, short_code, is_primary, created_at)
+SELECT sc.id, sc.link_id, sc.file_id,
+       COALESCE(l.domain_id, f.domain_id),
+       sc.short_code, sc.is_primary, sc.created_at
+FROM short_codes sc
+LEFT JOIN links l ON sc.link_id = l.id
+LEFT JOIN files f ON sc.file_id = f.id;
+
+-- Drop old table and rename new one
+DROP TABLE short_codes;
+ALTER TABLE short_codes_scoped RENAME TO short_codes;
+
+-- Recreate indexes
+CREATE INDEX IF NOT EXISTS idx_short_codes_link_id ON short_codes (link_id);
+CREATE INDEX IF NOT EXISTS idx_short_codes_file_id ON short_codes (file_id);
+CREATE INDEX IF NOT EXISTS idx_short_codes_code ON short_codes (short_code);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_short_codes_domain_code ON short_codes (COALESCE(domain_id, ''), short_code);
+
+-- Recreate reference validation triggers dropped with the old table
+CREATE TRIGGER validate_short_code_reference
+    BEFORE INSERT ON short_codes
+    WHEN (NEW.link_id IS NULL AND NEW.file_id IS NULL) OR (NEW.link_id IS NOT NULL AND NEW.file_id IS NOT NULL)
+BEGIN
+    SELECT RAISE(FAIL, 'Short code must reference either a link or a file, but not both or neither');
+END;
+
+CREATE TRIGGER validate_short_code_reference_update
+    BEFORE UPDATE ON short_codes
+    WHEN (NEW.link_id IS NULL AND NEW.file_id IS NULL) OR (NEW.link_id IS NOT NULL AND NEW.file_id IS NOT NULL)
+BEGIN
+    SELECT RAISE(FAIL, 'Short code must reference either a link or a file, but not both or neither');
+END;
@@ -0,0 +1,2 @@
+-- Add optional password protection to links
+ALTER TABLE links ADD COLUMN password TEXT;
@@ -0,0 +1,2 @@
+-- Allow links to stop working after a number of clicks
+ALTER TABLE links ADD COLUMN max_clicks INTEGER;
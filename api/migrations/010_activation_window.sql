@@ -0,0 +1,3 @@
+-- Allow links and files to be published ahead of time
+ALTER TABLE links ADD COLUMN starts_at DATETIME;
+ALTER TABLE files ADD COLUMN starts_at DATETIME;
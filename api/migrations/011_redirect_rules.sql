@@ -0,0 +1,19 @@
+-- Per-link targeted redirect rules, evaluated in position order. Empty
+-- criteria match any visitor; a rule matches when all set criteria match.
+CREATE TABLE IF NOT EXISTS link_redirect_rules (
+    id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(4))) || '-' || lower(hex(randomblob(2))) || '-4' || substr(lower(hex(randomblob(2))),2) || '-' || substr('89ab',abs(random()) % 4 + 1, 1) || substr(lower(hex(randomblob(2))),2) || '-' || lower(hex(randomblob(6)))),
+    link_id TEXT NOT NULL,
+    position INTEGER NOT NULL,
+    platform TEXT,
+    language TEXT,
+    country TEXT,
+    destination_url TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (link_id) REFERENCES links (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_link_redirect_rules_link_id ON link_redirect_rules (link_id, position);
+
+-- Record which rule served a click. No foreign key so click history
+-- survives when a link's rules are replaced.
+ALTER TABLE clicks ADD COLUMN rule_id TEXT;
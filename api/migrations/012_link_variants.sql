@@ -0,0 +1,19 @@
+-- Weighted destination variants for A/B rotation. A visitor is assigned a
+-- variant with probability weight / SUM(weight) and kept on it by cookie.
+CREATE TABLE IF NOT EXISTS link_variants (
+    id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(4))) || '-' || lower(hex(randomblob(2))) || '-4' || substr(lower(hex(randomblob(2))),2) || '-' || substr('89ab',abs(random()) % 4 + 1, 1) || substr(lower(hex(randomblob(2))),2) || '-' || lower(hex(randomblob(6)))),
+    link_id TEXT NOT NULL,
+    position INTEGER NOT NULL,
+    label TEXT,
+    destination_url TEXT NOT NULL,
+    weight INTEGER NOT NULL CHECK (weight > 0),
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (link_id) REFERENCES links (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_link_variants_link_id ON link_variants (link_id, position);
+
+-- Record which variant served a click. Like rule_id, this has no foreign key
+-- so experiment results survive when variants are replaced.
+ALTER TABLE clicks ADD COLUMN variant_id TEXT;
+CREATE INDEX IF NOT EXISTS idx_clicks_variant_id ON clicks (variant_id);
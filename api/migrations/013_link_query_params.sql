@@ -0,0 +1,8 @@
+-- Query string handling at redirect time: optionally forward the visitor's
+-- query parameters, and append stored UTM parameters
+ALTER TABLE links ADD COLUMN forward_query BOOLEAN NOT NULL DEFAULT 0;
+ALTER TABLE links ADD COLUMN utm_source TEXT NOT NULL DEFAULT '';
+ALTER TABLE links ADD COLUMN utm_medium TEXT NOT NULL DEFAULT '';
+ALTER TABLE links ADD COLUMN utm_campaign TEXT NOT NULL DEFAULT '';
+ALTER TABLE links ADD COLUMN utm_term TEXT NOT NULL DEFAULT '';
+ALTER TABLE links ADD COLUMN utm_content TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+-- Allow links to forward extra path segments after the short code, e.g.
+-- /s/docs/api/v2 -> <destination>/api/v2
+ALTER TABLE links ADD COLUMN forward_path BOOLEAN NOT NULL DEFAULT 0;
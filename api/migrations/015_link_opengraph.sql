@@ -0,0 +1,4 @@
+-- Custom OpenGraph metadata served to link preview crawlers
+ALTER TABLE links ADD COLUMN og_title TEXT NOT NULL DEFAULT '';
+ALTER TABLE links ADD COLUMN og_description TEXT NOT NULL DEFAULT '';
+ALTER TABLE links ADD COLUMN og_image TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+-- Where a click came from when it can be told apart, e.g. 'qr' for QR scans
+ALTER TABLE clicks ADD COLUMN source TEXT;
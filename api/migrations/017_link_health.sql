@@ -0,0 +1,8 @@
+-- Result of the last background check of each link's destination.
+-- health_error holds the failure kind when no HTTP response was received.
+ALTER TABLE links ADD COLUMN health_status_code INTEGER;
+ALTER TABLE links ADD COLUMN health_error TEXT;
+ALTER TABLE links ADD COLUMN health_latency_ms INTEGER;
+ALTER TABLE links ADD COLUMN health_checked_at DATETIME;
+
+CREATE INDEX IF NOT EXISTS idx_links_health_checked_at ON links (health_checked_at);
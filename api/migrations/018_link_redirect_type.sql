@@ -0,0 +1,2 @@
+-- HTTP status used when redirecting: 301, 302, 307 or 308
+ALTER TABLE links ADD COLUMN redirect_type INTEGER NOT NULL DEFAULT 302;
@@ -0,0 +1,24 @@
+-- User-defined tags on links and files. Tags compare case-insensitively,
+-- so "Marketing" and "marketing" are the same tag.
+CREATE TABLE IF NOT EXISTS link_tags (
+    link_id TEXT NOT NULL,
+    tag TEXT NOT NULL COLLATE NOCASE,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (link_id, tag),
+    FOREIGN KEY (link_id) REFERENCES links (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS file_tags (
+    file_id TEXT NOT NULL,
+    tag TEXT NOT NULL COLLATE NOCASE,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (file_id, tag),
+    FOREIGN KEY (file_id) REFERENCES files (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_link_tags_tag ON link_tags (tag);
+CREATE INDEX IF NOT EXISTS idx_file_tags_tag ON file_tags (tag);
+
+-- Indexes for the list endpoint's sort orders
+CREATE INDEX IF NOT EXISTS idx_links_user_updated ON links (user_id, updated_at);
+CREATE INDEX IF NOT EXISTS idx_files_user_updated ON files (user_id, updated_at);
@@ -0,0 +1,68 @@
+-- Full-text indexes over links and files, kept in sync by triggers.
+-- The indexes keep their own copy of the text rather than reading it from
+-- the source tables, because links and files have no stable integer rowid
+-- for an external content table to point at.
+CREATE VIRTUAL TABLE IF NOT EXISTS links_search USING fts5(
+    link_id UNINDEXED,
+    title,
+    description,
+    original_url,
+    tokenize = 'unicode61 remove_diacritics 2'
+);
+
+CREATE VIRTUAL TABLE IF NOT EXISTS files_search USING fts5(
+    file_id UNINDEXED,
+    original_name,
+    title,
+    description,
+    tokenize = 'unicode61 remove_diacritics 2'
+);
+
+CREATE TRIGGER IF NOT EXISTS links_search_insert
+    AFTER INSERT ON links
+BEGIN
+    INSERT INTO links_search (link_id, title, description, original_url)
+    VALUES (NEW.id, NEW.title, NEW.description, NEW.original_url);
+END;
+
+CREATE TRIGGER IF NOT EXISTS links_search_update
+    AFTER UPDATE OF title, description, original_url ON links
+BEGIN
+    DELETE FROM links_search WHERE link_id = OLD.id;
+    INSERT INTO links_search (link_id, title, description, original_url)
+    VALUES (NEW.id, NEW.title, NEW.description, NEW.original_url);
+END;
+
+CREATE TRIGGER IF NOT EXISTS links_search_delete
+    AFTER DELETE ON links
+BEGIN
+    DELETE FROM links_search WHERE link_id = OLD.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS files_search_insert
+    AFTER INSERT ON files
+BEGIN
+    INSERT INTO files_search (file_id, original_name, title, description)
+    VALUES (NEW.id, NEW.original_name, NEW.title, NEW.description);
+END;
+
+CREATE TRIGGER IF NOT EXISTS files_search_update
+    AFTER UPDATE OF original_name, title, description ON files
+BEGIN
+    DELETE FROM files_search WHERE file_id = OLD.id;
+    INSERT INTO files_search (file_id, original_name, title, description)
+    VALUES (NEW.id, NEW.original_name, NEW.title, NEW.description);
+END;
+
+CREATE TRIGGER IF NOT EXISTS files_search_delete
+    AFTER DELETE ON files
+BEGIN
+    DELETE FROM files_search WHERE file_id = OLD.id;
+END;
+
+-- Index what already exists
+INSERT INTO links_search (link_id, title, description, original_url)
+SELECT id, title, description, original_url FROM links;
+
+INSERT INTO files_search (file_id, original_name, title, description)
+SELECT id, original_name, title, description FROM files;
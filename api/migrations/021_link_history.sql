@@ -0,0 +1,66 @@
+-- Every version of a link's editable settings. A version is recorded when a
+-- link is created and whenever a change or rollback alters it. Redirect
+-- rules, variants and tags are stored as JSON arrays.
+CREATE TABLE IF NOT EXISTS link_versions (
+    id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(4))) || '-' || lower(hex(randomblob(2))) || '-4' || substr(lower(hex(randomblob(2))),2) || '-' || substr('89ab',abs(random()) % 4 + 1, 1) || substr(lower(hex(randomblob(2))),2) || '-' || lower(hex(randomblob(6)))),
+    link_id TEXT NOT NULL,
+    version INTEGER NOT NULL,
+    action TEXT NOT NULL,
+    restored_version INTEGER,
+    changed_fields TEXT NOT NULL DEFAULT '',
+    -- Who made the change. No foreign keys so history outlives tokens.
+    user_id TEXT,
+    api_token_id TEXT,
+    original_url TEXT NOT NULL,
+    title TEXT,
+    description TEXT,
+    analytics BOOLEAN,
+    forward_query BOOLEAN,
+    forward_path BOOLEAN,
+    redirect_type INTEGER,
+    utm_source TEXT,
+    utm_medium TEXT,
+    utm_campaign TEXT,
+    utm_term TEXT,
+    utm_content TEXT,
+    og_title TEXT,
+    og_description TEXT,
+    og_image TEXT,
+    password TEXT,
+    max_clicks INTEGER,
+    starts_at DATETIME,
+    expires_at DATETIME,
+    redirect_rules TEXT NOT NULL DEFAULT '[]',
+    variants TEXT NOT NULL DEFAULT '[]',
+    tags TEXT NOT NULL DEFAULT '[]',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (link_id, version),
+    FOREIGN KEY (link_id) REFERENCES links (id) ON DELETE CASCADE
+);
+
+-- The version currently live, recorded on each click so clicks can be
+-- attributed to the destination at the time. Clicks from before history
+-- was kept have no version. Existing links start at the version 1 recorded
+-- below.
+ALTER TABLE links ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE clicks ADD COLUMN link_version INTEGER;
+CREATE INDEX IF NOT EXISTS idx_clicks_link_version ON clicks (link_id, link_version);
+
+-- Existing links start their history with their current settings
+INSERT INTO link_versions (link_id, version, action, user_id, original_url, title, description,
+    analytics, forward_query, forward_path, redirect_type, utm_source, utm_medium, utm_campaign,
+    utm_term, utm_content, og_title, og_description, og_image, password, max_clicks, starts_at,
+    expires_at, redirect_rules, variants, tags, created_at)
+SELECT l.id, 1, 'initial', l.user_id, l.original_url, COALESCE(l.title, ''), COALESCE(l.description, ''),
+    l.analytics, l.forward_query, l.forward_path, l.redirect_type, l.utm_source, l.utm_medium, l.utm_campaign,
+    l.utm_term, l.utm_content, l.og_title, l.og_description, l.og_image, l.password, l.max_clicks, l.starts_at,
+    l.expires_at,
+    (SELECT json_group_array(json_object('id', id, 'platform', COALESCE(platform, ''), 'language', COALESCE(language, ''),
+            'country', COALESCE(country, ''), 'destination_url', destination_url))
+     FROM (SELECT * FROM link_redirect_rules WHERE link_id = l.id ORDER BY position)),
+    (SELECT json_group_array(json_object('id', id, 'label', COALESCE(label, ''), 'destination_url', destination_url,
+            'weight', weight))
+     FROM (SELECT * FROM link_variants WHERE link_id = l.id ORDER BY position)),
+    (SELECT json_group_array(tag) FROM (SELECT tag FROM link_tags WHERE link_id = l.id ORDER BY tag)),
+    CURRENT_TIMESTAMP
+FROM links l;
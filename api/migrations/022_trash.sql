@@ -0,0 +1,7 @@
+-- Soft deletion. A deleted link or file keeps its row, and with it its short
+-- codes, until it is restored or purged after the retention period.
+ALTER TABLE links ADD COLUMN deleted_at DATETIME;
+ALTER TABLE files ADD COLUMN deleted_at DATETIME;
+
+CREATE INDEX IF NOT EXISTS idx_links_deleted_at ON links (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_files_deleted_at ON files (deleted_at);
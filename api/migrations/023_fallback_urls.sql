@@ -0,0 +1,5 @@
+-- Where visitors go when a link can't be followed: the link's own fallback,
+-- then the fallback of the domain the visitor used
+ALTER TABLE links ADD COLUMN fallback_url TEXT NOT NULL DEFAULT '';
+ALTER TABLE link_versions ADD COLUMN fallback_url TEXT NOT NULL DEFAULT '';
+ALTER TABLE domains ADD COLUMN fallback_url TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,7 @@
+-- How a domain's public pages look: the not-found, expired, password and
+-- landing pages shown to browsers
+ALTER TABLE domains ADD COLUMN brand_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE domains ADD COLUMN logo_url TEXT NOT NULL DEFAULT '';
+ALTER TABLE domains ADD COLUMN primary_color TEXT NOT NULL DEFAULT '';
+ALTER TABLE domains ADD COLUMN background_color TEXT NOT NULL DEFAULT '';
+ALTER TABLE domains ADD COLUMN footer_text TEXT NOT NULL DEFAULT '';
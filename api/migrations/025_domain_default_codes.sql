@@ -0,0 +1,4 @@
+-- Whether a branded domain also answers with the default domain's short
+-- codes when it has no matching code of its own. Off unless enabled, so a
+-- brand's domain never serves another brand's links by accident.
+ALTER TABLE domains ADD COLUMN use_default_codes BOOLEAN NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+-- Where a download came from when it can be told apart, e.g. 'qr' for QR scans
+ALTER TABLE file_downloads ADD COLUMN source TEXT;
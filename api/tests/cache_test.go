@@ -0,0 +1,143 @@
+package tests
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"linker/internal/cache"
+	"linker/internal/models"
+)
+
+func TestCacheEvictionAndExpiry(t *testing.T) {
+	c := cache.New(2, time.Hour, 50*time.Millisecond)
+
+	c.Set("a", 1, nil, time.Time{}, c.Generation())
+	c.Set("b", 2, nil, time.Time{}, c.Generation())
+	c.Get("a") // b is now the least recently used
+	c.Set("c", 3, nil, time.Time{}, c.Generation())
+
+	if _, found := c.Get("b"); found {
+		t.Error("Expected the least recently used entry to be evicted")
+	}
+	if value, found := c.Get("a"); !found || value != 1 {
+		t.Errorf("Expected a to be kept, got %v (%v)", value, found)
+	}
+
+	c.Set("missing", nil, nil, time.Time{}, c.Generation())
+	if value, found := c.Get("missing"); !found || value != nil {
+		t.Errorf("Expected a remembered miss, got %v (%v)", value, found)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, found := c.Get("missing"); found {
+		t.Error("Expected the remembered miss to expire after the negative TTL")
+	}
+
+	// Entries never outlive the time they are given
+	c.Set("short", 4, nil, time.Now().Add(-time.Second), c.Generation())
+	if _, found := c.Get("short"); found {
+		t.Error("Expected an entry past its expiry to be dropped")
+	}
+
+	stats := c.Stats()
+	if stats.Capacity != 2 || stats.Size > 2 || stats.Evictions == 0 || stats.Expirations != 2 ||
+		stats.Hits != 2 || stats.NegativeHits != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	c := cache.New(10, time.Hour, time.Hour)
+
+	c.Set("domain-a/promo", "link-1", []string{"code:promo", "id:link-1"}, time.Time{}, c.Generation())
+	c.Set("domain-b/promo", nil, []string{"code:promo"}, time.Time{}, c.Generation())
+	c.Set("/other", "link-1", []string{"code:other", "id:link-1"}, time.Time{}, c.Generation())
+
+	c.Invalidate("code:promo")
+	if _, found := c.Get("domain-a/promo"); found {
+		t.Error("Expected every entry for the short code to be invalidated")
+	}
+	if _, found := c.Get("domain-b/promo"); found {
+		t.Error("Expected the remembered miss for the short code to be invalidated")
+	}
+	if _, found := c.Get("/other"); !found {
+		t.Error("Expected other short codes to be kept")
+	}
+
+	// A value loaded before an invalidation is not cached after it
+	generation := c.Generation()
+	c.Invalidate("id:link-1")
+	c.Set("/other", "stale", []string{"code:other"}, time.Time{}, generation)
+	if _, found := c.Get("/other"); found {
+		t.Error("Expected a value loaded before an invalidation to be discarded")
+	}
+}
+
+func TestRedirectCache(t *testing.T) {
+	db := setupTestDB(t)
+	db.EnableRedirectCache(100, time.Hour, time.Hour)
+	user := createTestUser(t, db, "cacheuser", "cache@example.com")
+
+	for i := 0; i < 2; i++ {
+		if _, err := db.GetLinkByShortCode("cached", nil); err != sql.ErrNoRows {
+			t.Fatalf("Expected unknown short code to fail with ErrNoRows, got %v", err)
+		}
+	}
+
+	link := createTestLink(t, db, &models.Link{UserID: user.ID, OriginalURL: "https://example.com/before"})
+	if err := db.CreateShortCode(link.ID, "cached", true); err != nil {
+		t.Fatalf("Failed to create short code: %v", err)
+	}
+
+	// Adding the code replaces the remembered miss
+	retrieved, err := db.GetLinkByShortCode("cached", nil)
+	if err != nil {
+		t.Fatalf("Expected new short code to resolve, got %v", err)
+	}
+	retrieved.OriginalURL = "https://example.com/changed-by-caller"
+
+	retrieved, err = db.GetLinkByShortCode("cached", nil)
+	if err != nil || retrieved.OriginalURL != "https://example.com/before" {
+		t.Errorf("Expected the cached link to be unaffected by callers, got %v (%v)", retrieved, err)
+	}
+
+	after := "https://example.com/after"
+	if err := db.UpdateLink(link.ID, user.ID, &models.UpdateLinkRequest{OriginalURL: &after}); err != nil {
+		t.Fatalf("Failed to update link: %v", err)
+	}
+	retrieved, err = db.GetLinkByShortCode("cached", nil)
+	if err != nil || retrieved.OriginalURL != after {
+		t.Errorf("Expected the update to be seen, got %v (%v)", retrieved, err)
+	}
+
+	if err := db.TrashLink(link.ID, user.ID); err != nil {
+		t.Fatalf("Failed to trash link: %v", err)
+	}
+	if _, err := db.GetLinkByShortCode("cached", nil); err != sql.ErrNoRows {
+		t.Errorf("Expected trashed link not to resolve, got %v", err)
+	}
+	if err := db.RestoreLink(link.ID, user.ID, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("Failed to restore link: %v", err)
+	}
+	if _, err := db.GetLinkByShortCode("cached", nil); err != nil {
+		t.Errorf("Expected restored link to resolve, got %v", err)
+	}
+
+	if err := db.CreateShortCode(link.ID, "cached-alias", false); err != nil {
+		t.Fatalf("Failed to create short code: %v", err)
+	}
+	if _, err := db.GetLinkByShortCode("cached-alias", nil); err != nil {
+		t.Errorf("Expected the alias to resolve, got %v", err)
+	}
+	if err := db.DeleteLinkShortCode(link.ID, "cached-alias"); err != nil {
+		t.Fatalf("Failed to delete short code: %v", err)
+	}
+	if _, err := db.GetLinkByShortCode("cached-alias", nil); err != sql.ErrNoRows {
+		t.Errorf("Expected the removed alias not to resolve, got %v", err)
+	}
+
+	stats := db.RedirectCacheStats()
+	if !stats.Enabled || stats.Links.Hits == 0 || stats.Links.NegativeHits == 0 || stats.Links.Invalidations == 0 {
+		t.Errorf("Unexpected link cache stats: %+v", stats.Links)
+	}
+}
@@ -0,0 +1,362 @@
+package tests
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"linker/internal/models"
+)
+
+func TestDomainCRUD(t *testing.T) {
+	db := setupTestDB(t)
+
+	primary := &models.Domain{Domain: "go.brand-a.com", IsDefault: true, Enabled: true}
+	if err := db.CreateDomain(primary); err != nil {
+		t.Fatalf("Failed to create domain: %v", err)
+	}
+
+	secondary := &models.Domain{Domain: "go.brand-b.com", Enabled: true}
+	if err := db.CreateDomain(secondary); err != nil {
+		t.Fatalf("Failed to create domain: %v", err)
+	}
+
+	byName, err := db.GetDomainByName("go.brand-b.com")
+	if err != nil {
+		t.Fatalf("Failed to retrieve domain by name: %v", err)
+	}
+	if byName.ID != secondary.ID {
+		t.Errorf("Expected domain ID %s, got %s", secondary.ID, byName.ID)
+	}
+
+	// Promoting a domain to default should demote the previous default
+	isDefault := true
+	if err := db.UpdateDomain(secondary.ID, &models.UpdateDomainRequest{IsDefault: &isDefault}); err != nil {
+		t.Fatalf("Failed to update domain: %v", err)
+	}
+
+	defaultDomain, err := db.GetDefaultDomain()
+	if err != nil {
+		t.Fatalf("Failed to retrieve default domain: %v", err)
+	}
+	if defaultDomain.ID != secondary.ID {
+		t.Errorf("Expected default domain %s, got %s", secondary.ID, defaultDomain.ID)
+	}
+
+	domains, err := db.GetDomains()
+	if err != nil {
+		t.Fatalf("Failed to list domains: %v", err)
+	}
+	defaults := 0
+	for _, d := range domains {
+		if d.IsDefault {
+			defaults++
+		}
+	}
+	if defaults != 1 {
+		t.Errorf("Expected exactly 1 default domain, got %d", defaults)
+	}
+
+	if err := db.DeleteDomain(primary.ID); err != nil {
+		t.Fatalf("Failed to delete domain: %v", err)
+	}
+	if _, err := db.GetDomainByID(primary.ID); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows for deleted domain, got %v", err)
+	}
+}
+
+func TestDomainFallbackURL(t *testing.T) {
+	db := setupTestDB(t)
+
+	domain := &models.Domain{Domain: "go.brand-c.com", Enabled: true, FallbackURL: "https://brand-c.com/"}
+	if err := db.CreateDomain(domain); err != nil {
+		t.Fatalf("Failed to create domain: %v", err)
+	}
+
+	retrieved, err := db.GetDomainByName("go.brand-c.com")
+	if err != nil {
+		t.Fatalf("Failed to retrieve domain: %v", err)
+	}
+	if retrieved.FallbackURL != domain.FallbackURL {
+		t.Errorf("Expected fallback_url %q, got %q", domain.FallbackURL, retrieved.FallbackURL)
+	}
+
+	// Updates that don't mention the fallback keep it
+	enabled := false
+	if err := db.UpdateDomain(domain.ID, &models.UpdateDomainRequest{Enabled: &enabled}); err != nil {
+		t.Fatalf("Failed to update domain: %v", err)
+	}
+	retrieved, err = db.GetDomainByID(domain.ID)
+	if err != nil {
+		t.Fatalf("Failed to retrieve domain: %v", err)
+	}
+	if retrieved.FallbackURL != domain.FallbackURL || retrieved.Enabled {
+		t.Errorf("Expected disabled domain to keep its fallback, got %+v", retrieved)
+	}
+
+	cleared := ""
+	if err := db.UpdateDomain(domain.ID, &models.UpdateDomainRequest{FallbackURL: &cleared}); err != nil {
+		t.Fatalf("Failed to update domain: %v", err)
+	}
+	retrieved, err = db.GetDomainByID(domain.ID)
+	if err != nil {
+		t.Fatalf("Failed to retrieve domain: %v", err)
+	}
+	if retrieved.FallbackURL != "" {
+		t.Errorf("Expected fallback_url to be cleared, got %q", retrieved.FallbackURL)
+	}
+}
+
+func TestDomainBranding(t *testing.T) {
+	db := setupTestDB(t)
+
+	branding := models.DomainBranding{
+		Name:         "Brand D",
+		LogoURL:      "https://brand-d.com/logo.png",
+		PrimaryColor: "#ff6600",
+		FooterText:   "© Brand D",
+	}
+	domain := &models.Domain{Domain: "go.brand-d.com", Enabled: true, Branding: branding}
+	if err := db.CreateDomain(domain); err != nil {
+		t.Fatalf("Failed to create domain: %v", err)
+	}
+
+	retrieved, err := db.GetDomainByName("go.brand-d.com")
+	if err != nil {
+		t.Fatalf("Failed to retrieve domain: %v", err)
+	}
+	if retrieved.Branding != branding {
+		t.Errorf("Expected branding %+v, got %+v", branding, retrieved.Branding)
+	}
+
+	// Updates that don't mention the branding keep it
+	isDefault := true
+	if err := db.UpdateDomain(domain.ID, &models.UpdateDomainRequest{IsDefault: &isDefault}); err != nil {
+		t.Fatalf("Failed to update domain: %v", err)
+	}
+	retrieved, err = db.GetDefaultDomain()
+	if err != nil {
+		t.Fatalf("Failed to retrieve domain: %v", err)
+	}
+	if retrieved.Branding != branding {
+		t.Errorf("Expected branding to be kept, got %+v", retrieved.Branding)
+	}
+
+	// A new branding replaces every field
+	replaced := models.DomainBranding{BackgroundColor: "#000"}
+	if err := db.UpdateDomain(domain.ID, &models.UpdateDomainRequest{Branding: &replaced}); err != nil {
+		t.Fatalf("Failed to update domain: %v", err)
+	}
+	retrieved, err = db.GetDomainByID(domain.ID)
+	if err != nil {
+		t.Fatalf("Failed to retrieve domain: %v", err)
+	}
+	if retrieved.Branding != replaced {
+		t.Errorf("Expected branding %+v, got %+v", replaced, retrieved.Branding)
+	}
+}
+
+func TestDomainUsage(t *testing.T) {
+	db := setupTestDB(t)
+	user := createTestUser(t, db, "domainuser", "domain@example.com")
+
+	domain := &models.Domain{Domain: "go.brand-a.com", Enabled: true}
+	if err := db.CreateDomain(domain); err != nil {
+		t.Fatalf("Failed to create domain: %v", err)
+	}
+
+	link := &models.Link{
+		UserID:      user.ID,
+		DomainID:    &domain.ID,
+		OriginalURL: "https://example.com",
+	}
+	if err := db.CreateLink(link); err != nil {
+		t.Fatalf("Failed to create link: %v", err)
+	}
+
+	count, err := db.CountDomainUsage(domain.ID)
+	if err != nil {
+		t.Fatalf("Failed to count domain usage: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected domain usage of 1, got %d", count)
+	}
+}
+
+func TestPerDomainShortCodes(t *testing.T) {
+	db := setupTestDB(t)
+	user := createTestUser(t, db, "nsuser", "ns@example.com")
+
+	brandA := &models.Domain{Domain: "go.brand-a.com", Enabled: true}
+	brandB := &models.Domain{Domain: "go.brand-b.com", Enabled: true}
+	for _, d := range []*models.Domain{brandA, brandB} {
+		if err := db.CreateDomain(d); err != nil {
+			t.Fatalf("Failed to create domain: %v", err)
+		}
+	}
+
+	linkA := &models.Link{UserID: user.ID, DomainID: &brandA.ID, OriginalURL: "https://a.example.com"}
+	linkB := &models.Link{UserID: user.ID, DomainID: &brandB.ID, OriginalURL: "https://b.example.com"}
+	linkDefault := &models.Link{UserID: user.ID, OriginalURL: "https://default.example.com"}
+	for _, l := range []*models.Link{linkA, linkB, linkDefault} {
+		if err := db.CreateLink(l); err != nil {
+			t.Fatalf("Failed to create link: %v", err)
+		}
+	}
+
+	// The same short code can exist once per domain
+	if err := db.CreateShortCode(linkA.ID, "promo", true); err != nil {
+		t.Fatalf("Failed to create short code on brand A: %v", err)
+	}
+	if err := db.CreateShortCode(linkB.ID, "promo", true); err != nil {
+		t.Fatalf("Failed to create short code on brand B: %v", err)
+	}
+	if err := db.CreateShortCode(linkDefault.ID, "shared", true); err != nil {
+		t.Fatalf("Failed to create short code on default domain: %v", err)
+	}
+
+	// ...but not twice in the same domain
+	if err := db.CreateShortCode(linkA.ID, "promo", false); err == nil {
+		t.Error("Expected error when creating duplicate short code in the same domain")
+	}
+
+	taken, err := db.ShortCodeExists("promo", &brandA.ID)
+	if err != nil {
+		t.Fatalf("Failed to check short code: %v", err)
+	}
+	if !taken {
+		t.Error("Expected 'promo' to be taken on brand A")
+	}
+	taken, err = db.ShortCodeExists("promo", nil)
+	if err != nil {
+		t.Fatalf("Failed to check short code: %v", err)
+	}
+	if taken {
+		t.Error("Expected 'promo' to be free on the default domain")
+	}
+
+	resolved, err := db.GetLinkByShortCode("promo", &brandB.ID)
+	if err != nil {
+		t.Fatalf("Failed to resolve short code: %v", err)
+	}
+	if resolved.ID != linkB.ID {
+		t.Errorf("Expected link %s on brand B, got %s", linkB.ID, resolved.ID)
+	}
+
+	// Branded codes never leak onto the default domain
+	if _, err := db.GetLinkByShortCode("promo", nil); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows for branded code on default domain, got %v", err)
+	}
+}
+
+func TestDomainDefaultCodesFallback(t *testing.T) {
+	db := setupTestDB(t)
+	db.EnableRedirectCache(100, time.Minute, time.Minute)
+	user := createTestUser(t, db, "fallbackuser", "fallback@example.com")
+
+	defaultDomain := &models.Domain{Domain: "short.example.com", IsDefault: true, Enabled: true}
+	brand := &models.Domain{Domain: "go.brand-c.com", Enabled: true}
+	for _, d := range []*models.Domain{defaultDomain, brand} {
+		if err := db.CreateDomain(d); err != nil {
+			t.Fatalf("Failed to create domain: %v", err)
+		}
+	}
+
+	link := createTestLink(t, db, &models.Link{UserID: user.ID, OriginalURL: "https://default.example.com"})
+	if err := db.CreateShortCode(link.ID, "shared", true); err != nil {
+		t.Fatalf("Failed to create short code: %v", err)
+	}
+	file := &models.File{UserID: user.ID, Filename: "a.pdf", MimeType: "application/pdf", S3Key: "a", S3Bucket: "b", IsPublic: true}
+	if err := db.CreateFile(file); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if err := db.CreateFileShortCode(file.ID, "f-shared", true); err != nil {
+		t.Fatalf("Failed to create file short code: %v", err)
+	}
+
+	tests := []struct {
+		name            string
+		domainID        *string
+		useDefaultCodes bool
+		resolves        bool
+	}{
+		{"no domain", nil, false, true},
+		{"default domain", &defaultDomain.ID, false, true},
+		{"branded domain", &brand.ID, false, false},
+		{"branded domain using default codes", &brand.ID, true, true},
+		{"branded domain no longer using default codes", &brand.ID, false, false},
+	}
+	for _, tt := range tests {
+		enabled := tt.useDefaultCodes
+		if err := db.UpdateDomain(brand.ID, &models.UpdateDomainRequest{UseDefaultCodes: &enabled}); err != nil {
+			t.Fatalf("Failed to update domain: %v", err)
+		}
+
+		// Looked up twice so the second answer comes from the cache
+		for i := 0; i < 2; i++ {
+			_, linkErr := db.GetLinkByShortCode("shared", tt.domainID)
+			_, fileErr := db.GetFileByShortCode("f-shared", tt.domainID)
+			if tt.resolves && (linkErr != nil || fileErr != nil) {
+				t.Errorf("%s: expected the default codes to resolve, got %v and %v", tt.name, linkErr, fileErr)
+			}
+			if !tt.resolves && (linkErr != sql.ErrNoRows || fileErr != sql.ErrNoRows) {
+				t.Errorf("%s: expected sql.ErrNoRows, got %v and %v", tt.name, linkErr, fileErr)
+			}
+		}
+	}
+
+	retrieved, err := db.GetDomainByID(brand.ID)
+	if err != nil {
+		t.Fatalf("Failed to retrieve domain: %v", err)
+	}
+	if retrieved.UseDefaultCodes {
+		t.Error("Expected use_default_codes to be off")
+	}
+}
+
+func TestDomainChangesRequireAdmin(t *testing.T) {
+	db := setupTestDB(t)
+	cfg := testServerConfig()
+	cfg.AllowedDomains = append(cfg.AllowedDomains, "go.brand-g.com")
+	cfg.AdminUsers = []string{"domainadmin"}
+	server := newTestServer(t, db, cfg)
+
+	admin := testToken(t, cfg, createTestUser(t, db, "domainadmin", "domainadmin@example.com"))
+	member := testToken(t, cfg, createTestUser(t, db, "domainmember", "domainmember@example.com"))
+
+	if w := doRequest(t, server, http.MethodPost, "/api/v1/domains", member, gin.H{"domain": "go.brand-g.com", "is_default": true}); w.Code != http.StatusForbidden {
+		t.Errorf("Expected non-admin create to get 403, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w := doRequest(t, server, http.MethodPost, "/api/v1/domains", admin, gin.H{"domain": "go.brand-g.com"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected admin create to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	var domain models.Domain
+	if err := json.Unmarshal(w.Body.Bytes(), &domain); err != nil {
+		t.Fatalf("Failed to decode domain: %v", err)
+	}
+
+	path := "/api/v1/domains/" + domain.ID
+	if w := doRequest(t, server, http.MethodPut, path, member, gin.H{"is_default": true}); w.Code != http.StatusForbidden {
+		t.Errorf("Expected non-admin update to get 403, got %d", w.Code)
+	}
+	if w := doRequest(t, server, http.MethodDelete, path, member, nil); w.Code != http.StatusForbidden {
+		t.Errorf("Expected non-admin delete to get 403, got %d", w.Code)
+	}
+	if w := doRequest(t, server, http.MethodGet, "/api/v1/domains", member, nil); w.Code != http.StatusOK {
+		t.Errorf("Expected any user to list domains, got %d", w.Code)
+	}
+
+	unchanged, err := db.GetDomainByID(domain.ID)
+	if err != nil || unchanged.IsDefault {
+		t.Errorf("Expected the domain to be unchanged, got %+v (%v)", unchanged, err)
+	}
+
+	if w := doRequest(t, server, http.MethodDelete, path, admin, nil); w.Code != http.StatusOK {
+		t.Errorf("Expected admin delete to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+}
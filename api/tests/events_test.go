@@ -0,0 +1,147 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"linker/internal/config"
+	"linker/internal/events"
+	"linker/internal/models"
+)
+
+func eventsConfig(queueSize, batchSize, flushIntervalMs int) *config.Config {
+	return &config.Config{Events: config.EventsConfig{
+		QueueSize:       queueSize,
+		BatchSize:       batchSize,
+		FlushIntervalMs: flushIntervalMs,
+	}}
+}
+
+func TestEventsBatchedWrites(t *testing.T) {
+	db := setupTestDB(t)
+	user := createTestUser(t, db, "eventsuser", "events@example.com")
+	link := createTestLink(t, db, &models.Link{UserID: user.ID, OriginalURL: "https://example.com", Analytics: true})
+
+	file := &models.File{
+		UserID:    user.ID,
+		Filename:  "report.pdf",
+		MimeType:  "application/pdf",
+		S3Key:     "events/report.pdf",
+		S3Bucket:  "test-bucket",
+		Analytics: true,
+	}
+	if err := db.CreateFile(file); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	recorder := events.NewRecorder(db, eventsConfig(100, 10, 60000))
+
+	clickedAt := time.Now().Add(-time.Minute).Truncate(time.Second)
+	recorder.RecordClick(link.ID, true, &models.Click{LinkID: link.ID, IPAddress: "127.0.0.1", CreatedAt: clickedAt})
+	recorder.RecordClick(link.ID, true, nil)
+	// Links with a click limit are counted before the redirect, so only the
+	// click row is queued
+	recorder.RecordClick(link.ID, false, &models.Click{LinkID: link.ID, IPAddress: "127.0.0.2"})
+	recorder.RecordDownload(file.ID, &models.FileDownload{FileID: file.ID, IPAddress: "127.0.0.1", Source: "qr"})
+	recorder.RecordDownload(file.ID, nil)
+	// Events for a link deleted while they were queued are skipped
+	recorder.RecordClick("deleted-link", true, &models.Click{LinkID: "deleted-link"})
+
+	if written := recorder.Flush(); written != 6 {
+		t.Fatalf("Expected 6 events written, got %d", written)
+	}
+
+	retrieved, err := db.GetLinkByID(link.ID, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to get link: %v", err)
+	}
+	if retrieved.Clicks != 2 {
+		t.Errorf("Expected 2 counted clicks, got %d", retrieved.Clicks)
+	}
+
+	clicks, err := db.GetLinkAnalytics(link.ID, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to get link analytics: %v", err)
+	}
+	if len(clicks) != 2 {
+		t.Fatalf("Expected 2 click rows, got %d", len(clicks))
+	}
+	if !clicks[1].CreatedAt.Equal(clickedAt) {
+		t.Errorf("Expected the click to keep its time %v, got %v", clickedAt, clicks[1].CreatedAt)
+	}
+
+	retrievedFile, err := db.GetFileByID(file.ID, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to get file: %v", err)
+	}
+	if retrievedFile.Downloads != 2 {
+		t.Errorf("Expected 2 downloads, got %d", retrievedFile.Downloads)
+	}
+	downloads, err := db.GetFileAnalytics(file.ID, user.ID)
+	if err != nil || len(downloads) != 1 {
+		t.Errorf("Expected 1 download row, got %d (%v)", len(downloads), err)
+	} else if downloads[0].Source != "qr" {
+		t.Errorf("Expected the download to keep its QR source, got %q", downloads[0].Source)
+	}
+
+	stats := recorder.Stats()
+	if stats.Enqueued != 6 || stats.Written != 6 || stats.Batches != 1 || stats.Queued != 0 || stats.LastFlushAt == nil {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestEventsDropWhenFull(t *testing.T) {
+	db := setupTestDB(t)
+	user := createTestUser(t, db, "dropuser", "drop@example.com")
+	link := createTestLink(t, db, &models.Link{UserID: user.ID, OriginalURL: "https://example.com"})
+
+	recorder := events.NewRecorder(db, eventsConfig(2, 10, 60000))
+	for i := 0; i < 5; i++ {
+		recorder.RecordClick(link.ID, true, nil)
+	}
+
+	stats := recorder.Stats()
+	if stats.Enqueued != 2 || stats.Dropped != 3 || stats.Queued != 2 || stats.Capacity != 2 {
+		t.Errorf("Expected 3 of 5 clicks dropped by a full queue, got %+v", stats)
+	}
+
+	// Requests may wait briefly for room before their event is dropped
+	waiting := events.NewRecorder(db, &config.Config{Events: config.EventsConfig{QueueSize: 1, BatchSize: 10, FlushIntervalMs: 60000, MaxWaitMs: 500}})
+	waiting.RecordClick(link.ID, true, nil)
+	flushed := make(chan struct{})
+	go func() {
+		defer close(flushed)
+		time.Sleep(50 * time.Millisecond)
+		waiting.Flush()
+	}()
+	waiting.RecordClick(link.ID, true, nil)
+	<-flushed
+	if stats := waiting.Stats(); stats.Waited != 1 || stats.Dropped != 0 {
+		t.Errorf("Expected the click to wait for room, got %+v", stats)
+	}
+}
+
+func TestEventsFlushOnStop(t *testing.T) {
+	db := setupTestDB(t)
+	user := createTestUser(t, db, "stopuser", "stop@example.com")
+	link := createTestLink(t, db, &models.Link{UserID: user.ID, OriginalURL: "https://example.com"})
+
+	// Batches of 2 are written as they fill; the interval never comes round
+	recorder := events.NewRecorder(db, eventsConfig(100, 2, 60000))
+	recorder.Start()
+	for i := 0; i < 5; i++ {
+		recorder.RecordClick(link.ID, true, nil)
+	}
+	recorder.Stop()
+
+	retrieved, err := db.GetLinkByID(link.ID, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to get link: %v", err)
+	}
+	if retrieved.Clicks != 5 {
+		t.Errorf("Expected every queued click written on stop, got %d", retrieved.Clicks)
+	}
+	if stats := recorder.Stats(); stats.Written != 5 || stats.Batches != 3 || stats.Queued != 0 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
@@ -19,31 +19,31 @@ func setupTestDB(t *testing.T) *database.Database {
 	originalDir, _ := os.Getwd()
 	projectRoot := filepath.Join(originalDir, "..")
 	os.Chdir(projectRoot)
-	
+
 	testDBPath := "./test_file_sharing.db"
 	os.Remove(testDBPath)
-	
+
 	db, err := database.Init(testDBPath)
 	if err != nil {
 		os.Chdir(originalDir) // Restore directory
 		t.Fatalf("Failed to initialize test database: %v", err)
 	}
-	
+
 	t.Cleanup(func() {
 		db.Close()
 		os.Remove(testDBPath)
 		os.Chdir(originalDir) // Restore directory
 	})
-	
+
 	return db
 }
 
 func setupTestFileSystem(t *testing.T) (*database.Database, *storage.S3Client) {
 	db := setupTestDB(t)
-	
+
 	// For unit tests, we'll mock S3 operations or skip them
 	var s3Client *storage.S3Client = nil
-	
+
 	return db, s3Client
 }
 
@@ -52,25 +52,25 @@ func createTestUser(t *testing.T, db *database.Database, username, email string)
 	if err != nil {
 		t.Fatalf("Failed to hash password: %v", err)
 	}
-	
+
 	user := &models.User{
 		Username: username,
 		Email:    email,
 		Password: hashedPassword,
 	}
-	
+
 	err = db.CreateUser(user)
 	if err != nil {
 		t.Fatalf("Failed to create test user: %v", err)
 	}
-	
+
 	return user
 }
 
 func TestCreateFile(t *testing.T) {
 	db, _ := setupTestFileSystem(t)
 	user := createTestUser(t, db, "fileuser", "fileuser@example.com")
-	
+
 	file := &models.File{
 		UserID:       user.ID,
 		Filename:     "test_image.jpg",
@@ -84,26 +84,26 @@ func TestCreateFile(t *testing.T) {
 		Analytics:    true,
 		IsPublic:     true,
 	}
-	
+
 	err := db.CreateFile(file)
 	if err != nil {
 		t.Fatalf("Failed to create file: %v", err)
 	}
-	
+
 	if file.ID == "" {
 		t.Fatal("File ID should be set after creation")
 	}
-	
+
 	// Test retrieving the file
 	retrievedFile, err := db.GetFileByID(file.ID, user.ID)
 	if err != nil {
 		t.Fatalf("Failed to retrieve file: %v", err)
 	}
-	
+
 	if retrievedFile.Filename != file.Filename {
 		t.Errorf("Expected filename %s, got %s", file.Filename, retrievedFile.Filename)
 	}
-	
+
 	if retrievedFile.UserID != user.ID {
 		t.Errorf("Expected user ID %s, got %s", user.ID, retrievedFile.UserID)
 	}
@@ -112,7 +112,7 @@ func TestCreateFile(t *testing.T) {
 func TestCreateFileShortCode(t *testing.T) {
 	db, _ := setupTestFileSystem(t)
 	user := createTestUser(t, db, "shortcodeuser", "shortcode@example.com")
-	
+
 	// Create a file
 	file := &models.File{
 		UserID:       user.ID,
@@ -124,12 +124,12 @@ func TestCreateFileShortCode(t *testing.T) {
 		S3Bucket:     "test-bucket",
 		IsPublic:     true,
 	}
-	
+
 	err := db.CreateFile(file)
 	if err != nil {
 		t.Fatalf("Failed to create file: %v", err)
 	}
-	
+
 	// Create short codes for the file
 	shortCodes := []string{"testpdf", "mydoc"}
 	for i, shortCode := range shortCodes {
@@ -139,27 +139,27 @@ func TestCreateFileShortCode(t *testing.T) {
 			t.Fatalf("Failed to create short code %s: %v", shortCode, err)
 		}
 	}
-	
+
 	// Test retrieving file by short code
-	retrievedFile, err := db.GetFileByShortCode("testpdf")
+	retrievedFile, err := db.GetFileByShortCode("testpdf", nil)
 	if err != nil {
 		t.Fatalf("Failed to retrieve file by short code: %v", err)
 	}
-	
+
 	if retrievedFile.ID != file.ID {
 		t.Errorf("Expected file ID %s, got %s", file.ID, retrievedFile.ID)
 	}
-	
+
 	// Test retrieving short codes for the file
 	shortCodeRecords, err := db.GetShortCodesByFileID(file.ID)
 	if err != nil {
 		t.Fatalf("Failed to retrieve short codes: %v", err)
 	}
-	
+
 	if len(shortCodeRecords) != 2 {
 		t.Errorf("Expected 2 short codes, got %d", len(shortCodeRecords))
 	}
-	
+
 	// Verify primary short code
 	primaryFound := false
 	for _, sc := range shortCodeRecords {
@@ -167,7 +167,7 @@ func TestCreateFileShortCode(t *testing.T) {
 			primaryFound = true
 		}
 	}
-	
+
 	if !primaryFound {
 		t.Error("Primary short code not found or incorrect")
 	}
@@ -176,7 +176,7 @@ func TestCreateFileShortCode(t *testing.T) {
 func TestFileDownloadTracking(t *testing.T) {
 	db, _ := setupTestFileSystem(t)
 	user := createTestUser(t, db, "analyticsuser", "analytics@example.com")
-	
+
 	// Create a file with analytics enabled
 	file := &models.File{
 		UserID:       user.ID,
@@ -189,12 +189,12 @@ func TestFileDownloadTracking(t *testing.T) {
 		Analytics:    true,
 		IsPublic:     true,
 	}
-	
+
 	err := db.CreateFile(file)
 	if err != nil {
 		t.Fatalf("Failed to create file: %v", err)
 	}
-	
+
 	// Simulate file downloads
 	downloads := []models.FileDownload{
 		{
@@ -210,14 +210,14 @@ func TestFileDownloadTracking(t *testing.T) {
 			Referer:   "https://test.com",
 		},
 	}
-	
+
 	for _, download := range downloads {
 		err := db.CreateFileDownload(&download)
 		if err != nil {
 			t.Fatalf("Failed to create download record: %v", err)
 		}
 	}
-	
+
 	// Increment download counter
 	err = db.IncrementFileDownloads(file.ID)
 	if err != nil {
@@ -227,27 +227,27 @@ func TestFileDownloadTracking(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Failed to increment downloads: %v", err)
 	}
-	
+
 	// Test analytics retrieval
 	analytics, err := db.GetFileAnalytics(file.ID, user.ID)
 	if err != nil {
 		t.Fatalf("Failed to get file analytics: %v", err)
 	}
-	
+
 	if len(analytics) != 2 {
 		t.Errorf("Expected 2 download records, got %d", len(analytics))
 	}
-	
+
 	// Test analytics summary
 	summary, err := db.GetFileAnalyticsSummary(file.ID, user.ID)
 	if err != nil {
 		t.Fatalf("Failed to get analytics summary: %v", err)
 	}
-	
+
 	if summary.TotalDownloads != 2 {
 		t.Errorf("Expected 2 total downloads, got %d", summary.TotalDownloads)
 	}
-	
+
 	if len(summary.TopReferrers) == 0 {
 		t.Error("Expected referrer data, got none")
 	}
@@ -256,14 +256,14 @@ func TestFileDownloadTracking(t *testing.T) {
 func TestFilePasswordProtection(t *testing.T) {
 	db, _ := setupTestFileSystem(t)
 	user := createTestUser(t, db, "pwduser", "pwd@example.com")
-	
+
 	// Hash a password
 	password := "secretfile123"
 	hashedPassword, err := auth.HashPassword(password)
 	if err != nil {
 		t.Fatalf("Failed to hash password: %v", err)
 	}
-	
+
 	// Create a password-protected file
 	file := &models.File{
 		UserID:       user.ID,
@@ -276,33 +276,33 @@ func TestFilePasswordProtection(t *testing.T) {
 		IsPublic:     false,
 		Password:     &hashedPassword,
 	}
-	
+
 	err = db.CreateFile(file)
 	if err != nil {
 		t.Fatalf("Failed to create password-protected file: %v", err)
 	}
-	
+
 	// Create short code
 	err = db.CreateFileShortCode(file.ID, "secretfile", true)
 	if err != nil {
 		t.Fatalf("Failed to create short code: %v", err)
 	}
-	
+
 	// Test password verification
-	retrievedFile, err := db.GetFileByShortCode("secretfile")
+	retrievedFile, err := db.GetFileByShortCode("secretfile", nil)
 	if err != nil {
 		t.Fatalf("Failed to retrieve file: %v", err)
 	}
-	
+
 	if retrievedFile.Password == nil {
 		t.Fatal("Expected password to be set")
 	}
-	
+
 	// Verify correct password
 	if !auth.CheckPassword(password, *retrievedFile.Password) {
 		t.Error("Password verification failed for correct password")
 	}
-	
+
 	// Verify incorrect password
 	if auth.CheckPassword("wrongpassword", *retrievedFile.Password) {
 		t.Error("Password verification should fail for incorrect password")
@@ -312,7 +312,7 @@ func TestFilePasswordProtection(t *testing.T) {
 func TestFileExpiration(t *testing.T) {
 	db, _ := setupTestFileSystem(t)
 	user := createTestUser(t, db, "expireuser", "expire@example.com")
-	
+
 	// Create a file that expires in the past
 	pastTime := time.Now().Add(-24 * time.Hour)
 	file := &models.File{
@@ -326,29 +326,29 @@ func TestFileExpiration(t *testing.T) {
 		IsPublic:     true,
 		ExpiresAt:    &pastTime,
 	}
-	
+
 	err := db.CreateFile(file)
 	if err != nil {
 		t.Fatalf("Failed to create expiring file: %v", err)
 	}
-	
+
 	// Create short code
 	err = db.CreateFileShortCode(file.ID, "expiredfile", true)
 	if err != nil {
 		t.Fatalf("Failed to create short code: %v", err)
 	}
-	
+
 	// Test that we can retrieve the file (expiration logic is handled at the handler level)
-	retrievedFile, err := db.GetFileByShortCode("expiredfile")
+	retrievedFile, err := db.GetFileByShortCode("expiredfile", nil)
 	if err != nil {
 		t.Fatalf("Failed to retrieve expired file: %v", err)
 	}
-	
+
 	// Verify expiration time
 	if retrievedFile.ExpiresAt == nil {
 		t.Fatal("Expected expiration time to be set")
 	}
-	
+
 	if !retrievedFile.ExpiresAt.Before(time.Now()) {
 		t.Error("File should be expired")
 	}
@@ -357,7 +357,7 @@ func TestFileExpiration(t *testing.T) {
 func TestUserFileAnalytics(t *testing.T) {
 	db, _ := setupTestFileSystem(t)
 	user := createTestUser(t, db, "statsuser", "stats@example.com")
-	
+
 	// Create multiple files
 	files := []*models.File{
 		{
@@ -381,13 +381,13 @@ func TestUserFileAnalytics(t *testing.T) {
 			IsPublic:     true,
 		},
 	}
-	
+
 	for _, file := range files {
 		err := db.CreateFile(file)
 		if err != nil {
 			t.Fatalf("Failed to create file: %v", err)
 		}
-		
+
 		// Simulate some downloads
 		for i := 0; i < 3; i++ {
 			err = db.IncrementFileDownloads(file.ID)
@@ -396,17 +396,17 @@ func TestUserFileAnalytics(t *testing.T) {
 			}
 		}
 	}
-	
+
 	// Test user file analytics
 	userStats, err := db.GetUserFileAnalytics(user.ID)
 	if err != nil {
 		t.Fatalf("Failed to get user file analytics: %v", err)
 	}
-	
+
 	if len(userStats) != 2 {
 		t.Errorf("Expected 2 files in user stats, got %d", len(userStats))
 	}
-	
+
 	for _, stat := range userStats {
 		if stat.TotalDownloads != 3 {
 			t.Errorf("Expected 3 downloads for file %s, got %d", stat.Filename, stat.TotalDownloads)
@@ -417,7 +417,7 @@ func TestUserFileAnalytics(t *testing.T) {
 func TestFileUpdateAndDelete(t *testing.T) {
 	db, _ := setupTestFileSystem(t)
 	user := createTestUser(t, db, "updateuser", "update@example.com")
-	
+
 	// Create a file
 	file := &models.File{
 		UserID:       user.ID,
@@ -431,44 +431,45 @@ func TestFileUpdateAndDelete(t *testing.T) {
 		Description:  "Original Description",
 		IsPublic:     true,
 	}
-	
+
 	err := db.CreateFile(file)
 	if err != nil {
 		t.Fatalf("Failed to create file: %v", err)
 	}
-	
+
 	// Test file update
+	title, description, isPublic := "Updated Title", "Updated Description", false
 	updateReq := &models.UpdateFileRequest{
-		Title:       "Updated Title",
-		Description: "Updated Description",
-		IsPublic:    false,
+		Title:       &title,
+		Description: &description,
+		IsPublic:    &isPublic,
 	}
-	
+
 	err = db.UpdateFile(file.ID, user.ID, updateReq)
 	if err != nil {
 		t.Fatalf("Failed to update file: %v", err)
 	}
-	
+
 	// Verify update
 	updatedFile, err := db.GetFileByID(file.ID, user.ID)
 	if err != nil {
 		t.Fatalf("Failed to retrieve updated file: %v", err)
 	}
-	
+
 	if updatedFile.Title != "Updated Title" {
 		t.Errorf("Expected title 'Updated Title', got '%s'", updatedFile.Title)
 	}
-	
+
 	if updatedFile.IsPublic {
 		t.Error("Expected file to be private after update")
 	}
-	
+
 	// Test file deletion
 	err = db.DeleteFile(file.ID, user.ID)
 	if err != nil {
 		t.Fatalf("Failed to delete file: %v", err)
 	}
-	
+
 	// Verify deletion
 	_, err = db.GetFileByID(file.ID, user.ID)
 	if err == nil {
@@ -480,19 +481,19 @@ func TestFileUpdateAndDelete(t *testing.T) {
 func createMultipartForm(filename string, content []byte, fields map[string]string) (*bytes.Buffer, string) {
 	var buf bytes.Buffer
 	writer := multipart.NewWriter(&buf)
-	
+
 	// Add file
 	fileWriter, err := writer.CreateFormFile("file", filename)
 	if err != nil {
 		return nil, ""
 	}
 	fileWriter.Write(content)
-	
+
 	// Add form fields
 	for key, value := range fields {
 		writer.WriteField(key, value)
 	}
-	
+
 	writer.Close()
 	return &buf, writer.FormDataContentType()
 }
@@ -500,7 +501,7 @@ func createMultipartForm(filename string, content []byte, fields map[string]stri
 func TestFileShortCodeUniqueness(t *testing.T) {
 	db, _ := setupTestFileSystem(t)
 	user := createTestUser(t, db, "uniqueuser", "unique@example.com")
-	
+
 	// Create first file
 	file1 := &models.File{
 		UserID:       user.ID,
@@ -512,18 +513,18 @@ func TestFileShortCodeUniqueness(t *testing.T) {
 		S3Bucket:     "test-bucket",
 		IsPublic:     true,
 	}
-	
+
 	err := db.CreateFile(file1)
 	if err != nil {
 		t.Fatalf("Failed to create first file: %v", err)
 	}
-	
+
 	// Create short code for first file
 	err = db.CreateFileShortCode(file1.ID, "uniquecode", true)
 	if err != nil {
 		t.Fatalf("Failed to create short code for first file: %v", err)
 	}
-	
+
 	// Create second file
 	file2 := &models.File{
 		UserID:       user.ID,
@@ -535,15 +536,71 @@ func TestFileShortCodeUniqueness(t *testing.T) {
 		S3Bucket:     "test-bucket",
 		IsPublic:     true,
 	}
-	
+
 	err = db.CreateFile(file2)
 	if err != nil {
 		t.Fatalf("Failed to create second file: %v", err)
 	}
-	
+
 	// Try to create same short code for second file - should fail
 	err = db.CreateFileShortCode(file2.ID, "uniquecode", true)
 	if err == nil {
 		t.Error("Expected error when creating duplicate short code")
 	}
-}
\ No newline at end of file
+}
+
+func TestFileTagsAndSearch(t *testing.T) {
+	db := setupTestDB(t)
+	user := createTestUser(t, db, "tagfileuser", "tagfile@example.com")
+
+	report := &models.File{UserID: user.ID, Filename: "a.pdf", OriginalName: "Quarterly_Report.pdf", MimeType: "application/pdf", S3Key: "a", S3Bucket: "b", IsPublic: true}
+	photo := &models.File{UserID: user.ID, Filename: "b.jpg", OriginalName: "holiday.jpg", MimeType: "image/jpeg", S3Key: "b", S3Bucket: "b", IsPublic: true}
+	for _, file := range []*models.File{report, photo} {
+		if err := db.CreateFile(file); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+
+	if err := db.SetFileTags(report.ID, []string{"finance"}); err != nil {
+		t.Fatalf("Failed to set tags: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := db.IncrementFileDownloads(photo.ID); err != nil {
+			t.Fatalf("Failed to record download: %v", err)
+		}
+	}
+
+	files, err := db.GetUserFiles(user.ID, &models.ListOptions{Tags: []string{"Finance"}, Limit: 20})
+	if err != nil {
+		t.Fatalf("Failed to list files: %v", err)
+	}
+	if len(files) != 1 || files[0].ID != report.ID || len(files[0].Tags) != 1 {
+		t.Errorf("Expected only the tagged report, got %v", files)
+	}
+
+	// The underscore is matched literally, not as a wildcard
+	files, err = db.GetUserFiles(user.ID, &models.ListOptions{Search: "y_r", Limit: 20})
+	if err != nil {
+		t.Fatalf("Failed to list files: %v", err)
+	}
+	if len(files) != 1 || files[0].ID != report.ID {
+		t.Errorf("Expected search to match the original filename, got %v", files)
+	}
+
+	files, err = db.GetUserFiles(user.ID, &models.ListOptions{Sort: models.SortDownloads, Descending: true, Limit: 20})
+	if err != nil {
+		t.Fatalf("Failed to list files: %v", err)
+	}
+	if len(files) != 2 || files[0].ID != photo.ID {
+		t.Error("Expected the most downloaded file first")
+	}
+
+	defaultDomain := models.DomainDefault
+	files, err = db.GetUserFiles(user.ID, &models.ListOptions{DomainID: &defaultDomain, Expiry: models.ExpiryNever, Limit: 20})
+	if err != nil {
+		t.Fatalf("Failed to list files: %v", err)
+	}
+	if len(files) != 2 {
+		t.Errorf("Expected both files on the default domain, got %d", len(files))
+	}
+}
@@ -0,0 +1,177 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"linker/internal/config"
+	"linker/internal/database"
+	"linker/internal/healthcheck"
+	"linker/internal/models"
+)
+
+func TestLinkHealthCheck(t *testing.T) {
+	db := setupTestDB(t)
+	user := createTestUser(t, db, "healthuser", "health@example.com")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			w.WriteHeader(http.StatusOK)
+		case "/no-head":
+			// Some servers reject HEAD but serve GET
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		case "/error":
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	// Nothing listens on a closed server's address
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closedURL := closed.URL
+	closed.Close()
+
+	urls := map[string]string{
+		"ok":      server.URL + "/ok",
+		"no-head": server.URL + "/no-head",
+		"missing": server.URL + "/missing",
+		"error":   server.URL + "/error",
+		"refused": closedURL + "/",
+	}
+	links := make(map[string]*models.Link)
+	for name, url := range urls {
+		links[name] = createTestLink(t, db, &models.Link{UserID: user.ID, OriginalURL: url})
+	}
+
+	// The test servers listen on loopback
+	cfg := &config.Config{HealthCheck: config.DefaultHealthCheckConfig()}
+	cfg.HealthCheck.PerHostDelayMs = 0
+	cfg.HealthCheck.AllowPrivateNetworks = true
+	checker := healthcheck.NewChecker(db, cfg)
+
+	checked, err := checker.RunOnce(context.Background())
+	if err != nil {
+		t.Fatalf("Health check pass failed: %v", err)
+	}
+	if checked != len(urls) {
+		t.Errorf("Expected %d links checked, got %d", len(urls), checked)
+	}
+
+	expected := map[string]int{"ok": 200, "no-head": 200, "missing": 404, "error": 502}
+	for name, status := range expected {
+		link, err := db.GetLinkByID(links[name].ID, user.ID)
+		if err != nil {
+			t.Fatalf("Failed to retrieve link: %v", err)
+		}
+		if link.HealthStatusCode == nil || *link.HealthStatusCode != status {
+			t.Errorf("Expected %s link status %d, got %v", name, status, link.HealthStatusCode)
+		}
+		if link.HealthCheckedAt == nil || link.HealthLatencyMs == nil {
+			t.Errorf("Expected %s link to record check time and latency", name)
+		}
+	}
+
+	refused, err := db.GetLinkByID(links["refused"].ID, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to retrieve link: %v", err)
+	}
+	if refused.HealthError == nil || *refused.HealthError != healthcheck.ErrorConnection {
+		t.Errorf("Expected connection_error for refused link, got %v", refused.HealthError)
+	}
+
+	// Everything was just checked, so nothing is due
+	if checked, err := checker.RunOnce(context.Background()); err != nil || checked != 0 {
+		t.Errorf("Expected no links due for a recheck, got %d (%v)", checked, err)
+	}
+
+	brokenCounts := map[string]int{
+		database.BrokenAny:         3,
+		database.BrokenClientError: 1,
+		database.BrokenServerError: 1,
+		database.BrokenNetwork:     1,
+		database.BrokenDNS:         0,
+	}
+	for filter, count := range brokenCounts {
+		broken, err := db.GetBrokenLinks(user.ID, filter, 20, 0)
+		if err != nil {
+			t.Fatalf("Failed to get broken links: %v", err)
+		}
+		if len(broken) != count {
+			t.Errorf("Expected %d broken links for filter %q, got %d", count, filter, len(broken))
+		}
+	}
+
+	// Changing the destination clears the stale result
+	fixed := server.URL + "/ok"
+	if err := db.UpdateLink(links["error"].ID, user.ID, &models.UpdateLinkRequest{OriginalURL: &fixed}); err != nil {
+		t.Fatalf("Failed to update link: %v", err)
+	}
+	updated, err := db.GetLinkByID(links["error"].ID, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to retrieve link: %v", err)
+	}
+	if updated.HealthCheckedAt != nil || updated.HealthStatusCode != nil {
+		t.Error("Expected health result to be cleared when the destination changes")
+	}
+
+	due, err := db.GetLinksDueForHealthCheck(time.Now().Add(-time.Hour), 10)
+	if err != nil {
+		t.Fatalf("Failed to get due links: %v", err)
+	}
+	if len(due) != 1 || due[0].LinkID != links["error"].ID {
+		t.Errorf("Expected only the updated link to be due, got %v", due)
+	}
+}
+
+func TestLinkHealthCheckRefusesPrivateNetworks(t *testing.T) {
+	requested := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = true
+	}))
+	defer server.Close()
+
+	// Refused by default, even when the URL policy allows private hosts
+	cfg := &config.Config{HealthCheck: config.DefaultHealthCheckConfig()}
+	cfg.HealthCheck.PerHostDelayMs = 0
+	checker := healthcheck.NewChecker(nil, cfg)
+
+	port := server.URL[strings.LastIndex(server.URL, ":")+1:]
+	for _, url := range []string{
+		server.URL + "/",
+		"http://localhost:" + port + "/",
+		"http://[::1]:" + port + "/",
+		"http://169.254.169.254/latest/meta-data/",
+	} {
+		result := checker.Check(context.Background(), url)
+		if result.Error != healthcheck.ErrorBlocked || result.StatusCode != 0 {
+			t.Errorf("Expected %s to be blocked, got %+v", url, result)
+		}
+	}
+	if requested {
+		t.Error("Expected no request to reach the private address")
+	}
+}
+
+func TestHealthCheckOffByDefault(t *testing.T) {
+	t.Setenv("CONFIG_FILE", t.TempDir()+"/missing.json")
+	t.Setenv("HEALTH_CHECK_ENABLED", "")
+	if config.Load().HealthCheck.Enabled {
+		t.Error("Expected the health checker to be off without HEALTH_CHECK_ENABLED")
+	}
+
+	t.Setenv("HEALTH_CHECK_ENABLED", "true")
+	if !config.Load().HealthCheck.Enabled {
+		t.Error("Expected HEALTH_CHECK_ENABLED=true to turn the health checker on")
+	}
+}
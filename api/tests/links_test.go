@@ -0,0 +1,1550 @@
+package tests
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"linker/internal/auth"
+	"linker/internal/database"
+	"linker/internal/models"
+)
+
+func createTestLink(t *testing.T, db *database.Database, link *models.Link) *models.Link {
+	if err := db.CreateLink(link); err != nil {
+		t.Fatalf("Failed to create link: %v", err)
+	}
+	return link
+}
+
+func TestLinkClickLimit(t *testing.T) {
+	db := setupTestDB(t)
+	user := createTestUser(t, db, "limituser", "limit@example.com")
+
+	maxClicks := 2
+	link := createTestLink(t, db, &models.Link{
+		UserID:      user.ID,
+		OriginalURL: "https://example.com/invite",
+		MaxClicks:   &maxClicks,
+	})
+
+	for i := 0; i < maxClicks; i++ {
+		counted, err := db.IncrementLinkClicks(link.ID)
+		if err != nil {
+			t.Fatalf("Failed to increment clicks: %v", err)
+		}
+		if !counted {
+			t.Fatalf("Expected click %d to be counted", i+1)
+		}
+	}
+
+	counted, err := db.IncrementLinkClicks(link.ID)
+	if err != nil {
+		t.Fatalf("Failed to increment clicks: %v", err)
+	}
+	if counted {
+		t.Error("Expected click beyond the limit to be rejected")
+	}
+
+	retrieved, err := db.GetLinkByID(link.ID, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to retrieve link: %v", err)
+	}
+	if retrieved.Clicks != maxClicks {
+		t.Errorf("Expected %d clicks, got %d", maxClicks, retrieved.Clicks)
+	}
+}
+
+func TestLinkClickLimitConcurrent(t *testing.T) {
+	db := setupTestDB(t)
+	user := createTestUser(t, db, "raceuser", "race@example.com")
+
+	maxClicks := 1
+	link := createTestLink(t, db, &models.Link{
+		UserID:      user.ID,
+		OriginalURL: "https://example.com/one-time",
+		MaxClicks:   &maxClicks,
+	})
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	accepted := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if counted, err := db.IncrementLinkClicks(link.ID); err == nil && counted {
+				mu.Lock()
+				accepted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if accepted > maxClicks {
+		t.Errorf("Expected at most %d accepted clicks, got %d", maxClicks, accepted)
+	}
+
+	retrieved, err := db.GetLinkByID(link.ID, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to retrieve link: %v", err)
+	}
+	if retrieved.Clicks > maxClicks {
+		t.Errorf("Expected clicks to stay within %d, got %d", maxClicks, retrieved.Clicks)
+	}
+}
+
+func TestLinkClickLimitCountFailure(t *testing.T) {
+	db := setupTestDB(t)
+	user := createTestUser(t, db, "countfailuser", "countfail@example.com")
+	server := newTestServer(t, db, testServerConfig())
+
+	maxClicks := 5
+	link := createTestLink(t, db, &models.Link{
+		UserID:      user.ID,
+		OriginalURL: "https://example.com/invite",
+		MaxClicks:   &maxClicks,
+	})
+	if err := db.CreateShortCode(link.ID, "countfail", true); err != nil {
+		t.Fatalf("Failed to create short code: %v", err)
+	}
+
+	// Make counting fail as it would on a locked database
+	if _, err := db.Exec(`CREATE TRIGGER fail_click_count BEFORE UPDATE OF clicks ON links
+		BEGIN SELECT RAISE(ABORT, 'database is locked'); END`); err != nil {
+		t.Fatalf("Failed to create trigger: %v", err)
+	}
+
+	w := doRequest(t, server, http.MethodGet, "/s/countfail", "", nil)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 when the click can't be counted, got %d", w.Code)
+	}
+	if location := w.Header().Get("Location"); location != "" {
+		t.Errorf("Expected no redirect, got %s", location)
+	}
+
+	if _, err := db.Exec(`DROP TRIGGER fail_click_count`); err != nil {
+		t.Fatalf("Failed to drop trigger: %v", err)
+	}
+	if w := doRequest(t, server, http.MethodGet, "/s/countfail", "", nil); w.Code != http.StatusFound {
+		t.Errorf("Expected the link to redirect once counting works, got %d", w.Code)
+	}
+}
+
+func TestLinkActivationWindow(t *testing.T) {
+	db := setupTestDB(t)
+	user := createTestUser(t, db, "launchuser", "launch@example.com")
+
+	startsAt := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	link := createTestLink(t, db, &models.Link{
+		UserID:      user.ID,
+		OriginalURL: "https://example.com/launch",
+		StartsAt:    &startsAt,
+	})
+
+	retrieved, err := db.GetLinkByID(link.ID, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to retrieve link: %v", err)
+	}
+	if retrieved.StartsAt == nil || !retrieved.StartsAt.Equal(startsAt) {
+		t.Fatalf("Expected starts_at %v, got %v", startsAt, retrieved.StartsAt)
+	}
+
+	// Rescheduling the launch only touches starts_at
+	rescheduled := startsAt.Add(time.Hour)
+	err = db.UpdateLink(link.ID, user.ID, &models.UpdateLinkRequest{StartsAt: &rescheduled})
+	if err != nil {
+		t.Fatalf("Failed to update link: %v", err)
+	}
+
+	retrieved, err = db.GetLinkByID(link.ID, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to retrieve link: %v", err)
+	}
+	if retrieved.StartsAt == nil || !retrieved.StartsAt.Equal(rescheduled) {
+		t.Errorf("Expected starts_at %v, got %v", rescheduled, retrieved.StartsAt)
+	}
+}
+
+func TestLinkRedirectRules(t *testing.T) {
+	db := setupTestDB(t)
+	user := createTestUser(t, db, "rulesuser", "rules@example.com")
+
+	link := createTestLink(t, db, &models.Link{
+		UserID:      user.ID,
+		OriginalURL: "https://example.com/app",
+		Analytics:   true,
+	})
+	if err := db.CreateShortCode(link.ID, "app", true); err != nil {
+		t.Fatalf("Failed to create short code: %v", err)
+	}
+
+	rules, err := db.SetLinkRedirectRules(link.ID, []models.RedirectRuleRequest{
+		{Platform: "ios", DestinationURL: "https://apps.apple.com/app/example"},
+		{Platform: "android", DestinationURL: "https://play.google.com/store/apps/details?id=example"},
+		{Language: "de", Country: "at", DestinationURL: "https://example.com/at"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to set redirect rules: %v", err)
+	}
+	if rules[2].Country != "AT" {
+		t.Errorf("Expected country to be normalised to AT, got %s", rules[2].Country)
+	}
+
+	resolved, err := db.GetLinkByShortCode("app", nil)
+	if err != nil {
+		t.Fatalf("Failed to resolve short code: %v", err)
+	}
+	if len(resolved.RedirectRules) != 3 {
+		t.Fatalf("Expected 3 redirect rules, got %d", len(resolved.RedirectRules))
+	}
+	for i, rule := range resolved.RedirectRules {
+		if rule.Position != i {
+			t.Errorf("Expected rule %d at position %d, got %d", i, i, rule.Position)
+		}
+	}
+
+	// Clicks remember which rule served them
+	click := &models.Click{LinkID: link.ID, IPAddress: "127.0.0.1", Country: "AT", RuleID: &rules[2].ID}
+	if err := db.CreateClick(click); err != nil {
+		t.Fatalf("Failed to create click: %v", err)
+	}
+	clicks, err := db.GetLinkAnalytics(link.ID, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to get link analytics: %v", err)
+	}
+	if len(clicks) != 1 || clicks[0].RuleID == nil || *clicks[0].RuleID != rules[2].ID {
+		t.Errorf("Expected click to record rule %s, got %+v", rules[2].ID, clicks)
+	}
+
+	// Replacing with an empty list clears the rules
+	if _, err := db.SetLinkRedirectRules(link.ID, []models.RedirectRuleRequest{}); err != nil {
+		t.Fatalf("Failed to clear redirect rules: %v", err)
+	}
+	remaining, err := db.GetRedirectRulesByLinkID(link.ID)
+	if err != nil {
+		t.Fatalf("Failed to get redirect rules: %v", err)
+	}
+	if len(remaining) != 0 {
+		t.Errorf("Expected no redirect rules, got %d", len(remaining))
+	}
+}
+
+func TestLinkRedirectRulesKeepIDs(t *testing.T) {
+	db := setupTestDB(t)
+	user := createTestUser(t, db, "ruleskeepuser", "ruleskeep@example.com")
+
+	link := createTestLink(t, db, &models.Link{UserID: user.ID, OriginalURL: "https://example.com/app", Analytics: true})
+	original, err := db.SetLinkRedirectRules(link.ID, []models.RedirectRuleRequest{
+		{Platform: "ios", DestinationURL: "https://apps.apple.com/app/example"},
+		{Country: "at", DestinationURL: "https://example.com/at"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to set redirect rules: %v", err)
+	}
+
+	// Resending a rule unchanged, or by ID with new settings, keeps its ID
+	updated, err := db.SetLinkRedirectRules(link.ID, []models.RedirectRuleRequest{
+		{ID: original[1].ID, Country: "de", DestinationURL: "https://example.com/de"},
+		{Platform: "IOS", DestinationURL: "https://apps.apple.com/app/example"},
+		{Platform: "android", DestinationURL: "https://play.google.com/store/apps/details?id=example"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to update redirect rules: %v", err)
+	}
+	if updated[0].ID != original[1].ID || updated[0].Country != "DE" || updated[1].ID != original[0].ID {
+		t.Errorf("Expected existing rules to keep their IDs, got %+v", updated)
+	}
+	if updated[2].ID == original[0].ID || updated[2].ID == original[1].ID {
+		t.Errorf("Expected a new ID for the new rule, got %s", updated[2].ID)
+	}
+
+	current, err := db.GetRedirectRulesByLinkID(link.ID)
+	if err != nil || len(current) != 3 || current[0].ID != original[1].ID || current[0].Position != 0 ||
+		current[0].DestinationURL != "https://example.com/de" {
+		t.Errorf("Expected rules updated in place, got %+v (%v)", current, err)
+	}
+
+	// Versions remember the rule IDs, so an update that resends the same
+	// rules keeps them
+	retrieved, err := db.GetLinkByID(link.ID, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to get link: %v", err)
+	}
+	version, err := db.RecordLinkVersion(retrieved, models.LinkVersionCreate, nil, user.ID, nil)
+	if err != nil {
+		t.Fatalf("Failed to record version: %v", err)
+	}
+	if len(version.RedirectRules) != 3 || version.RedirectRules[0].ID != original[1].ID {
+		t.Errorf("Expected the version to keep rule IDs, got %+v", version.RedirectRules)
+	}
+	restored, err := db.SetLinkRedirectRules(link.ID, version.RedirectRules)
+	if err != nil {
+		t.Fatalf("Failed to restore redirect rules: %v", err)
+	}
+	for i, rule := range restored {
+		if rule.ID != current[i].ID {
+			t.Errorf("Expected rule %d to keep ID %s, got %s", i, current[i].ID, rule.ID)
+		}
+	}
+}
+
+func TestLinkVariantStats(t *testing.T) {
+	db := setupTestDB(t)
+	user := createTestUser(t, db, "abuser", "ab@example.com")
+
+	link := createTestLink(t, db, &models.Link{
+		UserID:      user.ID,
+		OriginalURL: "https://example.com/landing",
+		Analytics:   true,
+	})
+
+	variants, err := db.SetLinkVariants(link.ID, []models.LinkVariantRequest{
+		{Label: "control", DestinationURL: "https://example.com/landing-a", Weight: 70},
+		{Label: "challenger", DestinationURL: "https://example.com/landing-b", Weight: 30},
+	})
+	if err != nil {
+		t.Fatalf("Failed to set variants: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := db.CreateClick(&models.Click{LinkID: link.ID, IPAddress: "127.0.0.1", VariantID: &variants[0].ID}); err != nil {
+			t.Fatalf("Failed to create click: %v", err)
+		}
+	}
+	if err := db.CreateClick(&models.Click{LinkID: link.ID, IPAddress: "127.0.0.1"}); err != nil {
+		t.Fatalf("Failed to create click: %v", err)
+	}
+
+	stats, err := db.GetLinkVariantStats(link.ID, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to get variant stats: %v", err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("Expected stats for 2 variants, got %d", len(stats))
+	}
+	if stats[0].Label != "control" || stats[0].Clicks != 3 {
+		t.Errorf("Expected 3 clicks for control, got %+v", stats[0])
+	}
+	if stats[1].Label != "challenger" || stats[1].Clicks != 0 {
+		t.Errorf("Expected 0 clicks for challenger, got %+v", stats[1])
+	}
+
+	// Stats are scoped to the link owner
+	other := createTestUser(t, db, "otheruser", "other@example.com")
+	stats, err = db.GetLinkVariantStats(link.ID, other.ID)
+	if err != nil {
+		t.Fatalf("Failed to get variant stats: %v", err)
+	}
+	if len(stats) != 0 {
+		t.Errorf("Expected no stats for another user, got %d", len(stats))
+	}
+}
+
+func TestLinkVariantsKeepIDs(t *testing.T) {
+	db := setupTestDB(t)
+	user := createTestUser(t, db, "abkeepuser", "abkeep@example.com")
+
+	link := createTestLink(t, db, &models.Link{UserID: user.ID, OriginalURL: "https://example.com/landing", Analytics: true})
+	original, err := db.SetLinkVariants(link.ID, []models.LinkVariantRequest{
+		{Label: "control", DestinationURL: "https://example.com/landing-a", Weight: 50},
+		{Label: "challenger", DestinationURL: "https://example.com/landing-b", Weight: 50},
+	})
+	if err != nil {
+		t.Fatalf("Failed to set variants: %v", err)
+	}
+	retrieved, err := db.GetLinkByID(link.ID, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to get link: %v", err)
+	}
+	if _, err := db.RecordLinkVersion(retrieved, models.LinkVersionCreate, nil, user.ID, nil); err != nil {
+		t.Fatalf("Failed to record version: %v", err)
+	}
+	for _, variant := range original {
+		if err := db.CreateClick(&models.Click{LinkID: link.ID, VariantID: &variant.ID}); err != nil {
+			t.Fatalf("Failed to create click: %v", err)
+		}
+	}
+
+	// Resending a variant unchanged, or by ID with new settings, keeps its ID
+	updated, err := db.SetLinkVariants(link.ID, []models.LinkVariantRequest{
+		{ID: original[1].ID, Label: "challenger", DestinationURL: "https://example.com/landing-c", Weight: 80},
+		{Label: "control", DestinationURL: "https://example.com/landing-a", Weight: 20},
+		{Label: "newcomer", DestinationURL: "https://example.com/landing-d", Weight: 10},
+	})
+	if err != nil {
+		t.Fatalf("Failed to update variants: %v", err)
+	}
+	if updated[0].ID != original[1].ID || updated[0].Weight != 80 || updated[1].ID != original[0].ID {
+		t.Errorf("Expected existing variants to keep their IDs, got %+v", updated)
+	}
+	if updated[2].ID == original[0].ID || updated[2].ID == original[1].ID {
+		t.Errorf("Expected a new ID for the new variant, got %s", updated[2].ID)
+	}
+
+	current, err := db.GetLinkVariantsByLinkID(link.ID)
+	if err != nil || len(current) != 3 || current[0].ID != original[1].ID || current[0].DestinationURL != "https://example.com/landing-c" {
+		t.Errorf("Expected variants updated in place, got %+v (%v)", current, err)
+	}
+
+	// Clicks on removed variants still count in the stats
+	if _, err := db.SetLinkVariants(link.ID, []models.LinkVariantRequest{
+		{Label: "control", DestinationURL: "https://example.com/landing-a", Weight: 20},
+	}); err != nil {
+		t.Fatalf("Failed to remove variants: %v", err)
+	}
+	stats, err := db.GetLinkVariantStats(link.ID, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to get variant stats: %v", err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("Expected stats for the current and the removed variant, got %+v", stats)
+	}
+	if stats[0].VariantID != original[0].ID || stats[0].Clicks != 1 || stats[0].Removed {
+		t.Errorf("Expected the kept variant's click, got %+v", stats[0])
+	}
+	removed := stats[1]
+	if removed.VariantID != original[1].ID || removed.Clicks != 1 || !removed.Removed ||
+		removed.Label != "challenger" || removed.DestinationURL != "https://example.com/landing-b" || removed.Weight != 50 {
+		t.Errorf("Expected the removed variant described by history, got %+v", removed)
+	}
+}
+
+func TestLinkQueryParamSettings(t *testing.T) {
+	db := setupTestDB(t)
+	user := createTestUser(t, db, "utmuser", "utm@example.com")
+
+	link := createTestLink(t, db, &models.Link{
+		UserID:       user.ID,
+		OriginalURL:  "https://example.com/pricing",
+		ForwardQuery: true,
+		UTMSource:    "newsletter",
+		UTMCampaign:  "spring",
+	})
+
+	// Omitted fields are left alone; an empty string clears a field
+	medium := "email"
+	campaign := ""
+	err := db.UpdateLink(link.ID, user.ID, &models.UpdateLinkRequest{
+		UTMMedium:   &medium,
+		UTMCampaign: &campaign,
+	})
+	if err != nil {
+		t.Fatalf("Failed to update link: %v", err)
+	}
+
+	updated, err := db.GetLinkByID(link.ID, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to retrieve link: %v", err)
+	}
+	if !updated.ForwardQuery {
+		t.Error("Expected forward_query to stay enabled")
+	}
+	if updated.UTMSource != "newsletter" || updated.UTMMedium != "email" || updated.UTMCampaign != "" {
+		t.Errorf("Unexpected UTM fields after update: source=%q medium=%q campaign=%q",
+			updated.UTMSource, updated.UTMMedium, updated.UTMCampaign)
+	}
+}
+
+func TestLinkForwardPathSetting(t *testing.T) {
+	db := setupTestDB(t)
+	user := createTestUser(t, db, "pathuser", "path@example.com")
+
+	link := createTestLink(t, db, &models.Link{
+		UserID:      user.ID,
+		OriginalURL: "https://docs.example.com/{path}",
+		ForwardPath: true,
+	})
+	if err := db.CreateShortCode(link.ID, "docs", true); err != nil {
+		t.Fatalf("Failed to create short code: %v", err)
+	}
+
+	resolved, err := db.GetLinkByShortCode("docs", nil)
+	if err != nil {
+		t.Fatalf("Failed to resolve short code: %v", err)
+	}
+	if !resolved.ForwardPath {
+		t.Error("Expected forward_path to be enabled")
+	}
+
+	disabled := false
+	if err := db.UpdateLink(link.ID, user.ID, &models.UpdateLinkRequest{ForwardPath: &disabled}); err != nil {
+		t.Fatalf("Failed to update link: %v", err)
+	}
+	updated, err := db.GetLinkByID(link.ID, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to retrieve link: %v", err)
+	}
+	if updated.ForwardPath {
+		t.Error("Expected forward_path to be disabled after update")
+	}
+}
+
+func TestLinkShortCodeAliases(t *testing.T) {
+	db := setupTestDB(t)
+	user := createTestUser(t, db, "aliasuser", "alias@example.com")
+
+	link := createTestLink(t, db, &models.Link{
+		UserID:      user.ID,
+		OriginalURL: "https://example.com/alias",
+	})
+	if err := db.CreateShortCode(link.ID, "first", true); err != nil {
+		t.Fatalf("Failed to create short code: %v", err)
+	}
+
+	if err := db.DeleteLinkShortCode(link.ID, "first"); err != database.ErrLastShortCode {
+		t.Fatalf("Expected ErrLastShortCode when deleting the only short code, got %v", err)
+	}
+
+	if err := db.CreateShortCode(link.ID, "second", false); err != nil {
+		t.Fatalf("Failed to create short code: %v", err)
+	}
+	if err := db.SetPrimaryLinkShortCode(link.ID, "missing"); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows for an unknown short code, got %v", err)
+	}
+	if err := db.SetPrimaryLinkShortCode(link.ID, "second"); err != nil {
+		t.Fatalf("Failed to set primary short code: %v", err)
+	}
+
+	primaryOf := func() string {
+		shortCodes, err := db.GetShortCodesByLinkID(link.ID)
+		if err != nil {
+			t.Fatalf("Failed to get short codes: %v", err)
+		}
+		primary := ""
+		for _, sc := range shortCodes {
+			if sc.IsPrimary {
+				if primary != "" {
+					t.Fatalf("Expected a single primary short code, found %s and %s", primary, sc.ShortCode)
+				}
+				primary = sc.ShortCode
+			}
+		}
+		return primary
+	}
+
+	if primary := primaryOf(); primary != "second" {
+		t.Errorf("Expected primary short code 'second', got '%s'", primary)
+	}
+
+	// Deleting the primary promotes the remaining short code
+	if err := db.DeleteLinkShortCode(link.ID, "second"); err != nil {
+		t.Fatalf("Failed to delete short code: %v", err)
+	}
+	if primary := primaryOf(); primary != "first" {
+		t.Errorf("Expected 'first' to be promoted to primary, got '%s'", primary)
+	}
+
+	if err := db.DeleteLinkShortCode(link.ID, "second"); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows when deleting a removed short code, got %v", err)
+	}
+}
+
+func TestLinkRedirectType(t *testing.T) {
+	db := setupTestDB(t)
+	user := createTestUser(t, db, "redirectuser", "redirect@example.com")
+
+	link := createTestLink(t, db, &models.Link{
+		UserID:      user.ID,
+		OriginalURL: "https://example.com/vanity",
+	})
+
+	retrieved, err := db.GetLinkByID(link.ID, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to retrieve link: %v", err)
+	}
+	if retrieved.RedirectType != 302 {
+		t.Errorf("Expected default redirect_type 302, got %d", retrieved.RedirectType)
+	}
+
+	permanent := 308
+	if err := db.UpdateLink(link.ID, user.ID, &models.UpdateLinkRequest{RedirectType: &permanent}); err != nil {
+		t.Fatalf("Failed to update link: %v", err)
+	}
+
+	retrieved, err = db.GetLinkByID(link.ID, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to retrieve link: %v", err)
+	}
+	if retrieved.RedirectType != permanent {
+		t.Errorf("Expected redirect_type %d after update, got %d", permanent, retrieved.RedirectType)
+	}
+
+	// Omitting redirect_type leaves it alone
+	if err := db.UpdateLink(link.ID, user.ID, &models.UpdateLinkRequest{}); err != nil {
+		t.Fatalf("Failed to update link: %v", err)
+	}
+	retrieved, err = db.GetLinkByID(link.ID, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to retrieve link: %v", err)
+	}
+	if retrieved.RedirectType != permanent {
+		t.Errorf("Expected redirect_type to stay %d, got %d", permanent, retrieved.RedirectType)
+	}
+}
+
+func TestLinkFallbackURL(t *testing.T) {
+	db := setupTestDB(t)
+	user := createTestUser(t, db, "fallbackuser", "fallback@example.com")
+
+	link := createTestLink(t, db, &models.Link{
+		UserID:      user.ID,
+		OriginalURL: "https://example.com/launch",
+		FallbackURL: "https://example.com/launch-over",
+	})
+	if _, err := db.RecordLinkVersion(link, models.LinkVersionCreate, nil, user.ID, nil); err != nil {
+		t.Fatalf("Failed to record version: %v", err)
+	}
+
+	// Omitting fallback_url leaves it alone
+	if err := db.UpdateLink(link.ID, user.ID, &models.UpdateLinkRequest{}); err != nil {
+		t.Fatalf("Failed to update link: %v", err)
+	}
+	retrieved, err := db.GetLinkByID(link.ID, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to retrieve link: %v", err)
+	}
+	if retrieved.FallbackURL != link.FallbackURL {
+		t.Errorf("Expected fallback_url %q, got %q", link.FallbackURL, retrieved.FallbackURL)
+	}
+
+	// An empty string clears it, and the change is kept in the history
+	cleared := ""
+	if err := db.UpdateLink(link.ID, user.ID, &models.UpdateLinkRequest{FallbackURL: &cleared}); err != nil {
+		t.Fatalf("Failed to update link: %v", err)
+	}
+	retrieved, err = db.GetLinkByID(link.ID, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to retrieve link: %v", err)
+	}
+	if retrieved.FallbackURL != "" {
+		t.Errorf("Expected fallback_url to be cleared, got %q", retrieved.FallbackURL)
+	}
+
+	version, err := db.RecordLinkVersion(retrieved, models.LinkVersionUpdate, nil, user.ID, nil)
+	if err != nil {
+		t.Fatalf("Failed to record version: %v", err)
+	}
+	if version == nil || len(version.ChangedFields) != 1 || version.ChangedFields[0] != "fallback_url" {
+		t.Errorf("Expected only fallback_url to change, got %v", version)
+	}
+
+	first, err := db.GetLinkVersion(link.ID, user.ID, 1)
+	if err != nil {
+		t.Fatalf("Failed to get version: %v", err)
+	}
+	if err := db.RestoreLinkVersion(link.ID, user.ID, first); err != nil {
+		t.Fatalf("Failed to restore version: %v", err)
+	}
+	retrieved, err = db.GetLinkByID(link.ID, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to retrieve link: %v", err)
+	}
+	if retrieved.FallbackURL != link.FallbackURL {
+		t.Errorf("Expected rollback to restore fallback_url, got %q", retrieved.FallbackURL)
+	}
+}
+
+func TestLinkListingFilters(t *testing.T) {
+	db := setupTestDB(t)
+	user := createTestUser(t, db, "listuser", "list@example.com")
+
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	one := 1
+
+	docs := createTestLink(t, db, &models.Link{UserID: user.ID, OriginalURL: "https://docs.example.com/guide", Title: "Setup guide"})
+	sale := createTestLink(t, db, &models.Link{UserID: user.ID, OriginalURL: "https://shop.example.com/sale", Description: "100% off"})
+	expired := createTestLink(t, db, &models.Link{UserID: user.ID, OriginalURL: "https://old.example.com", ExpiresAt: &past})
+	scheduled := createTestLink(t, db, &models.Link{UserID: user.ID, OriginalURL: "https://new.example.com", StartsAt: &future})
+	usedUp := createTestLink(t, db, &models.Link{UserID: user.ID, OriginalURL: "https://once.example.com", MaxClicks: &one})
+
+	if _, err := db.IncrementLinkClicks(usedUp.ID); err != nil {
+		t.Fatalf("Failed to record click: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := db.IncrementLinkClicks(sale.ID); err != nil {
+			t.Fatalf("Failed to record click: %v", err)
+		}
+	}
+
+	if err := db.SetLinkTags(docs.ID, []string{"Docs", " internal ", "docs", ""}); err != nil {
+		t.Fatalf("Failed to set tags: %v", err)
+	}
+	if err := db.SetLinkTags(sale.ID, []string{"marketing", "internal"}); err != nil {
+		t.Fatalf("Failed to set tags: %v", err)
+	}
+
+	retrieved, err := db.GetLinkByID(docs.ID, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to retrieve link: %v", err)
+	}
+	if len(retrieved.Tags) != 2 || retrieved.Tags[0] != "Docs" || retrieved.Tags[1] != "internal" {
+		t.Errorf("Expected tags [Docs internal], got %v", retrieved.Tags)
+	}
+
+	list := func(opts models.ListOptions) []string {
+		if opts.Limit == 0 {
+			opts.Limit = 20
+		}
+		links, err := db.GetUserLinks(user.ID, &opts)
+		if err != nil {
+			t.Fatalf("Failed to list links: %v", err)
+		}
+		ids := make([]string, len(links))
+		for i, link := range links {
+			ids[i] = link.ID
+		}
+		return ids
+	}
+
+	tests := []struct {
+		name     string
+		opts     models.ListOptions
+		expected []string
+	}{
+		{"tag ignores case", models.ListOptions{Tags: []string{"DOCS"}}, []string{docs.ID}},
+		{"every tag must match", models.ListOptions{Tags: []string{"internal", "marketing"}}, []string{sale.ID}},
+		{"search title", models.ListOptions{Search: "GUIDE"}, []string{docs.ID}},
+		{"search url", models.ListOptions{Search: "shop.example"}, []string{sale.ID}},
+		{"search escapes wildcards", models.ListOptions{Search: "100%"}, []string{sale.ID}},
+		{"expired includes used up", models.ListOptions{Expiry: models.ExpiryExpired, Sort: models.SortCreated}, []string{expired.ID, usedUp.ID}},
+		{"scheduled", models.ListOptions{Expiry: models.ExpiryScheduled}, []string{scheduled.ID}},
+		{"active", models.ListOptions{Expiry: models.ExpiryActive}, []string{docs.ID, sale.ID}},
+		{"most clicked first", models.ListOptions{Sort: models.SortClicks, Descending: true, Limit: 2}, []string{sale.ID, usedUp.ID}},
+		{"created after", models.ListOptions{CreatedAfter: &future}, []string{}},
+	}
+
+	for _, tt := range tests {
+		ids := list(tt.opts)
+		if len(ids) != len(tt.expected) {
+			t.Errorf("%s: expected %d links, got %d", tt.name, len(tt.expected), len(ids))
+			continue
+		}
+		for i := range ids {
+			if ids[i] != tt.expected[i] {
+				t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, ids)
+				break
+			}
+		}
+	}
+
+	// Clearing the tags drops the link from tag filters
+	if err := db.SetLinkTags(docs.ID, []string{}); err != nil {
+		t.Fatalf("Failed to clear tags: %v", err)
+	}
+	if ids := list(models.ListOptions{Tags: []string{"docs"}}); len(ids) != 0 {
+		t.Errorf("Expected no links tagged docs after clearing, got %v", ids)
+	}
+
+	if _, err := db.GetUserLinks(user.ID, &models.ListOptions{Sort: models.SortDownloads, Limit: 20}); err == nil {
+		t.Error("Expected links to reject sorting by downloads")
+	}
+}
+
+func TestTimeFiltersAcrossZones(t *testing.T) {
+	db := setupTestDB(t)
+	user := createTestUser(t, db, "zoneuser", "zone@example.com")
+
+	// Stored as text, these would sort after now in the +05:00 zone and
+	// before it in the -05:00 zone
+	east := time.FixedZone("east", 5*60*60)
+	west := time.FixedZone("west", -5*60*60)
+	expiredAt := time.Now().Add(-30 * time.Minute).In(east)
+	startsAt := time.Now().Add(30 * time.Minute).In(west)
+	expired := createTestLink(t, db, &models.Link{UserID: user.ID, OriginalURL: "https://example.com/old", ExpiresAt: &expiredAt})
+	scheduled := createTestLink(t, db, &models.Link{UserID: user.ID, OriginalURL: "https://example.com/soon", StartsAt: &startsAt})
+
+	list := func(expiry string) []models.Link {
+		links, err := db.GetUserLinks(user.ID, &models.ListOptions{Expiry: expiry, Limit: 20})
+		if err != nil {
+			t.Fatalf("Failed to list links: %v", err)
+		}
+		return links
+	}
+	if links := list(models.ExpiryExpired); len(links) != 1 || links[0].ID != expired.ID {
+		t.Errorf("Expected only the expired link, got %+v", links)
+	}
+	if links := list(models.ExpiryScheduled); len(links) != 1 || links[0].ID != scheduled.ID {
+		t.Errorf("Expected only the scheduled link, got %+v", links)
+	}
+	if links := list(models.ExpiryActive); len(links) != 0 {
+		t.Errorf("Expected no active links, got %+v", links)
+	}
+
+	// A check made two hours ago is due again after an hour, whatever the
+	// zone it was recorded in
+	checkedAt := time.Now().Add(-2 * time.Hour).In(east)
+	status := http.StatusOK
+	if err := db.UpdateLinkHealth(scheduled.ID, scheduled.OriginalURL, &status, nil, time.Millisecond, checkedAt); err != nil {
+		t.Fatalf("Failed to record health: %v", err)
+	}
+	due, err := db.GetLinksDueForHealthCheck(time.Now().Add(-time.Hour).In(west), 10)
+	if err != nil {
+		t.Fatalf("Failed to get links due for a check: %v", err)
+	}
+	if len(due) != 1 || due[0].LinkID != scheduled.ID {
+		t.Errorf("Expected the scheduled link to be due, got %+v", due)
+	}
+}
+
+func TestLinkUpdateIsAtomic(t *testing.T) {
+	db := setupTestDB(t)
+	cfg := testServerConfig()
+	server := newTestServer(t, db, cfg)
+	user := createTestUser(t, db, "atomicuser", "atomic@example.com")
+	token := testToken(t, cfg, user)
+
+	w := doRequest(t, server, http.MethodPost, "/api/v1/links", token, map[string]interface{}{
+		"original_url":   "https://example.com/menu",
+		"redirect_rules": []map[string]string{{"platform": "ios", "destination_url": "https://example.com/ios"}},
+		"tags":           []string{"print"},
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var created models.Link
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("Failed to decode link: %v", err)
+	}
+
+	// Make the last step, recording the version, fail
+	if _, err := db.Exec(`
+		CREATE TRIGGER fail_link_versions BEFORE INSERT ON link_versions
+		BEGIN SELECT RAISE(ABORT, 'history unavailable'); END`); err != nil {
+		t.Fatalf("Failed to create trigger: %v", err)
+	}
+
+	check := func(step string) {
+		t.Helper()
+		link, err := db.GetLinkByID(created.ID, user.ID)
+		if err != nil {
+			t.Fatalf("Failed to get link: %v", err)
+		}
+		if link.OriginalURL != "https://example.com/menu" || link.Version != 1 ||
+			len(link.RedirectRules) != 1 || link.RedirectRules[0].Platform != "ios" ||
+			len(link.Tags) != 1 || link.Tags[0] != "print" {
+			t.Errorf("Expected the failed %s to leave the link unchanged, got %+v", step, link)
+		}
+	}
+
+	w = doRequest(t, server, http.MethodPut, "/api/v1/links/"+created.ID, token, map[string]interface{}{
+		"original_url":   "https://example.com/wrong",
+		"redirect_rules": []map[string]string{},
+		"tags":           []string{"draft"},
+	})
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500 when history can't be recorded, got %d", w.Code)
+	}
+	check("update")
+
+	// A rollback that fails part way is undone as well
+	if _, err := db.Exec(`DROP TRIGGER fail_link_versions`); err != nil {
+		t.Fatalf("Failed to drop trigger: %v", err)
+	}
+	w = doRequest(t, server, http.MethodPut, "/api/v1/links/"+created.ID, token, map[string]interface{}{
+		"original_url": "https://example.com/new",
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, err := db.Exec(`
+		CREATE TRIGGER fail_link_versions BEFORE INSERT ON link_versions
+		BEGIN SELECT RAISE(ABORT, 'history unavailable'); END`); err != nil {
+		t.Fatalf("Failed to create trigger: %v", err)
+	}
+	w = doRequest(t, server, http.MethodPost, "/api/v1/links/"+created.ID+"/rollback", token, map[string]int{"version": 1})
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500 when history can't be recorded, got %d", w.Code)
+	}
+	link, err := db.GetLinkByID(created.ID, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to get link: %v", err)
+	}
+	if link.OriginalURL != "https://example.com/new" || link.Version != 2 {
+		t.Errorf("Expected the failed rollback to leave version 2 live, got %s at version %d", link.OriginalURL, link.Version)
+	}
+}
+
+func TestLinkHistoryWithoutIDs(t *testing.T) {
+	db := setupTestDB(t)
+	user := createTestUser(t, db, "historyidsuser", "historyids@example.com")
+
+	link := createTestLink(t, db, &models.Link{UserID: user.ID, OriginalURL: "https://example.com/landing"})
+	if _, err := db.SetLinkRedirectRules(link.ID, []models.RedirectRuleRequest{
+		{Platform: "ios", DestinationURL: "https://apps.apple.com/app/example"},
+	}); err != nil {
+		t.Fatalf("Failed to set redirect rules: %v", err)
+	}
+	if _, err := db.SetLinkVariants(link.ID, []models.LinkVariantRequest{
+		{Label: "control", DestinationURL: "https://example.com/landing-a", Weight: 50},
+		{Label: "challenger", DestinationURL: "https://example.com/landing-b", Weight: 50},
+	}); err != nil {
+		t.Fatalf("Failed to set variants: %v", err)
+	}
+
+	// Versions backfilled before rule and variant IDs were kept have none
+	initial, err := db.GetLinkByID(link.ID, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to get link: %v", err)
+	}
+	for i := range initial.RedirectRules {
+		initial.RedirectRules[i].ID = ""
+	}
+	for i := range initial.Variants {
+		initial.Variants[i].ID = ""
+	}
+	if _, err := db.RecordLinkVersion(initial, models.LinkVersionInitial, nil, user.ID, nil); err != nil {
+		t.Fatalf("Failed to record version: %v", err)
+	}
+
+	// The first update that changes nothing must not record a version
+	retrieved, err := db.GetLinkByID(link.ID, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to get link: %v", err)
+	}
+	version, err := db.RecordLinkVersion(retrieved, models.LinkVersionUpdate, nil, user.ID, nil)
+	if err != nil {
+		t.Fatalf("Failed to record version: %v", err)
+	}
+	if version != nil {
+		t.Errorf("Expected no version for an unchanged link, got %+v", version)
+	}
+}
+
+func TestLinkHistory(t *testing.T) {
+	db := setupTestDB(t)
+	user := createTestUser(t, db, "historyuser", "history@example.com")
+
+	load := func(id string) *models.Link {
+		link, err := db.GetLinkByID(id, user.ID)
+		if err != nil {
+			t.Fatalf("Failed to retrieve link: %v", err)
+		}
+		return link
+	}
+	record := func(id, action string, restored *int, tokenID *string) *models.LinkVersion {
+		version, err := db.RecordLinkVersion(load(id), action, restored, user.ID, tokenID)
+		if err != nil {
+			t.Fatalf("Failed to record version: %v", err)
+		}
+		return version
+	}
+	click := func(link *models.Link) {
+		if err := db.CreateClick(&models.Click{LinkID: link.ID, LinkVersion: &link.Version}); err != nil {
+			t.Fatalf("Failed to record click: %v", err)
+		}
+	}
+
+	link := createTestLink(t, db, &models.Link{UserID: user.ID, OriginalURL: "https://example.com/menu", Title: "Menu"})
+	if v := record(link.ID, models.LinkVersionCreate, nil, nil); v.Version != 1 {
+		t.Fatalf("Expected version 1 on create, got %d", v.Version)
+	}
+	click(load(link.ID))
+
+	// Repointing the QR code by mistake
+	tokenID := "token-1"
+	wrong, title := "https://example.com/wrong", "Menu"
+	if err := db.UpdateLink(link.ID, user.ID, &models.UpdateLinkRequest{OriginalURL: &wrong, Title: &title}); err != nil {
+		t.Fatalf("Failed to update link: %v", err)
+	}
+	if err := db.SetLinkTags(link.ID, []string{"print"}); err != nil {
+		t.Fatalf("Failed to set tags: %v", err)
+	}
+	v2 := record(link.ID, models.LinkVersionUpdate, nil, &tokenID)
+	if v2 == nil || v2.Version != 2 {
+		t.Fatalf("Expected version 2 after update, got %+v", v2)
+	}
+	if len(v2.ChangedFields) != 2 || v2.ChangedFields[0] != "original_url" || v2.ChangedFields[1] != "tags" {
+		t.Errorf("Expected original_url and tags to change, got %v", v2.ChangedFields)
+	}
+	click(load(link.ID))
+	click(load(link.ID))
+
+	// Saving the same settings again is not a new version
+	if v := record(link.ID, models.LinkVersionUpdate, nil, nil); v != nil {
+		t.Errorf("Expected no version for an unchanged update, got %d", v.Version)
+	}
+
+	original, err := db.GetLinkVersion(link.ID, user.ID, 1)
+	if err != nil {
+		t.Fatalf("Failed to get version: %v", err)
+	}
+	if err := db.RestoreLinkVersion(link.ID, user.ID, original); err != nil {
+		t.Fatalf("Failed to restore version: %v", err)
+	}
+	restored := 1
+	v3 := record(link.ID, models.LinkVersionRollback, &restored, nil)
+	if v3.Version != 3 || v3.RestoredVersion == nil || *v3.RestoredVersion != 1 {
+		t.Errorf("Expected version 3 restoring version 1, got %+v", v3)
+	}
+
+	current := load(link.ID)
+	if current.OriginalURL != "https://example.com/menu" || len(current.Tags) != 0 || current.Version != 3 {
+		t.Errorf("Expected the original settings back at version 3, got %s %v v%d", current.OriginalURL, current.Tags, current.Version)
+	}
+	click(current)
+
+	history, err := db.GetLinkHistory(link.ID, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	if len(history) != 3 || history[0].Version != 3 || history[2].Action != models.LinkVersionCreate {
+		t.Fatalf("Expected 3 versions newest first, got %d", len(history))
+	}
+	if history[1].OriginalURL != "https://example.com/wrong" || history[1].APITokenID == nil || *history[1].APITokenID != tokenID {
+		t.Errorf("Expected version 2 to keep the mistaken URL and acting token, got %+v", history[1])
+	}
+	if history[1].UserID == nil || *history[1].UserID != user.ID {
+		t.Error("Expected version 2 to record the acting user")
+	}
+
+	stats, err := db.GetLinkVersionStats(link.ID, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to get version stats: %v", err)
+	}
+	expected := []int{1, 2, 1}
+	if len(stats) != len(expected) {
+		t.Fatalf("Expected stats for %d versions, got %d", len(expected), len(stats))
+	}
+	for i, clicks := range expected {
+		if stats[i].Clicks != clicks {
+			t.Errorf("Expected %d clicks on version %d, got %d", clicks, stats[i].Version, stats[i].Clicks)
+		}
+	}
+
+	if _, err := db.GetLinkVersion(link.ID, user.ID, 9); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows for a missing version, got %v", err)
+	}
+}
+
+func TestLinkPasswordUnlock(t *testing.T) {
+	db := setupTestDB(t)
+	cfg := testServerConfig()
+	server := newTestServer(t, db, cfg)
+	user := createTestUser(t, db, "unlockuser", "unlock@example.com")
+	token := testToken(t, cfg, user)
+
+	hashed, err := auth.HashPassword("first-secret")
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	link := createTestLink(t, db, &models.Link{UserID: user.ID, OriginalURL: "https://example.com/private", Password: &hashed})
+	if err := db.CreateShortCode(link.ID, "locked", true); err != nil {
+		t.Fatalf("Failed to create short code: %v", err)
+	}
+
+	unlock := func(password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/s/locked", strings.NewReader(url.Values{"password": {password}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+	visit := func(cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/s/locked", nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := visit(nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without the password, got %d", w.Code)
+	}
+	if w := unlock("wrong-secret"); w.Code != http.StatusUnauthorized || len(w.Result().Cookies()) != 0 {
+		t.Errorf("Expected 401 and no cookie for a wrong password, got %d", w.Code)
+	}
+
+	w := unlock("first-secret")
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("Expected 303 for the right password, got %d", w.Code)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly {
+		t.Fatalf("Expected an HttpOnly unlock cookie, got %v", cookies)
+	}
+	if w := visit(cookies); w.Code != http.StatusFound || w.Header().Get("Location") != "https://example.com/private" {
+		t.Errorf("Expected the cookie to unlock the link, got %d", w.Code)
+	}
+
+	// Changing the password locks out visitors holding the old cookie
+	path := "/api/v1/links/" + link.ID
+	if w := doRequest(t, server, http.MethodPut, path, token, gin.H{"analytics": false, "password": "second-secret"}); w.Code != http.StatusOK {
+		t.Fatalf("Failed to change password: %d %s", w.Code, w.Body.String())
+	}
+	if w := visit(cookies); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the old cookie to stop working after a password change, got %d", w.Code)
+	}
+
+	if w := doRequest(t, server, http.MethodPut, path, token, gin.H{"password": "third-secret", "clear": []string{"password"}}); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for setting and clearing the password at once, got %d", w.Code)
+	}
+	if w := doRequest(t, server, http.MethodPut, path, token, gin.H{"analytics": false, "clear": []string{"password"}}); w.Code != http.StatusOK {
+		t.Fatalf("Failed to clear password: %d %s", w.Code, w.Body.String())
+	}
+	if w := visit(nil); w.Code != http.StatusFound {
+		t.Errorf("Expected the link to open without a password once cleared, got %d", w.Code)
+	}
+
+	// Password guesses are rate limited; two attempts were made above
+	for i := 2; i < 10; i++ {
+		if w := unlock("wrong-secret"); w.Code == http.StatusTooManyRequests {
+			t.Fatalf("Expected attempt %d to be allowed", i+1)
+		}
+	}
+	if w := unlock("wrong-secret"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 after 10 attempts, got %d", w.Code)
+	}
+}
+
+func TestLinkForwardPathDocumentedExample(t *testing.T) {
+	db := setupTestDB(t)
+	cfg := testServerConfig()
+	server := newTestServer(t, db, cfg)
+	token := testToken(t, cfg, createTestUser(t, db, "docsuser", "docs@example.com"))
+
+	// The forward_path example from the README
+	w := doRequest(t, server, http.MethodPost, "/api/v1/links", token, gin.H{
+		"original_url": "https://docs.example.com/",
+		"short_codes":  []string{"docs"},
+		"forward_path": true,
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected the documented link to be created, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = doRequest(t, server, http.MethodGet, "/s/docs/api/v2/auth", "", nil)
+	if location := w.Header().Get("Location"); w.Code != http.StatusFound || location != "https://docs.example.com/api/v2/auth" {
+		t.Errorf("Expected a redirect to https://docs.example.com/api/v2/auth, got %d %s", w.Code, location)
+	}
+}
+
+func TestLinkForwardPathJoining(t *testing.T) {
+	db := setupTestDB(t)
+	cfg := testServerConfig()
+	server := newTestServer(t, db, cfg)
+	token := testToken(t, cfg, createTestUser(t, db, "joinuser", "join@example.com"))
+
+	links := []struct {
+		code        string
+		destination string
+		forwardPath bool
+	}{
+		{"append", "https://docs.example.com/guide/", true},
+		{"inpath", "https://git.example.com/{path}/issues", true},
+		{"inquery", "https://search.example.com/find?q={path}&lang=en", true},
+		{"plain", "https://example.com/plain", false},
+	}
+	for _, link := range links {
+		w := doRequest(t, server, http.MethodPost, "/api/v1/links", token, gin.H{
+			"original_url": link.destination,
+			"short_codes":  []string{link.code},
+			"forward_path": link.forwardPath,
+		})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Failed to create link %s: %d %s", link.code, w.Code, w.Body.String())
+		}
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		status   int
+		location string
+	}{
+		{"appended", "/s/append/api/v2", http.StatusFound, "https://docs.example.com/guide/api/v2"},
+		{"no extra path", "/s/append", http.StatusFound, "https://docs.example.com/guide/"},
+		{"dot segments cleaned", "/s/append/a/../../../etc/passwd", http.StatusFound, "https://docs.example.com/guide/etc/passwd"},
+		{"dot segments can't leave placeholder", "/s/inpath/../../admin", http.StatusFound, "https://git.example.com/admin/issues"},
+		{"placeholder in path", "/s/inpath/team/repo", http.StatusFound, "https://git.example.com/team/repo/issues"},
+		{"empty placeholder", "/s/inpath", http.StatusFound, "https://git.example.com/issues"},
+		{"encoded segments stay escaped", "/s/append/a%3Fb%23c/d%20e", http.StatusFound, "https://docs.example.com/guide/a%3Fb%23c/d%20e"},
+		{"encoded slash in placeholder", "/s/inpath/a%2F..%2F..%2Fx", http.StatusFound, "https://git.example.com/x/issues"},
+		{"placeholder in query", "/s/inquery/go%20modules/v2", http.StatusFound, "https://search.example.com/find?q=go+modules%2Fv2&lang=en"},
+		{"query placeholder can't add parameters", "/s/inquery/a%26admin%3D1", http.StatusFound, "https://search.example.com/find?q=a%26admin%3D1&lang=en"},
+		{"not opted in", "/s/plain/extra", http.StatusNotFound, ""},
+		{"not opted in without extra path", "/s/plain", http.StatusFound, "https://example.com/plain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doRequest(t, server, http.MethodGet, tt.path, "", nil)
+			if w.Code != tt.status {
+				t.Fatalf("Expected %d for %s, got %d", tt.status, tt.path, w.Code)
+			}
+			if location := w.Header().Get("Location"); location != tt.location {
+				t.Errorf("Expected %s to redirect to %q, got %q", tt.path, tt.location, location)
+			}
+		})
+	}
+}
+
+func TestActivationWindowAgainstStoredDates(t *testing.T) {
+	db := setupTestDB(t)
+	cfg := testServerConfig()
+	server := newTestServer(t, db, cfg)
+	user := createTestUser(t, db, "windowuser", "window@example.com")
+	token := testToken(t, cfg, user)
+
+	startsAt := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	expiresAt := startsAt.Add(24 * time.Hour)
+	link := createTestLink(t, db, &models.Link{UserID: user.ID, OriginalURL: "https://example.com/launch", StartsAt: &startsAt, ExpiresAt: &expiresAt})
+	file := &models.File{UserID: user.ID, Filename: "launch.pdf", MimeType: "application/pdf", S3Key: "launch", S3Bucket: "b", StartsAt: &startsAt, ExpiresAt: &expiresAt}
+	if err := db.CreateFile(file); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		body gin.H
+		code int
+	}{
+		{"starts_at after the stored expires_at", gin.H{"starts_at": expiresAt.Add(time.Hour)}, http.StatusBadRequest},
+		{"expires_at before the stored starts_at", gin.H{"expires_at": startsAt.Add(-time.Hour)}, http.StatusBadRequest},
+		{"starts_at after the cleared expires_at", gin.H{"starts_at": expiresAt.Add(time.Hour), "clear": []string{"expires_at"}}, http.StatusOK},
+		{"both moved together", gin.H{"starts_at": expiresAt.Add(time.Hour), "expires_at": expiresAt.Add(2 * time.Hour)}, http.StatusOK},
+	}
+
+	for _, resource := range []string{"links/" + link.ID, "files/" + file.ID} {
+		for _, tt := range tests {
+			t.Run(resource+" "+tt.name, func(t *testing.T) {
+				// Start each case from the original window
+				for _, table := range []string{"links", "files"} {
+					if _, err := db.Exec(`UPDATE `+table+` SET starts_at = ?, expires_at = ?`, startsAt, expiresAt); err != nil {
+						t.Fatalf("Failed to reset %s: %v", table, err)
+					}
+				}
+
+				w := doRequest(t, server, http.MethodPut, "/api/v1/"+resource, token, tt.body)
+				if w.Code != tt.code {
+					t.Errorf("Expected %d, got %d: %s", tt.code, w.Code, w.Body.String())
+				}
+			})
+		}
+	}
+}
+
+func TestLinkClearLimits(t *testing.T) {
+	db := setupTestDB(t)
+	cfg := testServerConfig()
+	server := newTestServer(t, db, cfg)
+	user := createTestUser(t, db, "clearuser", "clear@example.com")
+	token := testToken(t, cfg, user)
+
+	startsAt := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	expiresAt := startsAt.Add(24 * time.Hour)
+	w := doRequest(t, server, http.MethodPost, "/api/v1/links", token, gin.H{
+		"original_url": "https://example.com/limited",
+		"short_codes":  []string{"limited"},
+		"max_clicks":   1,
+		"starts_at":    startsAt,
+		"expires_at":   expiresAt,
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Failed to create link: %d %s", w.Code, w.Body.String())
+	}
+	var link models.Link
+	if err := json.Unmarshal(w.Body.Bytes(), &link); err != nil {
+		t.Fatalf("Failed to decode link: %v", err)
+	}
+
+	if w := doRequest(t, server, http.MethodGet, "/s/limited", "", nil); w.Code != http.StatusForbidden {
+		t.Fatalf("Expected a scheduled link to respond 403, got %d", w.Code)
+	}
+
+	// Setting and clearing the same field is ambiguous
+	w = doRequest(t, server, http.MethodPut, "/api/v1/links/"+link.ID, token, gin.H{
+		"max_clicks": 5,
+		"clear":      []string{"max_clicks"},
+	})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 when setting and clearing max_clicks, got %d", w.Code)
+	}
+	w = doRequest(t, server, http.MethodPut, "/api/v1/links/"+link.ID, token, gin.H{
+		"clear": []string{"title"},
+	})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 when clearing an unknown setting, got %d", w.Code)
+	}
+
+	// Leaving the fields out keeps them
+	w = doRequest(t, server, http.MethodPut, "/api/v1/links/"+link.ID, token, gin.H{"title": "Launch"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Failed to update link: %d %s", w.Code, w.Body.String())
+	}
+	retrieved, err := db.GetLinkByID(link.ID, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to retrieve link: %v", err)
+	}
+	if retrieved.MaxClicks == nil || retrieved.StartsAt == nil || retrieved.ExpiresAt == nil {
+		t.Fatalf("Expected an update without the fields to keep them, got %+v", retrieved)
+	}
+	if retrieved.OriginalURL != "https://example.com/limited" || retrieved.Title != "Launch" {
+		t.Errorf("Expected a title-only update to keep the destination, got %q %q", retrieved.OriginalURL, retrieved.Title)
+	}
+
+	w = doRequest(t, server, http.MethodPut, "/api/v1/links/"+link.ID, token, gin.H{
+		"clear": []string{"max_clicks", "starts_at", "expires_at"},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Failed to clear limits: %d %s", w.Code, w.Body.String())
+	}
+	retrieved, err = db.GetLinkByID(link.ID, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to retrieve link: %v", err)
+	}
+	if retrieved.MaxClicks != nil || retrieved.StartsAt != nil || retrieved.ExpiresAt != nil {
+		t.Errorf("Expected the limits to be cleared, got max_clicks=%v starts_at=%v expires_at=%v",
+			retrieved.MaxClicks, retrieved.StartsAt, retrieved.ExpiresAt)
+	}
+	if retrieved.OriginalURL != "https://example.com/limited" || retrieved.Title != "Launch" {
+		t.Errorf("Expected clearing limits to keep the destination, got %q %q", retrieved.OriginalURL, retrieved.Title)
+	}
+
+	for i := 0; i < 2; i++ {
+		w := doRequest(t, server, http.MethodGet, "/s/limited", "", nil)
+		if location := w.Header().Get("Location"); w.Code != http.StatusFound || location != "https://example.com/limited" {
+			t.Fatalf("Expected the unlimited link to redirect to https://example.com/limited, got %d %q", w.Code, location)
+		}
+	}
+
+	file := &models.File{
+		UserID:    user.ID,
+		Filename:  "launch.pdf",
+		MimeType:  "application/pdf",
+		S3Key:     "clear/launch.pdf",
+		S3Bucket:  "test-bucket",
+		Title:     "Launch deck",
+		IsPublic:  true,
+		Analytics: true,
+		StartsAt:  &startsAt,
+		ExpiresAt: &expiresAt,
+	}
+	if err := db.CreateFile(file); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	err = db.UpdateFile(file.ID, user.ID, &models.UpdateFileRequest{Clear: []string{models.ClearStartsAt, models.ClearExpiresAt}})
+	if err != nil {
+		t.Fatalf("Failed to update file: %v", err)
+	}
+	retrievedFile, err := db.GetFileByID(file.ID, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to retrieve file: %v", err)
+	}
+	if retrievedFile.StartsAt != nil || retrievedFile.ExpiresAt != nil {
+		t.Errorf("Expected the file's window to be cleared, got %v to %v", retrievedFile.StartsAt, retrievedFile.ExpiresAt)
+	}
+	if retrievedFile.Title != "Launch deck" || !retrievedFile.IsPublic || !retrievedFile.Analytics {
+		t.Errorf("Expected clearing the window to keep the file's other settings, got %+v", retrievedFile)
+	}
+}
+
+func TestLinkCountryHeadersNeedTrustedProxy(t *testing.T) {
+	db := setupTestDB(t)
+	user := createTestUser(t, db, "countryuser", "country@example.com")
+
+	link := createTestLink(t, db, &models.Link{UserID: user.ID, OriginalURL: "https://example.com/"})
+	if err := db.CreateShortCode(link.ID, "intl", true); err != nil {
+		t.Fatalf("Failed to create short code: %v", err)
+	}
+	_, err := db.SetLinkRedirectRules(link.ID, []models.RedirectRuleRequest{
+		{Country: "DE", DestinationURL: "https://example.com/de"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to set redirect rules: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		trustedProxies []string
+		remoteAddr     string
+		location       string
+	}{
+		{"no trusted proxies", nil, "203.0.113.7:4000", "https://example.com/"},
+		{"untrusted client", []string{"10.0.0.0/8"}, "203.0.113.7:4000", "https://example.com/"},
+		{"trusted proxy range", []string{"10.0.0.0/8"}, "10.1.2.3:4000", "https://example.com/de"},
+		{"trusted proxy address", []string{"203.0.113.7"}, "203.0.113.7:4000", "https://example.com/de"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testServerConfig()
+			cfg.TrustedProxies = tt.trustedProxies
+			server := newTestServer(t, db, cfg)
+
+			req := httptest.NewRequest(http.MethodGet, "/s/intl", nil)
+			req.Host = "localhost:8080"
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("CF-IPCountry", "DE")
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, req)
+
+			if location := w.Header().Get("Location"); location != tt.location {
+				t.Errorf("Expected a redirect to %s, got %d %s", tt.location, w.Code, location)
+			}
+		})
+	}
+}
+
+func TestLinkPartialUpdateKeepsFields(t *testing.T) {
+	db := setupTestDB(t)
+	cfg := testServerConfig()
+	server := newTestServer(t, db, cfg)
+	user := createTestUser(t, db, "partialuser", "partial@example.com")
+	token := testToken(t, cfg, user)
+
+	w := doRequest(t, server, http.MethodPost, "/api/v1/links", token, gin.H{
+		"original_url": "https://example.com/menu",
+		"title":        "Menu",
+		"description":  "Printed on every table",
+		"analytics":    true,
+		"short_codes":  []string{"menu"},
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Failed to create link: %d %s", w.Code, w.Body.String())
+	}
+	var link models.Link
+	if err := json.Unmarshal(w.Body.Bytes(), &link); err != nil {
+		t.Fatalf("Failed to decode link: %v", err)
+	}
+	if _, err := db.Exec(`UPDATE links SET health_checked_at = ?, health_status_code = 200 WHERE id = ?`, time.Now(), link.ID); err != nil {
+		t.Fatalf("Failed to record health: %v", err)
+	}
+
+	updates := []gin.H{
+		{"tags": []string{"print"}},
+		{"clear": []string{"password"}},
+		{"forward_query": true},
+	}
+	for _, update := range updates {
+		w := doRequest(t, server, http.MethodPut, "/api/v1/links/"+link.ID, token, update)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Failed to update link with %v: %d %s", update, w.Code, w.Body.String())
+		}
+
+		retrieved, err := db.GetLinkByID(link.ID, user.ID)
+		if err != nil {
+			t.Fatalf("Failed to retrieve link: %v", err)
+		}
+		if retrieved.OriginalURL != "https://example.com/menu" || retrieved.Title != "Menu" ||
+			retrieved.Description != "Printed on every table" || !retrieved.Analytics {
+			t.Errorf("Expected %v to leave the other fields alone, got %+v", update, retrieved)
+		}
+		if retrieved.HealthCheckedAt == nil {
+			t.Errorf("Expected %v to keep the health check result", update)
+		}
+
+		w = doRequest(t, server, http.MethodGet, "/s/menu", "", nil)
+		if location := w.Header().Get("Location"); location != "https://example.com/menu" {
+			t.Errorf("Expected the link to keep redirecting to https://example.com/menu after %v, got %d %s", update, w.Code, location)
+		}
+	}
+
+	history, err := db.GetLinkHistory(link.ID, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	for _, version := range history {
+		for _, field := range version.ChangedFields {
+			if field == "original_url" && version.Action != models.LinkVersionCreate {
+				t.Errorf("Expected no version to record a destination change, got %+v", version)
+			}
+		}
+	}
+
+	w = doRequest(t, server, http.MethodPut, "/api/v1/links/"+link.ID, token, gin.H{"original_url": ""})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected an empty original_url to be rejected, got %d", w.Code)
+	}
+}
+
+func TestFilePasswordUnlock(t *testing.T) {
+	db := setupTestDB(t)
+	cfg := testServerConfig()
+	server := newTestServer(t, db, cfg)
+	user := createTestUser(t, db, "fileunlockuser", "fileunlock@example.com")
+
+	hashed, err := auth.HashPassword("first-secret")
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	file := &models.File{UserID: user.ID, Filename: "plan.pdf", MimeType: "application/pdf", S3Key: "plan", S3Bucket: "b", Password: &hashed}
+	if err := db.CreateFile(file); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if err := db.CreateFileShortCode(file.ID, "plan", true); err != nil {
+		t.Fatalf("Failed to create file short code: %v", err)
+	}
+
+	unlock := func(password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/f/plan?info=true", strings.NewReader(url.Values{"password": {password}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "text/html")
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+	visit := func(path string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept", "text/html")
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+
+	// The form posts the password instead of putting it in the URL
+	w := visit("/f/plan?info=true", nil)
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), `method="POST"`) {
+		t.Fatalf("Expected a POST unlock form, got %d %s", w.Code, w.Body.String())
+	}
+	w = visit("/f/plan?info=true&password=wrong-secret", nil)
+	if w.Code != http.StatusUnauthorized || strings.Contains(w.Body.String(), "wrong-secret") {
+		t.Errorf("Expected the form without the password in its action, got %d %s", w.Code, w.Body.String())
+	}
+
+	if w := unlock("wrong-secret"); w.Code != http.StatusUnauthorized || len(w.Result().Cookies()) != 0 {
+		t.Errorf("Expected 401 and no cookie for a wrong password, got %d", w.Code)
+	}
+
+	w = unlock("first-secret")
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/f/plan?info=true" {
+		t.Fatalf("Expected a 303 back to the file, got %d %s", w.Code, w.Header().Get("Location"))
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly {
+		t.Fatalf("Expected an HttpOnly unlock cookie, got %v", cookies)
+	}
+	if w := visit("/f/plan?info=true", cookies); w.Code != http.StatusOK {
+		t.Errorf("Expected the cookie to unlock the file, got %d", w.Code)
+	}
+
+	// Changing the password locks out visitors holding the old cookie
+	second := "second-secret"
+	hashedSecond, err := auth.HashPassword(second)
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	if err := db.UpdateFile(file.ID, user.ID, &models.UpdateFileRequest{Password: &hashedSecond}); err != nil {
+		t.Fatalf("Failed to change password: %v", err)
+	}
+	if w := visit("/f/plan?info=true", cookies); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the old cookie to stop working after a password change, got %d", w.Code)
+	}
+
+	// Scripts may still pass the password as a query parameter
+	if w := doRequest(t, server, http.MethodGet, "/f/plan?info=true&password="+second, "", nil); w.Code != http.StatusOK {
+		t.Errorf("Expected the query password to unlock the file, got %d", w.Code)
+	}
+}
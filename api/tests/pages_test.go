@@ -0,0 +1,108 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"linker/internal/config"
+	"linker/internal/models"
+	"linker/internal/pages"
+)
+
+func renderPage(t *testing.T, renderer *pages.Renderer, page string, domain *models.Domain) (int, string) {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	if err := renderer.Render(recorder, http.StatusNotFound, page, domain, &pages.Data{Message: "Link not found"}); err != nil {
+		t.Fatalf("Failed to render %s page: %v", page, err)
+	}
+	return recorder.Code, recorder.Body.String()
+}
+
+func writeTemplate(t *testing.T, path, content string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("Failed to create template directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write template: %v", err)
+	}
+}
+
+func TestPagesBuiltinBranding(t *testing.T) {
+	renderer := pages.NewRenderer(&config.Config{Environment: "production"})
+
+	domain := &models.Domain{
+		Domain: "go.brand-e.com",
+		Branding: models.DomainBranding{
+			Name:         "Brand <E>",
+			LogoURL:      "javascript:alert(1)",
+			PrimaryColor: "#ff6600",
+			FooterText:   "Footer text",
+		},
+	}
+
+	status, body := renderPage(t, renderer, pages.NotFound, domain)
+	if status != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", status)
+	}
+	for _, want := range []string{"Link not found", "--primary: #ff6600", "Footer text", "Brand &lt;E&gt;"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected page to contain %q, got:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "javascript:") {
+		t.Errorf("Expected unsafe logo URL to be escaped, got:\n%s", body)
+	}
+
+	// Pages render with the default look when there is no domain record
+	if _, body := renderPage(t, renderer, pages.Landing, nil); !strings.Contains(body, "Linker") {
+		t.Errorf("Expected default landing page, got:\n%s", body)
+	}
+
+	recorder := httptest.NewRecorder()
+	if err := renderer.Render(recorder, http.StatusOK, "missing", nil, &pages.Data{}); err == nil {
+		t.Error("Expected an unknown page to fail")
+	}
+	if recorder.Body.Len() != 0 {
+		t.Errorf("Expected nothing written for a failed render, got %q", recorder.Body.String())
+	}
+}
+
+func TestPagesTemplateOverrides(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplate(t, filepath.Join(dir, "not_found.html"), `{{define "content"}}<p>Global: {{.Message}}</p>{{end}}`)
+	writeTemplate(t, filepath.Join(dir, "go.brand-f.com", "not_found.html"), `{{define "content"}}<p>Brand F: {{.Message}}</p>{{end}}`)
+
+	renderer := pages.NewRenderer(&config.Config{Environment: "development", Pages: config.PagesConfig{TemplatesDir: dir}})
+
+	// The built-in layout wraps overridden content
+	if _, body := renderPage(t, renderer, pages.NotFound, nil); !strings.Contains(body, "Global: Link not found") || !strings.Contains(body, "<!DOCTYPE html>") {
+		t.Errorf("Expected the global override in the built-in layout, got:\n%s", body)
+	}
+	if _, body := renderPage(t, renderer, pages.NotFound, &models.Domain{Domain: "go.brand-f.com"}); !strings.Contains(body, "Brand F: Link not found") {
+		t.Errorf("Expected the domain override, got:\n%s", body)
+	}
+	if _, body := renderPage(t, renderer, pages.NotFound, &models.Domain{Domain: ".."}); !strings.Contains(body, "Global: Link not found") {
+		t.Errorf("Expected unsafe host names to be ignored, got:\n%s", body)
+	}
+
+	// Development renderers pick up edits straight away
+	writeTemplate(t, filepath.Join(dir, "layout.html"), `<main>{{template "content" .}}</main>`)
+	if _, body := renderPage(t, renderer, pages.NotFound, nil); body != "<main><p>Global: Link not found</p></main>" {
+		t.Errorf("Expected the edited layout, got:\n%s", body)
+	}
+
+	// Production renderers keep the templates they first loaded
+	production := pages.NewRenderer(&config.Config{Environment: "production", Pages: config.PagesConfig{TemplatesDir: dir}})
+	renderPage(t, production, pages.NotFound, nil)
+	writeTemplate(t, filepath.Join(dir, "layout.html"), `<div>{{template "content" .}}</div>`)
+	if _, body := renderPage(t, production, pages.NotFound, nil); !strings.HasPrefix(body, "<main>") {
+		t.Errorf("Expected cached templates in production, got:\n%s", body)
+	}
+}
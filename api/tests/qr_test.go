@@ -0,0 +1,110 @@
+package tests
+
+import (
+	"bytes"
+	"image/png"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"linker/internal/models"
+	"linker/internal/qr"
+)
+
+func TestQRCodeRendering(t *testing.T) {
+	opts, err := qr.ParseOptions(url.Values{
+		"size":   {"300"},
+		"margin": {"2"},
+		"ecc":    {"h"},
+		"fg":     {"#336699"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to parse options: %v", err)
+	}
+
+	data, err := qr.Render("https://example.com/s/abc123?via=qr", opts)
+	if err != nil {
+		t.Fatalf("Failed to render PNG: %v", err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Rendered PNG does not decode: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 300 || b.Dy() != 300 {
+		t.Errorf("Expected a 300x300 image, got %dx%d", b.Dx(), b.Dy())
+	}
+
+	opts.Format = qr.FormatSVG
+	data, err = qr.Render("https://example.com/s/abc123?via=qr", opts)
+	if err != nil {
+		t.Fatalf("Failed to render SVG: %v", err)
+	}
+	svg := string(data)
+	if !strings.HasPrefix(svg, "<svg") || !strings.Contains(svg, `fill="#336699"`) {
+		t.Errorf("Unexpected SVG output: %.120s", svg)
+	}
+	if opts.ContentType() != "image/svg+xml" {
+		t.Errorf("Expected SVG content type, got %s", opts.ContentType())
+	}
+}
+
+func TestQRCodeOptionValidation(t *testing.T) {
+	invalid := []url.Values{
+		{"format": {"gif"}},
+		{"size": {"10"}},
+		{"margin": {"-1"}},
+		{"ecc": {"X"}},
+		{"fg": {"red"}},
+	}
+
+	for _, values := range invalid {
+		if _, err := qr.ParseOptions(values); err == nil {
+			t.Errorf("Expected %v to be rejected", values)
+		}
+	}
+}
+
+func TestQRCodeCaching(t *testing.T) {
+	db := setupTestDB(t)
+	cfg := testServerConfig()
+	server := newTestServer(t, db, cfg)
+	user := createTestUser(t, db, "qrcacheuser", "qrcache@example.com")
+	token := testToken(t, cfg, user)
+
+	link := createTestLink(t, db, &models.Link{UserID: user.ID, OriginalURL: "https://example.com"})
+	if err := db.CreateShortCode(link.ID, "qrlink", true); err != nil {
+		t.Fatalf("Failed to create short code: %v", err)
+	}
+	file := &models.File{UserID: user.ID, Filename: "qr.pdf", MimeType: "application/pdf", S3Key: "qr", S3Bucket: "b", IsPublic: true}
+	if err := db.CreateFile(file); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if err := db.CreateFileShortCode(file.ID, "qrfile", true); err != nil {
+		t.Fatalf("Failed to create file short code: %v", err)
+	}
+
+	// The owner's QR codes may only be kept by their own browser
+	tests := []struct {
+		path         string
+		token        string
+		cacheControl string
+	}{
+		{"/api/v1/links/" + link.ID + "/qr?format=svg", token, "private, max-age=86400"},
+		{"/api/v1/files/" + file.ID + "/qr?format=svg", token, "private, max-age=86400"},
+		{"/s/qrlink.qr?format=svg", "", "public, max-age=86400"},
+		{"/f/qrfile.qr?format=svg", "", "public, max-age=86400"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := doRequest(t, server, http.MethodGet, tt.path, tt.token, nil)
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+			}
+			if got := w.Header().Get("Cache-Control"); got != tt.cacheControl {
+				t.Errorf("Expected Cache-Control %q, got %q", tt.cacheControl, got)
+			}
+		})
+	}
+}
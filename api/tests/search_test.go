@@ -0,0 +1,154 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"linker/internal/database"
+	"linker/internal/models"
+)
+
+func TestFullTextSearch(t *testing.T) {
+	db := setupTestDB(t)
+	user := createTestUser(t, db, "searchuser", "search@example.com")
+	other := createTestUser(t, db, "otheruser", "other@example.com")
+
+	titled := createTestLink(t, db, &models.Link{UserID: user.ID, OriginalURL: "https://example.com/a", Title: "Kubernetes deployment guide"})
+	described := createTestLink(t, db, &models.Link{UserID: user.ID, OriginalURL: "https://example.com/b", Title: "Notes", Description: "Covers <b>kubernetes</b> basics"})
+	byURL := createTestLink(t, db, &models.Link{UserID: user.ID, OriginalURL: "https://docs.kubernetes.io/start"})
+	createTestLink(t, db, &models.Link{UserID: other.ID, OriginalURL: "https://example.com/c", Title: "Kubernetes for others"})
+
+	file := &models.File{UserID: user.ID, Filename: "x.pdf", OriginalName: "kubernetes-cheatsheet.pdf", MimeType: "application/pdf", S3Key: "x", S3Bucket: "b", IsPublic: true}
+	if err := db.CreateFile(file); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	results, err := db.Search(user.ID, "kube", nil, 20, 0)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("Expected 4 results for the user, got %d", len(results))
+	}
+	if results[0].ID != titled.ID || results[0].Link == nil {
+		t.Errorf("Expected the title match to rank first, got %+v", results[0])
+	}
+	for i := 1; i < len(results); i++ {
+		if results[i].Score > results[i-1].Score {
+			t.Error("Expected results ordered by score")
+		}
+	}
+
+	for _, result := range results {
+		if !strings.Contains(result.Snippet, "<mark>") {
+			t.Errorf("Expected a highlighted snippet, got %q", result.Snippet)
+		}
+		if result.ID == described.ID && strings.Contains(result.Snippet, "<b>") {
+			t.Errorf("Expected stored markup to be escaped, got %q", result.Snippet)
+		}
+	}
+
+	files, err := db.Search(user.ID, "cheatsheet", []string{models.SearchTypeFile}, 20, 0)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(files) != 1 || files[0].File == nil || files[0].File.ID != file.ID {
+		t.Errorf("Expected the file to match its original name, got %+v", files)
+	}
+
+	// Updates and deletes go through the triggers
+	title := "Terraform modules"
+	if err := db.UpdateLink(titled.ID, user.ID, &models.UpdateLinkRequest{Title: &title}); err != nil {
+		t.Fatalf("Failed to update link: %v", err)
+	}
+	if err := db.DeleteLink(byURL.ID, user.ID); err != nil {
+		t.Fatalf("Failed to delete link: %v", err)
+	}
+	fileTitle := "Terraform notes"
+	if err := db.UpdateFile(file.ID, user.ID, &models.UpdateFileRequest{Title: &fileTitle}); err != nil {
+		t.Fatalf("Failed to update file: %v", err)
+	}
+
+	results, err = db.Search(user.ID, "terraform", nil, 20, 0)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 2 {
+		t.Errorf("Expected the renamed link and file, got %d results", len(results))
+	}
+	results, err = db.Search(user.ID, "kubernetes", []string{models.SearchTypeLink}, 20, 0)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 1 || results[0].ID != described.ID {
+		t.Errorf("Expected only the described link to still match, got %+v", results)
+	}
+
+	// Paging works across both kinds of result
+	page, err := db.Search(user.ID, "terraform", nil, 1, 1)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(page) != 1 {
+		t.Errorf("Expected one result on the second page, got %d", len(page))
+	}
+
+	// FTS5 syntax in user input is matched literally rather than failing
+	for _, text := range []string{`"unbalanced`, "NOT OR AND", "title:x", "(a"} {
+		if _, err := db.Search(user.ID, text, nil, 20, 0); err != nil {
+			t.Errorf("Search(%q) failed: %v", text, err)
+		}
+	}
+}
+
+func TestFTSQuery(t *testing.T) {
+	tests := map[string]string{
+		"":              "",
+		"  go  lang ":   `"go"* "lang"*`,
+		`say "hi"`:      `"say"* """hi"""*`,
+		"example.com/x": `"example.com/x"*`,
+	}
+	for text, expected := range tests {
+		if query := database.FTSQuery(text); query != expected {
+			t.Errorf("FTSQuery(%q) = %q, expected %q", text, query, expected)
+		}
+	}
+}
+
+func TestSearchOffsetCap(t *testing.T) {
+	db := setupTestDB(t)
+	cfg := testServerConfig()
+	server := newTestServer(t, db, cfg)
+	user := createTestUser(t, db, "offsetuser", "offset@example.com")
+	token := testToken(t, cfg, user)
+
+	createTestLink(t, db, &models.Link{UserID: user.ID, OriginalURL: "https://example.com/a", Title: "Quarterly report"})
+
+	tests := []struct {
+		query   string
+		results int
+	}{
+		{"q=quarterly", 1},
+		{"q=quarterly&offset=1", 0},
+		{"q=quarterly&offset=999&limit=100", 0},
+		{"q=quarterly&offset=1000", 0},
+		{"q=quarterly&offset=100000000", 0},
+	}
+	for _, tt := range tests {
+		w := doRequest(t, server, http.MethodGet, "/api/v1/search?"+tt.query, token, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200 for %s, got %d: %s", tt.query, w.Code, w.Body.String())
+		}
+		var body struct {
+			Results []models.SearchResult `json:"results"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if len(body.Results) != tt.results {
+			t.Errorf("Expected %d results for %s, got %d", tt.results, tt.query, len(body.Results))
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"linker/internal/api"
+	"linker/internal/auth"
+	"linker/internal/config"
+	"linker/internal/database"
+	"linker/internal/models"
+)
+
+// testServerConfig is the configuration loaded from an empty environment
+func testServerConfig() *config.Config {
+	return &config.Config{
+		DefaultDomain:  "localhost:8080",
+		AllowedDomains: []string{"localhost:8080"},
+		LinkPrefix:     "s",
+		FilePrefix:     "f",
+		JWTSecret:      "test-secret",
+		Analytics:      true,
+		Environment:    "test",
+		ShortCodes:     config.DefaultShortCodeConfig(),
+		URLPolicy:      config.DefaultURLPolicyConfig(),
+		Trash:          config.DefaultTrashConfig(),
+		RedirectCache:  config.DefaultCacheConfig(),
+		Events:         config.DefaultEventsConfig(),
+	}
+}
+
+func newTestServer(t *testing.T, db *database.Database, cfg *config.Config) http.Handler {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = io.Discard
+	return api.NewServer(cfg, db).Handler()
+}
+
+func testToken(t *testing.T, cfg *config.Config, user *models.User) string {
+	t.Helper()
+
+	token, err := auth.GenerateToken(user, cfg.JWTSecret)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	return token
+}
+
+// doRequest sends body as JSON, with token as the bearer token when set
+func doRequest(t *testing.T, handler http.Handler, method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("Failed to encode request body: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	req.Host = "localhost:8080"
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder
+}
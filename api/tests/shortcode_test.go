@@ -0,0 +1,157 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"linker/internal/config"
+	"linker/internal/middleware"
+	"linker/internal/shortcode"
+)
+
+func TestShortCodeGenerator(t *testing.T) {
+	cfg := config.DefaultShortCodeConfig()
+	cfg.Alphabet = shortcode.AlphabetUnambiguous
+	cfg.LinkPrefix = "l_"
+
+	generator, err := shortcode.NewGenerator(&cfg)
+	if err != nil {
+		t.Fatalf("Failed to create generator: %v", err)
+	}
+
+	free := func(string) (bool, error) { return false, nil }
+
+	linkCode, err := generator.LinkCode(free)
+	if err != nil {
+		t.Fatalf("Failed to generate link code: %v", err)
+	}
+	if !strings.HasPrefix(linkCode, "l_") || len(linkCode) != 2+cfg.Length {
+		t.Errorf("Unexpected link code %q", linkCode)
+	}
+	if strings.ContainsAny(strings.TrimPrefix(linkCode, "l_"), "0O1Il") {
+		t.Errorf("Link code %q contains look-alike characters", linkCode)
+	}
+	if !middleware.IsValidShortCode(linkCode) {
+		t.Errorf("Generated link code %q fails short code validation", linkCode)
+	}
+
+	fileCode, err := generator.FileCode(free)
+	if err != nil {
+		t.Fatalf("Failed to generate file code: %v", err)
+	}
+	if !strings.HasPrefix(fileCode, "f-") {
+		t.Errorf("Expected file code %q to keep the f- prefix", fileCode)
+	}
+}
+
+func TestShortCodeGeneratorGrowsOnCollision(t *testing.T) {
+	cfg := config.ShortCodeConfig{
+		Length:    4,
+		MaxLength: 6,
+		Alphabet:  shortcode.AlphabetLowercase,
+		Attempts:  2,
+	}
+
+	generator, err := shortcode.NewGenerator(&cfg)
+	if err != nil {
+		t.Fatalf("Failed to create generator: %v", err)
+	}
+
+	// Every 4 character code is taken, so the generator has to grow
+	calls := 0
+	code, err := generator.LinkCode(func(code string) (bool, error) {
+		calls++
+		return len(code) == 4, nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to generate code: %v", err)
+	}
+	if len(code) != 5 {
+		t.Errorf("Expected a 5 character code after collisions, got %q", code)
+	}
+	if calls != 3 {
+		t.Errorf("Expected 2 collisions before growing, got %d lookups", calls)
+	}
+	if generator.Length() != 5 {
+		t.Errorf("Expected the grown length to be kept, got %d", generator.Length())
+	}
+
+	_, err = generator.LinkCode(func(string) (bool, error) { return true, nil })
+	if err != shortcode.ErrExhausted {
+		t.Errorf("Expected ErrExhausted when every code is taken, got %v", err)
+	}
+	if generator.Length() != 6 {
+		t.Errorf("Expected the length to stop at max_length, got %d", generator.Length())
+	}
+}
+
+func TestShortCodeGeneratorConfigValidation(t *testing.T) {
+	invalid := []config.ShortCodeConfig{
+		{Length: 8, MaxLength: 12, Alphabet: "hex", Attempts: 3},
+		{Length: 8, MaxLength: 6, Alphabet: "base62", Attempts: 3},
+		{Length: 8, MaxLength: 12, Alphabet: "base62", Attempts: 0},
+		{Length: 8, MaxLength: 12, Alphabet: "base62", Attempts: 3, LinkPrefix: "a/"},
+		{Length: 2, MaxLength: 12, Alphabet: "base62", Attempts: 3},
+		{Length: 8, MaxLength: 31, Alphabet: "base62", Attempts: 3, FilePrefix: "f-"},
+	}
+
+	for i, cfg := range invalid {
+		cfg := cfg
+		if _, err := shortcode.NewGenerator(&cfg); err == nil {
+			t.Errorf("Expected config %d to be rejected", i)
+		}
+	}
+}
+
+func TestShortCodeBlocklist(t *testing.T) {
+	denylist := filepath.Join(t.TempDir(), "denylist.txt")
+	if err := os.WriteFile(denylist, []byte("# offensive words\nbadword\nass\n\n# matched anywhere\n*slur\n"), 0644); err != nil {
+		t.Fatalf("Failed to write denylist: %v", err)
+	}
+
+	cfg := config.DefaultShortCodeConfig()
+	cfg.Reserved = []string{"Promo"}
+	cfg.DenylistFile = denylist
+
+	blocklist, err := shortcode.NewBlocklist(&cfg)
+	if err != nil {
+		t.Fatalf("Failed to create blocklist: %v", err)
+	}
+	blocklist.Reserve("links")
+
+	tests := []struct {
+		code     string
+		expected error
+	}{
+		{"admin", shortcode.ErrReserved},
+		{"API", shortcode.ErrReserved},
+		{"promo", shortcode.ErrReserved},
+		{"links", shortcode.ErrReserved},
+		{"badword", shortcode.ErrBlocked},
+		{"my-Bad_Word-2", shortcode.ErrBlocked},
+		{"ass", shortcode.ErrBlocked},
+		{"kick-ass", shortcode.ErrBlocked},
+		{"ass2024", shortcode.ErrBlocked},
+		{"BigAss", shortcode.ErrBlocked},
+		{"xxslurxx", shortcode.ErrBlocked},
+		{"admins", nil},
+		{"summer-sale", nil},
+		// Harmless codes that merely contain a denylisted word
+		{"classic", nil},
+		{"bass-guitar", nil},
+		{"notabadwordsmith", nil},
+	}
+
+	for _, tt := range tests {
+		if err := blocklist.Check(tt.code); err != tt.expected {
+			t.Errorf("Check(%q) = %v, expected %v", tt.code, err, tt.expected)
+		}
+	}
+
+	cfg.DenylistFile = filepath.Join(t.TempDir(), "missing.txt")
+	if _, err := shortcode.NewBlocklist(&cfg); err == nil {
+		t.Error("Expected an error for a missing denylist file")
+	}
+}
@@ -0,0 +1,197 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"linker/internal/config"
+	"linker/internal/models"
+	"linker/internal/storage"
+	"linker/internal/trash"
+)
+
+func TestTrashSoftDelete(t *testing.T) {
+	db := setupTestDB(t)
+	user := createTestUser(t, db, "trashuser", "trash@example.com")
+
+	link := createTestLink(t, db, &models.Link{UserID: user.ID, OriginalURL: "https://example.com/trashed"})
+	if err := db.CreateShortCode(link.ID, "trashme", true); err != nil {
+		t.Fatalf("Failed to create short code: %v", err)
+	}
+	file := &models.File{UserID: user.ID, Filename: "a.pdf", OriginalName: "report.pdf", MimeType: "application/pdf", S3Key: "a", S3Bucket: "b", IsPublic: true}
+	if err := db.CreateFile(file); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if err := db.CreateFileShortCode(file.ID, "f-trashme", true); err != nil {
+		t.Fatalf("Failed to create file short code: %v", err)
+	}
+
+	if err := db.TrashLink(link.ID, user.ID); err != nil {
+		t.Fatalf("Failed to trash link: %v", err)
+	}
+	if err := db.TrashFile(file.ID, user.ID); err != nil {
+		t.Fatalf("Failed to trash file: %v", err)
+	}
+	if err := db.TrashLink(link.ID, user.ID); err != sql.ErrNoRows {
+		t.Errorf("Expected trashing a trashed link to fail with ErrNoRows, got %v", err)
+	}
+
+	// Trashed items stop resolving but keep their short codes reserved
+	if _, err := db.GetLinkByShortCode("trashme", nil); err != sql.ErrNoRows {
+		t.Errorf("Expected trashed link not to resolve, got %v", err)
+	}
+	if _, err := db.GetFileByShortCode("f-trashme", nil); err != sql.ErrNoRows {
+		t.Errorf("Expected trashed file not to resolve, got %v", err)
+	}
+	if _, err := db.GetLinkByID(link.ID, user.ID); err != sql.ErrNoRows {
+		t.Errorf("Expected trashed link to be hidden, got %v", err)
+	}
+	if exists, err := db.ShortCodeExists("trashme", nil); err != nil || !exists {
+		t.Errorf("Expected trashed link's short code to stay reserved, got %v (%v)", exists, err)
+	}
+
+	links, err := db.GetUserLinks(user.ID, &models.ListOptions{Limit: 20})
+	if err != nil {
+		t.Fatalf("Failed to list links: %v", err)
+	}
+	if len(links) != 0 {
+		t.Errorf("Expected trashed link to be left out of listings, got %d links", len(links))
+	}
+
+	trashedLinks, err := db.GetTrashedLinks(user.ID)
+	if err != nil {
+		t.Fatalf("Failed to list trashed links: %v", err)
+	}
+	if len(trashedLinks) != 1 || trashedLinks[0].DeletedAt == nil || len(trashedLinks[0].ShortCodes) != 1 {
+		t.Errorf("Expected the trashed link with its short code, got %v", trashedLinks)
+	}
+
+	cfg := &config.Config{Trash: config.TrashConfig{RetentionDays: 7}}
+	purger := trash.NewPurger(db, nil, cfg)
+
+	if err := db.RestoreLink(link.ID, user.ID, purger.Cutoff(time.Now())); err != nil {
+		t.Fatalf("Failed to restore link: %v", err)
+	}
+	if _, err := db.GetLinkByShortCode("trashme", nil); err != nil {
+		t.Errorf("Expected restored link to resolve, got %v", err)
+	}
+	if err := db.RestoreLink(link.ID, user.ID, purger.Cutoff(time.Now())); err != sql.ErrNoRows {
+		t.Errorf("Expected restoring a live link to fail with ErrNoRows, got %v", err)
+	}
+
+	// Nothing has been in the trash long enough to purge
+	if purged, err := purger.RunOnce(context.Background()); err != nil || purged != 0 {
+		t.Errorf("Expected nothing purged, got %d (%v)", purged, err)
+	}
+
+	if err := db.TrashLink(link.ID, user.ID); err != nil {
+		t.Fatalf("Failed to trash link: %v", err)
+	}
+	old := time.Now().AddDate(0, 0, -8)
+	if _, err := db.Exec(`UPDATE links SET deleted_at = ? WHERE id = ?`, old, link.ID); err != nil {
+		t.Fatalf("Failed to backdate link: %v", err)
+	}
+	if _, err := db.Exec(`UPDATE files SET deleted_at = ? WHERE id = ?`, old, file.ID); err != nil {
+		t.Fatalf("Failed to backdate file: %v", err)
+	}
+
+	// Items past the retention period can't be restored any more
+	if err := db.RestoreFile(file.ID, user.ID, purger.Cutoff(time.Now())); err != sql.ErrNoRows {
+		t.Errorf("Expected restoring an expired file to fail with ErrNoRows, got %v", err)
+	}
+
+	// Without storage the file's object can't be removed, so it stays put
+	if purged, err := purger.RunOnce(context.Background()); err != nil || purged != 1 {
+		t.Errorf("Expected only the link purged, got %d (%v)", purged, err)
+	}
+	if exists, err := db.ShortCodeExists("trashme", nil); err != nil || exists {
+		t.Errorf("Expected purged link's short code to be released, got %v (%v)", exists, err)
+	}
+	if _, err := db.GetTrashedFile(file.ID, user.ID); err != nil {
+		t.Errorf("Expected the file to stay in the trash without storage, got %v", err)
+	}
+	if err := purger.PurgeFile(context.Background(), file); !errors.Is(err, trash.ErrStorageUnavailable) {
+		t.Errorf("Expected ErrStorageUnavailable purging a file without storage, got %v", err)
+	}
+
+	store, deleted := fakeStorage(t)
+	purger = trash.NewPurger(db, store, cfg)
+	if purged, err := purger.RunOnce(context.Background()); err != nil || purged != 1 {
+		t.Errorf("Expected the file purged, got %d (%v)", purged, err)
+	}
+	if _, err := db.GetTrashedFile(file.ID, user.ID); err != sql.ErrNoRows {
+		t.Errorf("Expected purged file to be gone, got %v", err)
+	}
+	if len(*deleted) != 1 || (*deleted)[0] != "a" {
+		t.Errorf("Expected the file's stored object deleted, got %v", *deleted)
+	}
+}
+
+// fakeStorage is a storage client backed by a local server that accepts
+// every request, recording the keys of deleted objects
+func fakeStorage(t *testing.T) (*storage.S3Client, *[]string) {
+	t.Helper()
+
+	var mu sync.Mutex
+	deleted := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			mu.Lock()
+			deleted = append(deleted, strings.TrimPrefix(r.URL.Path, "/test-bucket/"))
+			mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	store, err := storage.NewS3Client(&config.S3Config{
+		Enabled:         true,
+		Endpoint:        strings.TrimPrefix(server.URL, "http://"),
+		Region:          "us-east-1",
+		AccessKeyID:     "test",
+		SecretAccessKey: "test",
+		BucketName:      "test-bucket",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create storage client: %v", err)
+	}
+	return store, &deleted
+}
+
+func TestEmptyTrash(t *testing.T) {
+	db := setupTestDB(t)
+	user := createTestUser(t, db, "emptyuser", "empty@example.com")
+	other := createTestUser(t, db, "otheruser", "other@example.com")
+
+	mine := createTestLink(t, db, &models.Link{UserID: user.ID, OriginalURL: "https://example.com/mine"})
+	theirs := createTestLink(t, db, &models.Link{UserID: other.ID, OriginalURL: "https://example.com/theirs"})
+	for _, link := range []*models.Link{mine, theirs} {
+		if err := db.TrashLink(link.ID, link.UserID); err != nil {
+			t.Fatalf("Failed to trash link: %v", err)
+		}
+	}
+
+	if err := db.PurgeLink(theirs.ID, user.ID); err != sql.ErrNoRows {
+		t.Errorf("Expected purging another user's link to fail with ErrNoRows, got %v", err)
+	}
+
+	purger := trash.NewPurger(db, nil, &config.Config{})
+	if purged, err := purger.Empty(context.Background(), user.ID); err != nil || purged != 1 {
+		t.Errorf("Expected one item purged, got %d (%v)", purged, err)
+	}
+
+	remaining, err := db.GetTrashedLinks(other.ID)
+	if err != nil {
+		t.Fatalf("Failed to list trashed links: %v", err)
+	}
+	if len(remaining) != 1 {
+		t.Errorf("Expected the other user's trash to be untouched, got %d links", len(remaining))
+	}
+}
@@ -0,0 +1,115 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"linker/internal/config"
+	"linker/internal/urlpolicy"
+)
+
+func testPolicyConfig(t *testing.T) *config.Config {
+	denylist := filepath.Join(t.TempDir(), "hosts")
+	hosts := "# blocked destinations\n0.0.0.0 malware.example phish.example # trailing comment\nbare.example\n"
+	if err := os.WriteFile(denylist, []byte(hosts), 0644); err != nil {
+		t.Fatalf("Failed to write denylist: %v", err)
+	}
+
+	return &config.Config{
+		DefaultDomain:  "sho.rt",
+		AllowedDomains: []string{"sho.rt", "go.example.com"},
+		LinkPrefix:     "s",
+		URLPolicy: config.URLPolicyConfig{
+			AllowedSchemes:       []string{"http", "https"},
+			DenylistFile:         denylist,
+			MaxChainHops:         1,
+			BlockPrivateNetworks: true,
+		},
+	}
+}
+
+func reasonOf(err error) string {
+	if violation, ok := err.(*urlpolicy.Violation); ok {
+		return violation.Reason
+	}
+	if err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+func TestURLPolicyChecks(t *testing.T) {
+	policy, err := urlpolicy.New(testPolicyConfig(t))
+	if err != nil {
+		t.Fatalf("Failed to create policy: %v", err)
+	}
+
+	tests := []struct {
+		url    string
+		reason string
+	}{
+		{"https://93.184.216.34/page", ""},
+		{"javascript:alert(1)", urlpolicy.ReasonSchemeNotAllowed},
+		{"data:text/html,<script>alert(1)</script>", urlpolicy.ReasonSchemeNotAllowed},
+		{"FTP://files.example/archive.zip", urlpolicy.ReasonSchemeNotAllowed},
+		{"https://MALWARE.example/login", urlpolicy.ReasonHostDenied},
+		{"http://phish.example.:8080/", urlpolicy.ReasonHostDenied},
+		{"https://bare.example", urlpolicy.ReasonHostDenied},
+		{"http://127.0.0.1:6379/", urlpolicy.ReasonPrivateNetwork},
+		{"http://10.0.0.8/admin", urlpolicy.ReasonPrivateNetwork},
+		{"http://192.168.1.1/", urlpolicy.ReasonPrivateNetwork},
+		{"http://169.254.169.254/latest/meta-data", urlpolicy.ReasonPrivateNetwork},
+		{"http://[::1]/", urlpolicy.ReasonPrivateNetwork},
+		{"http://app.localhost/", urlpolicy.ReasonPrivateNetwork},
+		{"/relative/path", urlpolicy.ReasonInvalidURL},
+	}
+
+	for _, tt := range tests {
+		if reason := reasonOf(policy.Check(tt.url, "", nil)); reason != tt.reason {
+			t.Errorf("Check(%q) reason = %q, expected %q", tt.url, reason, tt.reason)
+		}
+	}
+}
+
+func TestURLPolicyRedirectChains(t *testing.T) {
+	policy, err := urlpolicy.New(testPolicyConfig(t))
+	if err != nil {
+		t.Fatalf("Failed to create policy: %v", err)
+	}
+
+	// a -> b -> external, c -> a, loop -> loop
+	links := map[string][2]string{
+		"a":    {"link-a", "https://sho.rt/s/b"},
+		"b":    {"link-b", "https://93.184.216.34/"},
+		"c":    {"link-c", "https://go.example.com/s/a"},
+		"loop": {"link-loop", "https://sho.rt/s/loop"},
+	}
+	resolve := func(host, shortCode string) (string, string, error) {
+		link, ok := links[shortCode]
+		if !ok {
+			return "", "", nil
+		}
+		return link[0], link[1], nil
+	}
+
+	tests := []struct {
+		url    string
+		linkID string
+		reason string
+	}{
+		{"https://sho.rt/s/b", "", ""},
+		{"https://sho.rt/s/unknown", "", ""},
+		{"https://sho.rt/f/some-file", "", ""},
+		{"https://sho.rt/s/a", "", urlpolicy.ReasonChainTooLong},
+		{"https://go.example.com/s/c/extra", "", urlpolicy.ReasonChainTooLong},
+		{"https://sho.rt/s/b", "link-b", urlpolicy.ReasonRedirectLoop},
+		{"https://sho.rt/s/loop", "", urlpolicy.ReasonRedirectLoop},
+	}
+
+	for _, tt := range tests {
+		if reason := reasonOf(policy.Check(tt.url, tt.linkID, resolve)); reason != tt.reason {
+			t.Errorf("Check(%q, %q) reason = %q, expected %q", tt.url, tt.linkID, reason, tt.reason)
+		}
+	}
+}
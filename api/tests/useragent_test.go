@@ -0,0 +1,55 @@
+package tests
+
+import (
+	"reflect"
+	"testing"
+
+	"linker/internal/utils"
+)
+
+func TestParsePlatform(t *testing.T) {
+	cases := map[string]string{
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15":     utils.PlatformIOS,
+		"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 Chrome/120.0 Mobile": utils.PlatformAndroid,
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 Chrome/120.0":       utils.PlatformWindows,
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 14_0) AppleWebKit/605.1.15 Version/17.0":  utils.PlatformMacOS,
+		"Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0":  utils.PlatformLinux,
+		"curl/8.4.0": utils.PlatformOther,
+	}
+
+	for ua, want := range cases {
+		if got := utils.ParsePlatform(ua); got != want {
+			t.Errorf("ParsePlatform(%q) = %q, want %q", ua, got, want)
+		}
+	}
+}
+
+func TestParseAcceptLanguage(t *testing.T) {
+	got := utils.ParseAcceptLanguage("fr-CH, fr;q=0.9, en;q=0.8, de;q=0, *;q=0.5")
+	want := []string{"fr-ch", "fr", "en"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseAcceptLanguage = %v, want %v", got, want)
+	}
+
+	if got := utils.ParseAcceptLanguage(""); len(got) != 0 {
+		t.Errorf("Expected no languages for empty header, got %v", got)
+	}
+}
+
+func TestIsSocialCrawler(t *testing.T) {
+	crawlers := []string{
+		"Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)",
+		"Twitterbot/1.0",
+		"Mozilla/5.0 (compatible; Discordbot/2.0; +https://discordapp.com)",
+		"facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)",
+	}
+	for _, ua := range crawlers {
+		if !utils.IsSocialCrawler(ua) {
+			t.Errorf("Expected %q to be detected as a crawler", ua)
+		}
+	}
+
+	if utils.IsSocialCrawler("Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/120.0") {
+		t.Error("Expected a desktop browser not to be detected as a crawler")
+	}
+}